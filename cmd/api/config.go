@@ -0,0 +1,437 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/captainmango/greenlight/internal/validator"
+	"gopkg.in/yaml.v3"
+)
+
+type config struct {
+	port int
+	env  string
+	log  struct {
+		level string
+	}
+	grpc struct {
+		port int
+	}
+	db struct {
+		dsn          string
+		maxOpenConns int
+		maxIdleConns int
+		maxIdleTime  time.Duration
+	}
+	trash struct {
+		retention     time.Duration
+		purgeInterval time.Duration
+	}
+	idempotency struct {
+		ttl           time.Duration
+		purgeInterval time.Duration
+	}
+	jobs struct {
+		workers      int
+		pollInterval time.Duration
+	}
+	webhooks struct {
+		dispatchInterval time.Duration
+		retention        time.Duration
+		purgeInterval    time.Duration
+	}
+	websockets struct {
+		maxConnections int
+		allowedOrigins string
+	}
+	locks struct {
+		ttl          time.Duration
+		maxTTL       time.Duration
+		reapInterval time.Duration
+	}
+
+	// file is the config file the settings were read from, if there was one. With
+	// watchInterval set, it is checked for changes that often and reloaded.
+	file          string
+	watchInterval time.Duration
+	// values is every setting with where it came from, secrets redacted.
+	values []configValue
+}
+
+// A setting is one config value. In increasing priority it comes from its default, the
+// config file, a GREENLIGHT_ environment variable or its flag. Every layer is parsed by
+// the flag's Set, so a value means the same thing wherever it is written.
+//
+// Reloadable settings can change while the API is running (see reloadConfig), so
+// they must be read from a.config() each time they are used rather than copied.
+type setting struct {
+	// key is the name in the config file, like "db.max_open_conns". The environment
+	// variable is the key in capitals with _ for ., like GREENLIGHT_DB_MAX_OPEN_CONNS.
+	key  string
+	flag string
+	// legacyEnv is an older environment variable that is still read, below the
+	// GREENLIGHT_ one.
+	legacyEnv  string
+	secret     bool
+	reloadable bool
+}
+
+// configValue is a setting as -print-config shows it.
+type configValue struct {
+	Key        string `json:"key"`
+	Value      any    `json:"value"`
+	Source     string `json:"source"`
+	Reloadable bool   `json:"reloadable"`
+	// raw is the unredacted value in the form the flag parses.
+	raw string
+}
+
+type settings struct {
+	fs   *flag.FlagSet
+	list []*setting
+}
+
+func (s *settings) add(key, name string) *setting {
+	setting := &setting{key: key, flag: name}
+	s.list = append(s.list, setting)
+	return setting
+}
+
+func (s *settings) intVar(p *int, key, name string, value int, usage string) *setting {
+	s.fs.IntVar(p, name, value, usage)
+	return s.add(key, name)
+}
+
+func (s *settings) stringVar(p *string, key, name string, value string, usage string) *setting {
+	s.fs.StringVar(p, name, value, usage)
+	return s.add(key, name)
+}
+
+func (s *settings) durationVar(p *time.Duration, key, name string, value time.Duration, usage string) *setting {
+	s.fs.DurationVar(p, name, value, usage)
+	return s.add(key, name)
+}
+
+// settings registers a flag for every setting on fs, with its default.
+func (cfg *config) settings(fs *flag.FlagSet) []*setting {
+	s := &settings{fs: fs}
+
+	s.intVar(&cfg.port, "port", "port", 4000, "API server port")
+	s.intVar(&cfg.grpc.port, "grpc.port", "grpc-port", 4001, "gRPC server port (0 to disable)")
+	s.stringVar(&cfg.env, "env", "env", "development", "Environment (development|staging|production)").legacyEnv = "ENVIRONMENT"
+	s.stringVar(&cfg.log.level, "log.level", "log-level", "info", "Log level (debug|info|warn|error)").reloadable = true
+	s.durationVar(&cfg.watchInterval, "config.watch_interval", "config-watch-interval", 0, "How often to check the config file for changes (0 to only reload on SIGHUP)")
+
+	dsn := s.stringVar(&cfg.db.dsn, "db.dsn", "db-dsn", "", "PostgreSQL DSN")
+	dsn.legacyEnv = "PG_DSN"
+	dsn.secret = true
+
+	// Any of the pool settings being 0 means there is no limit.
+	s.intVar(&cfg.db.maxOpenConns, "db.max_open_conns", "db-max-open-conns", 25, "PostgreSQL max open connections")
+	s.intVar(&cfg.db.maxIdleConns, "db.max_idle_conns", "db-max-idle-conns", 25, "PostgreSQL max idle connections")
+	s.durationVar(&cfg.db.maxIdleTime, "db.max_idle_time", "db-max-idle-time", 15*time.Minute, "PostgreSQL max connection idle time")
+
+	// Soft-deleted movies are kept in the trash for this long before being purged.
+	s.durationVar(&cfg.trash.retention, "trash.retention", "trash-retention", 30*24*time.Hour, "How long deleted movies stay in the trash").reloadable = true
+	s.durationVar(&cfg.trash.purgeInterval, "trash.purge_interval", "trash-purge-interval", time.Hour, "How often to purge expired movies from the trash")
+
+	// Responses stored against an Idempotency-Key are replayed for this long.
+	s.durationVar(&cfg.idempotency.ttl, "idempotency.ttl", "idempotency-ttl", 24*time.Hour, "How long Idempotency-Key responses are kept").reloadable = true
+	s.durationVar(&cfg.idempotency.purgeInterval, "idempotency.purge_interval", "idempotency-purge-interval", time.Hour, "How often to delete expired Idempotency-Keys")
+
+	// Background jobs run inside the API process unless this is 0, in which case they
+	// are left to `api worker` processes.
+	s.intVar(&cfg.jobs.workers, "jobs.workers", "jobs-workers", 2, "Number of in-process background job workers")
+	s.durationVar(&cfg.jobs.pollInterval, "jobs.poll_interval", "jobs-poll-interval", time.Second, "How often idle workers check for queued jobs")
+
+	// Movie events wait in the outbox for at most dispatchInterval before being queued
+	// for delivery, and are kept (with their delivery log) for the retention period.
+	s.durationVar(&cfg.webhooks.dispatchInterval, "webhooks.dispatch_interval", "webhooks-dispatch-interval", time.Second, "How often to queue webhook deliveries for new movie events")
+	s.durationVar(&cfg.webhooks.retention, "webhooks.retention", "webhooks-retention", 30*24*time.Hour, "How long movie events and webhook deliveries are kept").reloadable = true
+	s.durationVar(&cfg.webhooks.purgeInterval, "webhooks.purge_interval", "webhooks-purge-interval", time.Hour, "How often to delete expired movie events")
+
+	s.intVar(&cfg.websockets.maxConnections, "websockets.max_connections", "ws-max-connections", 1000, "Maximum number of open WebSocket connections")
+	s.stringVar(&cfg.websockets.allowedOrigins, "websockets.allowed_origins", "ws-allowed-origins", "", "Space-separated origins (like https://app.example.com) browsers may open WebSocket connections from, besides the API's own").reloadable = true
+
+	// Edit locks last for ttl unless the client asks for something else (up to maxTTL).
+	s.durationVar(&cfg.locks.ttl, "locks.ttl", "locks-ttl", 5*time.Minute, "Default lease on a movie edit lock").reloadable = true
+	s.durationVar(&cfg.locks.maxTTL, "locks.max_ttl", "locks-max-ttl", time.Hour, "Longest lease a client can ask for on a movie edit lock").reloadable = true
+	s.durationVar(&cfg.locks.reapInterval, "locks.reap_interval", "locks-reap-interval", 30*time.Second, "How often to delete expired movie edit locks")
+
+	return s.list
+}
+
+func validateConfig(v *validator.Validator, cfg config) {
+	v.Check(cfg.port > 0 && cfg.port <= 65535, "port", "must be a valid port")
+	v.Check(cfg.grpc.port >= 0 && cfg.grpc.port <= 65535, "grpc.port", "must be a valid port, or 0")
+	v.Check(cfg.grpc.port != cfg.port, "grpc.port", "must not be the same as port")
+	v.Check(validator.PermittedValue(cfg.env, "development", "staging", "production"), "env", "must be development, staging or production")
+	v.Check(validator.PermittedValue(cfg.log.level, "debug", "info", "warn", "error"), "log.level", "must be debug, info, warn or error")
+	v.Check(cfg.watchInterval >= 0, "config.watch_interval", "must not be negative")
+
+	v.Check(cfg.db.dsn != "", "db.dsn", "must be provided")
+	v.Check(cfg.db.maxOpenConns >= 0, "db.max_open_conns", "must not be negative")
+	v.Check(cfg.db.maxIdleConns >= 0, "db.max_idle_conns", "must not be negative")
+	v.Check(cfg.db.maxIdleTime >= 0, "db.max_idle_time", "must not be negative")
+
+	v.Check(cfg.trash.retention > 0, "trash.retention", "must be positive")
+	v.Check(cfg.trash.purgeInterval > 0, "trash.purge_interval", "must be positive")
+	v.Check(cfg.idempotency.ttl > 0, "idempotency.ttl", "must be positive")
+	v.Check(cfg.idempotency.purgeInterval > 0, "idempotency.purge_interval", "must be positive")
+	v.Check(cfg.jobs.workers >= 0, "jobs.workers", "must not be negative")
+	v.Check(cfg.jobs.pollInterval > 0, "jobs.poll_interval", "must be positive")
+	v.Check(cfg.webhooks.dispatchInterval > 0, "webhooks.dispatch_interval", "must be positive")
+	v.Check(cfg.webhooks.retention > 0, "webhooks.retention", "must be positive")
+	v.Check(cfg.webhooks.purgeInterval > 0, "webhooks.purge_interval", "must be positive")
+	v.Check(cfg.websockets.maxConnections > 0, "websockets.max_connections", "must be positive")
+
+	for _, origin := range strings.Fields(cfg.websockets.allowedOrigins) {
+		u, err := url.Parse(origin)
+		if err != nil || !validator.PermittedValue(u.Scheme, "http", "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			v.AddError("websockets.allowed_origins", fmt.Sprintf("%q is not an origin like https://app.example.com", origin))
+			break
+		}
+	}
+
+	v.Check(cfg.locks.ttl > 0, "locks.ttl", "must be positive")
+	v.Check(cfg.locks.maxTTL >= cfg.locks.ttl, "locks.max_ttl", "must not be less than locks.ttl")
+	v.Check(cfg.locks.reapInterval > 0, "locks.reap_interval", "must be positive")
+}
+
+// loadConfig builds the config from the defaults, the file named by -config or
+// GREENLIGHT_CONFIG_FILE, GREENLIGHT_ environment variables and the flags in args, in
+// that order. It also reports whether -print-config was given.
+func loadConfig(name string, args []string) (config, bool, error) {
+	var cfg config
+	var printConfig bool
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&cfg.file, "config", os.Getenv("GREENLIGHT_CONFIG_FILE"), "YAML or TOML config file")
+	fs.BoolVar(&printConfig, "print-config", false, "Print the config, with secrets redacted, and exit")
+	settings := cfg.settings(fs)
+
+	// The flags are parsed first to find the config file, and they win over everything
+	// else, so only the settings they didn't set are looked up below.
+	fs.Parse(args)
+
+	fromFlags := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		fromFlags[f.Name] = true
+	})
+
+	var file map[string]string
+	if cfg.file != "" {
+		var err error
+
+		file, err = readConfigFile(cfg.file)
+		if err != nil {
+			return config{}, false, err
+		}
+	}
+
+	v := validator.New()
+
+	for _, s := range settings {
+		source := "default"
+
+		if fromFlags[s.flag] {
+			source = "flag -" + s.flag
+		} else if value, from, ok := s.lookup(file); ok {
+			source = from
+
+			err := fs.Set(s.flag, value)
+			if err != nil {
+				v.AddError(s.key, fmt.Sprintf("invalid value %q from %s", value, source))
+			}
+		}
+
+		delete(file, s.key)
+
+		cfg.values = append(cfg.values, s.configValue(fs, source))
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(file)) {
+		v.AddError(key, fmt.Sprintf("is not a setting (in %s)", cfg.file))
+	}
+
+	if validateConfig(v, cfg); !v.Valid() {
+		return config{}, false, configError(v.Errors)
+	}
+
+	return cfg, printConfig, nil
+}
+
+// lookup finds the value for a setting that wasn't given as a flag, and where it came
+// from.
+func (s *setting) lookup(file map[string]string) (string, string, bool) {
+	env := "GREENLIGHT_" + strings.ToUpper(strings.ReplaceAll(s.key, ".", "_"))
+
+	if value, ok := os.LookupEnv(env); ok {
+		return value, "env " + env, true
+	}
+
+	if s.legacyEnv != "" {
+		if value, ok := os.LookupEnv(s.legacyEnv); ok {
+			return value, "env " + s.legacyEnv, true
+		}
+	}
+
+	if value, ok := file[s.key]; ok {
+		return value, "file", true
+	}
+
+	return "", "", false
+}
+
+// configValue reads the setting's current value from its flag. The printed value has
+// secrets redacted.
+func (s *setting) configValue(fs *flag.FlagSet, source string) configValue {
+	f := fs.Lookup(s.flag)
+
+	value := f.Value.(flag.Getter).Get()
+
+	switch v := value.(type) {
+	case time.Duration:
+		value = v.String()
+	case string:
+		if s.secret && v != "" {
+			value = redact(v)
+		}
+	}
+
+	return configValue{Key: s.key, Value: value, Source: source, Reloadable: s.reloadable, raw: f.Value.String()}
+}
+
+// logLevel is the level log.level names. It has been validated, so it always parses.
+func (cfg *config) logLevel() slog.Level {
+	var level slog.Level
+	level.UnmarshalText([]byte(cfg.log.level))
+
+	return level
+}
+
+// redact hides the password in a URL-style DSN, or the whole value otherwise.
+func redact(value string) string {
+	u, err := url.Parse(value)
+	if err == nil && u.Scheme != "" && u.Host != "" {
+		return u.Redacted()
+	}
+
+	return "REDACTED"
+}
+
+// readConfigFile reads a YAML or TOML file (by its extension) into a map of dotted
+// keys, so that
+//
+//	db:
+//	  max_open_conns: 50
+//
+// becomes "db.max_open_conns": "50".
+func readConfigFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := map[string]any{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &tree)
+	case ".toml":
+		err = toml.Unmarshal(content, &tree)
+	default:
+		return nil, fmt.Errorf("%s: config files must be .yaml, .yml or .toml", path)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	values := map[string]string{}
+
+	var flatten func(prefix string, tree map[string]any)
+	flatten = func(prefix string, tree map[string]any) {
+		for key, value := range tree {
+			switch value := value.(type) {
+			case map[string]any:
+				flatten(prefix+key+".", value)
+			default:
+				values[prefix+key] = fmt.Sprint(value)
+			}
+		}
+	}
+	flatten("", tree)
+
+	return values, nil
+}
+
+// writeConfig prints the values as a YAML config file, with where each one came from
+// as a comment.
+func writeConfig(w io.Writer, values []configValue) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+
+	for _, value := range values {
+		parts := strings.Split(value.Key, ".")
+
+		node := root
+		for _, part := range parts[:len(parts)-1] {
+			node = yamlChild(node, part)
+		}
+
+		var scalar yaml.Node
+		if err := scalar.Encode(value.Value); err != nil {
+			return err
+		}
+		scalar.LineComment = value.Source
+		if value.Reloadable {
+			scalar.LineComment += ", reloadable"
+		}
+
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: parts[len(parts)-1]}, &scalar)
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+
+	return enc.Close()
+}
+
+// yamlChild returns the mapping under key in node, adding it if it isn't there yet.
+func yamlChild(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	child := &yaml.Node{Kind: yaml.MappingNode}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, child)
+
+	return child
+}
+
+// configError lists every problem with the config in one error.
+func configError(errs validator.ValidationErrors) error {
+	problems := make([]string, 0, len(errs))
+	for key, message := range errs {
+		problems = append(problems, key+" "+message)
+	}
+	slices.Sort(problems)
+
+	return errors.New("invalid config: " + strings.Join(problems, "; "))
+}
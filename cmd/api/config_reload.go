@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/captainmango/greenlight/internal/validator"
+)
+
+// maxConfigReloads is how many reloads the history keeps.
+const maxConfigReloads = 50
+
+// configReloader reads the config again with the arguments the API started with.
+type configReloader struct {
+	name string
+	args []string
+
+	// mu stops two reloads running at once, and guards history.
+	mu      sync.Mutex
+	history []configReload
+}
+
+// configReload is one entry in the reload history.
+type configReload struct {
+	Time    time.Time `json:"time"`
+	Trigger string    `json:"trigger"`
+	// Changed lists the settings that took effect, and Ignored the changed ones that
+	// need a restart.
+	Changed []string `json:"changed"`
+	Ignored []string `json:"ignored"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// config returns the config as of the last reload. Callers shouldn't keep it, or the
+// settings they read from it won't change on the next reload.
+func (a *application) config() *config {
+	return a.current.Load()
+}
+
+// reloadConfig reads the config file and environment again and swaps in the reloadable
+// settings. Other settings that changed are logged and left alone until a restart. If
+// the new config is invalid, nothing changes.
+func (a *application) reloadConfig(trigger string) configReload {
+	a.reloader.mu.Lock()
+	defer a.reloader.mu.Unlock()
+
+	reload := configReload{Time: time.Now(), Trigger: trigger, Changed: []string{}, Ignored: []string{}}
+
+	next, _, err := loadConfig(a.reloader.name, a.reloader.args)
+	if err == nil {
+		next, reload.Changed, reload.Ignored, err = mergeConfig(a.config(), &next)
+	}
+
+	if err != nil {
+		reload.Error = err.Error()
+		a.logger.Error("failed to reload config", "trigger", trigger, "error", reload.Error)
+	} else {
+		a.current.Store(&next)
+		a.logLevel.Set(next.logLevel())
+
+		for _, key := range reload.Ignored {
+			a.logger.Warn("ignored config change that needs a restart", "trigger", trigger, "setting", key)
+		}
+		a.logger.Info("reloaded config", "trigger", trigger, "changed", reload.Changed)
+	}
+
+	a.reloader.history = append(a.reloader.history, reload)
+	if len(a.reloader.history) > maxConfigReloads {
+		a.reloader.history = slices.Delete(a.reloader.history, 0, len(a.reloader.history)-maxConfigReloads)
+	}
+
+	return reload
+}
+
+// mergeConfig takes the reloadable settings from next and everything else from
+// current. It returns the keys of the reloadable settings that changed, and of the
+// other settings that changed and were ignored.
+func mergeConfig(current, next *config) (config, []string, []string, error) {
+	var merged config
+	changed, ignored := []string{}, []string{}
+
+	// The merged config is built through the same flags as loadConfig, setting each
+	// one to the value taken from current or next.
+	fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+
+	for i, s := range merged.settings(fs) {
+		value := current.values[i]
+
+		if next.values[i].raw != value.raw {
+			if s.reloadable {
+				changed = append(changed, s.key)
+			} else {
+				ignored = append(ignored, s.key)
+			}
+		}
+
+		if s.reloadable {
+			value = next.values[i]
+		}
+
+		err := fs.Set(s.flag, value.raw)
+		if err != nil {
+			return config{}, nil, nil, err
+		}
+
+		merged.values = append(merged.values, value)
+	}
+
+	merged.file = current.file
+
+	v := validator.New()
+	if validateConfig(v, merged); !v.Valid() {
+		return config{}, nil, nil, configError(v.Errors)
+	}
+
+	return merged, changed, ignored, nil
+}
+
+// watchConfig reloads the config on SIGHUP and, if config.watch_interval is set, when
+// the config file changes. It returns when ctx is done.
+func (a *application) watchConfig(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	cfg := a.config()
+
+	// A nil channel never receives, so without a file to watch only SIGHUP reloads.
+	var tick <-chan time.Time
+	var modTime time.Time
+
+	if cfg.file != "" && cfg.watchInterval > 0 {
+		ticker := time.NewTicker(cfg.watchInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+
+		modTime = configModTime(cfg.file)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			a.reloadConfig("SIGHUP")
+		case <-tick:
+			// Editors often save by replacing the file, so this compares times rather
+			// than watching the file itself.
+			latest := configModTime(cfg.file)
+			if !latest.Equal(modTime) {
+				modTime = latest
+				a.reloadConfig("file changed")
+			}
+		}
+	}
+}
+
+// configModTime is when the file was last changed, or the zero time if it can't be
+// read (in which case the reload reports why).
+func configModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime()
+}
+
+// showConfigHandler returns the config the API is running with, secrets redacted, and
+// the reload history, newest first.
+func (a *application) showConfigHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := a.config()
+
+	a.reloader.mu.Lock()
+	reloads := slices.Clone(a.reloader.history)
+	a.reloader.mu.Unlock()
+
+	slices.Reverse(reloads)
+
+	err := a.writeJSON(w, http.StatusOK, envelope{"config": envelope{"file": cfg.file, "settings": cfg.values}, "reloads": reloads}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/captainmango/greenlight/internal/data"
+)
+
+// Use a custom type for the context key so it can't collide with keys set by any
+// third-party packages.
+type contextKey string
+
+const userContextKey = contextKey("user")
+
+func (a *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// contextGetUser should only be called when we expect there to be a user in the
+// context (the authenticate middleware always sets one), so a missing value is a
+// programming error and we panic.
+func (a *application) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+
+	return user
+}
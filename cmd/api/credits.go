@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/captainmango/greenlight/internal/data"
+	"github.com/captainmango/greenlight/internal/validator"
+)
+
+func (a *application) getMovieCreditsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIdParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	// Fetch the movie first so an unknown ID is a 404 rather than an empty list.
+	movie, err := a.dao.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	credits, err := a.dao.Credits.GetForMovie(movie.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"credits": credits}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *application) createMovieCreditHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		PersonID     int64  `json:"person_id"`
+		Role         string `json:"role"`
+		Character    string `json:"character,omitempty"`
+		BillingOrder int32  `json:"billing_order"`
+	}
+
+	id, err := a.readIdParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	credit := &data.Credit{
+		MovieID:      id,
+		PersonID:     input.PersonID,
+		Role:         input.Role,
+		Character:    input.Character,
+		BillingOrder: input.BillingOrder,
+	}
+
+	v := validator.New()
+
+	if data.ValidateCredit(v, credit); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.dao.Credits.Insert(credit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateCredit):
+			v.AddError("person_id", "this person already has this credit on the movie")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusCreated, envelope{"credit": credit}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *application) deleteMovieCreditHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := a.readIdParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	creditID, err := a.readInt64Param(r, "credit_id")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.dao.Credits.Delete(movieID, creditID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"message": "successfully deleted credit"}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -3,6 +3,9 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"time"
+
+	"github.com/captainmango/greenlight/internal/data"
 )
 
 func (app *application) logError(r *http.Request, err error) {
@@ -63,3 +66,73 @@ func (app *application) editConfilctResponse(w http.ResponseWriter, r *http.Requ
 	message := "unable to update the record due to an edit conflict, please try again"
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
+
+func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid authentication credentials"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "invalid or missing authentication token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you must be authenticated to access this resource"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("the %q content type is not supported for this resource", r.Header.Get("Content-Type"))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+func (app *application) patchTestFailedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusConflict, err.Error())
+}
+
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this request must say which version it replaces, with an If-Match header or a version field"
+	app.errorResponse(w, r, http.StatusPreconditionRequired, message)
+}
+
+func (app *application) idempotencyKeyReusedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this Idempotency-Key has already been used for a different request"
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, message)
+}
+
+func (app *application) idempotencyInFlightResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a request with this Idempotency-Key is still being processed, please try again later"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) originNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("connections from the origin %q are not allowed", r.Header.Get("Origin"))
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) tooManyConnectionsResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "30")
+	message := "the server has too many open connections, please try again later"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
+func (app *application) movieLockedResponse(w http.ResponseWriter, r *http.Request, lock *data.MovieLock) {
+	env := envelope{
+		"error": fmt.Sprintf("this movie is locked for editing by %s until %s", lock.UserName, lock.ExpiresAt.UTC().Format(time.RFC3339)),
+		"lock":  lock,
+	}
+
+	err := app.writeJSON(w, http.StatusLocked, env, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/captainmango/greenlight/internal/data"
+	"github.com/captainmango/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+const (
+	// eventsHeartbeat is how often an idle stream gets a comment line, which keeps
+	// proxies from closing it and tells us when the client has gone.
+	eventsHeartbeat = 15 * time.Second
+
+	// eventsWriteDeadline replaces the server's WriteTimeout for streams. It's pushed
+	// back on every write, so only a client that stops reading gets cut off.
+	eventsWriteDeadline = eventsHeartbeat + 10*time.Second
+
+	// maxEventReplay caps how many missed events are replayed for a Last-Event-ID.
+	// Clients that are further behind get a reset event and should reload instead.
+	maxEventReplay = 1000
+
+	// eventsBuffer is how many events can queue up for a slow client before it is
+	// disconnected. It will reconnect and catch up from the log.
+	eventsBuffer = 64
+)
+
+// hubMessage is what the hub fans out: either a movie event or a change to a movie's
+// edit lock.
+type hubMessage struct {
+	event *data.MovieEvent
+	lock  *data.MovieLock
+}
+
+// movieEventHub shares one LISTEN connection between every SSE and WebSocket client in
+// the process and fans the events out to them. Each event is loaded from the log once,
+// however many clients are connected.
+type movieEventHub struct {
+	dsn    string
+	dao    data.MovieEventDAO
+	logger *slog.Logger
+
+	mu          sync.Mutex
+	subscribers map[chan hubMessage]struct{}
+	closed      bool
+}
+
+func newMovieEventHub(dsn string, dao data.MovieEventDAO, logger *slog.Logger) *movieEventHub {
+	return &movieEventHub{
+		dsn:         dsn,
+		dao:         dao,
+		logger:      logger,
+		subscribers: make(map[chan hubMessage]struct{}),
+	}
+}
+
+// subscribe returns a channel of new events and lock changes, and a function to stop
+// receiving them.
+// The channel is closed if the client falls behind, the LISTEN connection drops, or
+// the hub shuts down.
+func (h *movieEventHub) subscribe() (<-chan hubMessage, func()) {
+	ch := make(chan hubMessage, eventsBuffer)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	h.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if _, ok := h.subscribers[ch]; ok {
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (h *movieEventHub) broadcast(message hubMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- message:
+		default:
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// disconnectAll closes every subscriber. With shutdown set, later subscribers are
+// closed straight away too.
+func (h *movieEventHub) disconnectAll(shutdown bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+
+	h.closed = h.closed || shutdown
+}
+
+// run listens for movie events and lock changes until ctx is done, then disconnects every client so
+// the graceful shutdown isn't held up by open streams.
+func (h *movieEventHub) run(ctx context.Context) {
+	defer h.disconnectAll(true)
+
+	listener := pq.NewListener(h.dsn, time.Second, time.Minute, func(_ pq.ListenerEventType, err error) {
+		if err != nil {
+			h.logger.Error(err.Error(), "component", "movie_events")
+		}
+	})
+	defer listener.Close()
+
+	for _, channel := range []string{"movie_events", "movie_locks"} {
+		if err := listener.Listen(channel); err != nil {
+			h.logger.Error(err.Error(), "component", "movie_events")
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-listener.Notify:
+			// A nil notification means the connection was re-established, so events
+			// may have been missed. Clients reconnect and replay them from the log.
+			if n == nil {
+				h.disconnectAll(false)
+				continue
+			}
+
+			// Lock notifications carry the whole lock, as locks aren't kept in a log.
+			if n.Channel == "movie_locks" {
+				var lock data.MovieLock
+
+				if err := json.Unmarshal([]byte(n.Extra), &lock); err != nil {
+					h.logger.Error(err.Error(), "component", "movie_events")
+					continue
+				}
+
+				h.broadcast(hubMessage{lock: &lock})
+				continue
+			}
+
+			id, err := strconv.ParseInt(n.Extra, 10, 64)
+			if err != nil {
+				h.logger.Error(err.Error(), "component", "movie_events")
+				continue
+			}
+
+			event, err := h.dao.Get(id)
+			if err != nil {
+				h.logger.Error(err.Error(), "component", "movie_events", "event_id", id)
+				continue
+			}
+
+			h.broadcast(hubMessage{event: event})
+		case <-time.After(90 * time.Second):
+			go listener.Ping()
+		}
+	}
+}
+
+// movieEventsHandler streams movie changes as server-sent events. ?genres= limits the
+// stream to movies with at least one of the given genres. A reconnecting client sends
+// the ID of the last event it saw (as Last-Event-ID, or ?last_event_id= for the first
+// connection) and gets everything it missed first.
+func (a *application) movieEventsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	genres := a.readCSV(qs, "genres", []string{})
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = qs.Get("last_event_id")
+	}
+
+	var afterID int64
+	if lastEventID != "" {
+		id, err := strconv.ParseInt(lastEventID, 10, 64)
+		v.Check(err == nil && id >= 0, "last_event_id", "must be a valid event ID")
+		afterID = id
+	}
+
+	if len(genres) > 0 {
+		permitted, err := a.dao.Genres.Slugs()
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+
+		data.ValidateMovieGenres(v, genres, permitted)
+	}
+
+	if !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Subscribe before reading the log, so nothing committed in between is lost.
+	// Anything that turns up in both is only sent once.
+	events, unsubscribe := a.events.subscribe()
+	defer unsubscribe()
+
+	var (
+		replay   []data.MovieEvent
+		complete = true
+		err      error
+	)
+
+	if afterID > 0 {
+		replay, complete, err = a.dao.Events.GetSince(afterID, maxEventReplay)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// The server's ReadTimeout would otherwise cancel the request context part way
+	// through the stream, even though there's no body left to read.
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	send := func(format string, args ...any) error {
+		if err := rc.SetWriteDeadline(time.Now().Add(eventsWriteDeadline)); err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return err
+		}
+
+		return rc.Flush()
+	}
+
+	sendEvent := func(event *data.MovieEvent) error {
+		if !movieEventHasGenre(event, genres) {
+			return nil
+		}
+
+		js, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		return send("id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Event, js)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	err = send("retry: %d\n\n", (3 * time.Second).Milliseconds())
+
+	if err == nil && !complete {
+		err = send("event: reset\ndata: %s\n\n", `{"message":"too many events were missed, reload and reconnect without a Last-Event-ID"}`)
+		replay = nil
+	}
+
+	replayed := make(map[int64]bool, len(replay))
+
+	for i := 0; err == nil && i < len(replay); i++ {
+		replayed[replay[i].ID] = true
+		err = sendEvent(&replay[i])
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for err == nil {
+		select {
+		case <-r.Context().Done():
+			return
+		case message, ok := <-events:
+			if !ok {
+				return
+			}
+
+			// Lock changes are only sent over WebSockets.
+			if message.event != nil && !replayed[message.event.ID] {
+				err = sendEvent(message.event)
+			}
+		case <-heartbeat.C:
+			err = send(": heartbeat\n\n")
+		}
+	}
+
+	if !errors.Is(err, context.Canceled) && r.Context().Err() == nil {
+		a.logError(r, err)
+	}
+}
+
+// eventMovie is the part of an event's movie snapshot used to filter events.
+type eventMovie struct {
+	Title  string   `json:"title"`
+	Genres []string `json:"genres"`
+}
+
+func decodeEventMovie(event *data.MovieEvent) (eventMovie, error) {
+	var movie eventMovie
+
+	err := json.Unmarshal(event.Movie, &movie)
+	return movie, err
+}
+
+// movieEventHasGenre reports whether the movie in an event has any of the genres. An
+// empty list matches everything.
+func movieEventHasGenre(event *data.MovieEvent, genres []string) bool {
+	if len(genres) == 0 {
+		return true
+	}
+
+	movie, err := decodeEventMovie(event)
+	if err != nil {
+		return false
+	}
+
+	return slices.ContainsFunc(movie.Genres, func(genre string) bool {
+		return slices.Contains(genres, genre)
+	})
+}
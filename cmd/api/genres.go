@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/captainmango/greenlight/internal/data"
+	"github.com/captainmango/greenlight/internal/validator"
+)
+
+func (a *application) getGenresHandler(w http.ResponseWriter, r *http.Request) {
+	genres, err := a.dao.Genres.GetAll()
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"genres": genres}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *application) createGenreHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Slug string `json:"slug"`
+		Name string `json:"name"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	genre := &data.Genre{
+		Slug: input.Slug,
+		Name: input.Name,
+	}
+
+	v := validator.New()
+
+	if data.ValidateGenre(v, genre); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.dao.Genres.Insert(genre)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "a genre with this slug already exists")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/genres/%d", genre.ID))
+
+	err = a.writeJSON(w, http.StatusCreated, envelope{"genre": genre}, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *application) showGenreHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIdParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	genre, err := a.dao.Genres.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"genre": genre}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *application) updateGenreHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Slug *string `json:"slug"`
+		Name *string `json:"name"`
+	}
+
+	id, err := a.readIdParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	genre, err := a.dao.Genres.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Slug != nil {
+		genre.Slug = *input.Slug
+	}
+
+	if input.Name != nil {
+		genre.Name = *input.Name
+	}
+
+	v := validator.New()
+
+	if data.ValidateGenre(v, genre); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.dao.Genres.Update(genre)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConfilctResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "a genre with this slug already exists")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"genre": genre}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *application) deleteGenreHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIdParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.dao.Genres.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrGenreInUse):
+			a.errorResponse(w, r, http.StatusConflict, "the genre is still attached to one or more movies")
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"message": "successfully deleted genre"}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
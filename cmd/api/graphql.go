@@ -0,0 +1,495 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/captainmango/greenlight/internal/data"
+	"github.com/captainmango/greenlight/internal/validator"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+const (
+	// graphqlMaxDepth is how deeply fields can be nested. It's enough to go from a
+	// page of movies to their cast's other films and back, but not round again.
+	graphqlMaxDepth = 8
+
+	// graphqlMaxComplexity caps the number of fields a query could resolve. Every
+	// field costs one, and the fields under a list cost once per item it could hold.
+	graphqlMaxComplexity = 10_000
+
+	// graphqlDefaultListSize is the assumed length of lists that aren't paged, like a
+	// movie's credits.
+	graphqlDefaultListSize = 10
+
+	// Introspection has limits of its own. Tools ask for type references nested
+	// several ofTypes deep: GraphiQL's introspection query is 13 levels deep and costs
+	// about 40,000 by the rules above. Repeating types { fields { type { fields ... } } }
+	// multiplies the cost by 100 a time, so it still runs into them.
+	graphqlMaxIntrospectionDepth      = 15
+	graphqlMaxIntrospectionComplexity = 50_000
+
+	// graphqlMaxSelections caps how many selections checking a query may walk, so
+	// fragments spread many times over can't make the check itself expensive.
+	graphqlMaxSelections = 10_000
+)
+
+// graphqlMetaFields are the introspection fields, which every object has but none
+// lists.
+var graphqlMetaFields = map[string]*graphql.FieldDefinition{
+	"__schema":   graphql.SchemaMetaFieldDef,
+	"__type":     graphql.TypeMetaFieldDef,
+	"__typename": graphql.TypeNameMetaFieldDef,
+}
+
+// A graphqlError is a resolver error that is safe to show the client. Its code (and any
+// extensions) end up in the error's "extensions".
+type graphqlError struct {
+	message    string
+	code       string
+	extensions map[string]any
+}
+
+func (e *graphqlError) Error() string {
+	return e.message
+}
+
+func (e *graphqlError) Extensions() map[string]any {
+	extensions := map[string]any{"code": e.code}
+	for key, value := range e.extensions {
+		extensions[key] = value
+	}
+
+	return extensions
+}
+
+var (
+	graphqlNotFound = &graphqlError{
+		message: "the requested resource could not be found",
+		code:    "NOT_FOUND",
+	}
+	graphqlEditConflict = &graphqlError{
+		message: "unable to update the record due to an edit conflict, please try again",
+		code:    "EDIT_CONFLICT",
+	}
+	graphqlUnauthenticated = &graphqlError{
+		message: "you must be authenticated to access this resource",
+		code:    "UNAUTHENTICATED",
+	}
+)
+
+// graphqlDataError maps the DAO's sentinel errors to their GraphQL equivalents. Anything
+// else is passed through and reported as an internal error.
+func graphqlDataError(err error) error {
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		return graphqlNotFound
+	case errors.Is(err, data.ErrEditConflict):
+		return graphqlEditConflict
+	default:
+		return err
+	}
+}
+
+func graphqlInvalid(errors validator.ValidationErrors) error {
+	return &graphqlError{
+		message:    "the input failed validation",
+		code:       "BAD_USER_INPUT",
+		extensions: map[string]any{"fields": errors},
+	}
+}
+
+// graphqlHandler serves POST /v1/graphql. Requests are checked for depth and complexity
+// before they run, and each one gets fresh loaders so batching never leaks data between
+// users.
+func (a *application) graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Query         string         `json:"query"`
+		OperationName string         `json:"operationName"`
+		Variables     map[string]any `json:"variables"`
+		// Extensions is accepted because some clients always send it, but unused.
+		Extensions map[string]any `json:"extensions"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Check(strings.TrimSpace(input.Query) != "", "query", "must be provided"); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if limitErr := checkGraphqlLimits(a.graphql, input.Query, input.Variables); limitErr != nil {
+		a.writeGraphqlResult(w, r, &graphql.Result{Errors: []gqlerrors.FormattedError{gqlerrors.FormatError(limitErr)}})
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), graphqlLoadersContextKey, a.newGraphqlLoaders())
+
+	result := graphql.Do(graphql.Params{
+		Schema:         a.graphql,
+		RequestString:  input.Query,
+		VariableValues: input.Variables,
+		OperationName:  input.OperationName,
+		Context:        ctx,
+	})
+
+	a.writeGraphqlResult(w, r, result)
+}
+
+// writeGraphqlResult sends the result. Errors that didn't come from a graphqlError
+// (or from graphql-go's own parsing and validation) are unexpected: they're logged, and
+// the client just gets told something went wrong.
+func (a *application) writeGraphqlResult(w http.ResponseWriter, r *http.Request, result *graphql.Result) {
+	for i, formatted := range result.Errors {
+		original := graphqlOriginalError(formatted)
+
+		var gqlErr *graphqlError
+
+		switch {
+		case original == nil:
+			continue
+		case errors.As(original, &gqlErr):
+			result.Errors[i].Extensions = gqlErr.Extensions()
+		default:
+			a.logError(r, original)
+			result.Errors[i].Message = "the server encountered a problem and could not process your request"
+			result.Errors[i].Extensions = map[string]any{"code": "INTERNAL_SERVER_ERROR"}
+		}
+	}
+
+	env := envelope{"data": result.Data}
+	if len(result.Errors) > 0 {
+		env["errors"] = result.Errors
+	}
+
+	err := a.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// graphqlOriginalError digs the resolver's error out of the layers graphql-go wraps it
+// in. It returns nil for graphql-go's own errors, which are already fine to show.
+func graphqlOriginalError(err error) error {
+	for err != nil {
+		switch e := err.(type) {
+		case gqlerrors.FormattedError:
+			err = e.OriginalError()
+		case *gqlerrors.Error:
+			err = e.OriginalError
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkGraphqlLimits rejects queries that nest too deeply or could resolve too many
+// fields. Documents that don't parse are left for graphql-go to report.
+func checkGraphqlLimits(schema graphql.Schema, query string, variables map[string]any) error {
+	document, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{Body: []byte(query), Name: "GraphQL request"}),
+	})
+	if err != nil {
+		return nil
+	}
+
+	limiter := &graphqlLimiter{
+		variables: variables,
+		fragments: make(map[string]*ast.FragmentDefinition),
+	}
+
+	for _, definition := range document.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok {
+			limiter.fragments[fragment.Name.Value] = fragment
+		}
+	}
+
+	// graphql-go rejects fragment cycles too, but only after another of its rules has
+	// followed one until the stack runs out.
+	if name := graphqlFragmentCycle(limiter.fragments); name != "" {
+		return &graphqlError{
+			message: fmt.Sprintf("cannot spread fragment %q within itself", name),
+			code:    "GRAPHQL_VALIDATION_FAILED",
+		}
+	}
+
+	for _, definition := range document.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+
+		root := schema.QueryType()
+		if operation.Operation == ast.OperationTypeMutation {
+			root = schema.MutationType()
+		}
+
+		complexity, depth := limiter.selectionSet(operation.SelectionSet, root, 0, 1)
+
+		switch {
+		case limiter.selections > graphqlMaxSelections:
+			return &graphqlError{
+				message: fmt.Sprintf("the query must not have more than %d selections, counting each fragment spread in full", graphqlMaxSelections),
+				code:    "QUERY_TOO_COMPLEX",
+			}
+		case depth > graphqlMaxDepth:
+			return &graphqlError{
+				message: fmt.Sprintf("the query must not nest fields more than %d levels deep", graphqlMaxDepth),
+				code:    "QUERY_TOO_DEEP",
+			}
+		case limiter.introspectionDepth > graphqlMaxIntrospectionDepth:
+			return &graphqlError{
+				message: fmt.Sprintf("the query must not nest introspection fields more than %d levels deep", graphqlMaxIntrospectionDepth),
+				code:    "QUERY_TOO_DEEP",
+			}
+		case complexity > graphqlMaxComplexity:
+			return &graphqlError{
+				message: fmt.Sprintf("the query could resolve %d fields, the limit is %d", complexity, graphqlMaxComplexity),
+				code:    "QUERY_TOO_COMPLEX",
+			}
+		case limiter.introspectionComplexity > graphqlMaxIntrospectionComplexity:
+			return &graphqlError{
+				message: fmt.Sprintf("the query could resolve %d introspection fields, the limit is %d", limiter.introspectionComplexity, graphqlMaxIntrospectionComplexity),
+				code:    "QUERY_TOO_COMPLEX",
+			}
+		}
+	}
+
+	return nil
+}
+
+type graphqlLimiter struct {
+	variables map[string]any
+	fragments map[string]*ast.FragmentDefinition
+
+	selections int
+
+	// introspecting is set while walking an introspection field, which is measured
+	// against the introspection limits instead of the query's.
+	introspecting           bool
+	introspectionComplexity int
+	introspectionDepth      int
+}
+
+// selectionSet returns the complexity and depth of a selection set on parent. size is
+// the page size asked for by the field that owns the set, which is how big any list
+// directly inside it will be. Recursion stops once the depth limit is passed or too
+// many selections have been walked.
+func (l *graphqlLimiter) selectionSet(set *ast.SelectionSet, parent *graphql.Object, depth, size int) (int, int) {
+	maxDepth := graphqlMaxDepth
+	if l.introspecting {
+		maxDepth = graphqlMaxIntrospectionDepth
+	}
+
+	if set == nil || depth > maxDepth || l.selections > graphqlMaxSelections {
+		return 0, depth
+	}
+
+	complexity, setDepth := 0, depth
+
+	add := func(c, d int) {
+		complexity += c
+		setDepth = max(setDepth, d)
+	}
+
+	for _, selection := range set.Selections {
+		l.selections++
+
+		switch selection := selection.(type) {
+		case *ast.Field:
+			add(l.field(selection, parent, depth+1, size))
+		// Every type in the schema is an object, so fragments are always on the
+		// parent type.
+		case *ast.InlineFragment:
+			add(l.selectionSet(selection.SelectionSet, parent, depth, size))
+		case *ast.FragmentSpread:
+			if fragment := l.fragments[selection.Name.Value]; fragment != nil {
+				add(l.selectionSet(fragment.SelectionSet, parent, depth, size))
+			}
+		}
+	}
+
+	return complexity, setDepth
+}
+
+func (l *graphqlLimiter) field(field *ast.Field, parent *graphql.Object, depth, size int) (int, int) {
+	if parent == nil {
+		return 0, 0
+	}
+
+	definition := parent.Fields()[field.Name.Value]
+	if definition == nil {
+		definition = graphqlMetaFields[field.Name.Value]
+	}
+	if definition == nil {
+		return 1, depth
+	}
+
+	// An introspection field counts once towards the query's limits, and everything
+	// under it towards the introspection limits.
+	if graphqlMetaFields[field.Name.Value] != nil && !l.introspecting {
+		l.introspecting = true
+		complexity, maxDepth := l.field(field, parent, depth, size)
+		l.introspecting = false
+
+		l.introspectionComplexity += complexity
+		l.introspectionDepth = max(l.introspectionDepth, maxDepth)
+
+		return 1, depth
+	}
+
+	// A list costs its children once per item: the field's own page size if it has
+	// one, otherwise the page size of the field above, otherwise a guess.
+	multiplier := 1
+	ownSize := l.pageSize(field, definition)
+
+	if isGraphqlList(definition.Type) {
+		switch {
+		case ownSize > 0:
+			multiplier = ownSize
+		case size > 1:
+			multiplier = size
+		default:
+			multiplier = graphqlDefaultListSize
+		}
+	}
+
+	child, _ := graphqlNamedType(definition.Type).(*graphql.Object)
+
+	complexity, maxDepth := l.selectionSet(field.SelectionSet, child, depth, max(ownSize, 1))
+
+	return 1 + multiplier*complexity, max(depth, maxDepth)
+}
+
+// graphqlFragmentCycle returns the name of a fragment that ends up spreading itself,
+// or "" if there are no cycles. Every fragment is checked, used or not.
+func graphqlFragmentCycle(fragments map[string]*ast.FragmentDefinition) string {
+	const (
+		visiting = 1
+		done     = 2
+	)
+
+	state := make(map[string]int, len(fragments))
+
+	var visit func(name string) string
+	var visitSet func(set *ast.SelectionSet) string
+
+	visit = func(name string) string {
+		fragment := fragments[name]
+
+		switch {
+		case fragment == nil || state[name] == done:
+			return ""
+		case state[name] == visiting:
+			return name
+		}
+
+		state[name] = visiting
+		cycle := visitSet(fragment.SelectionSet)
+		state[name] = done
+
+		return cycle
+	}
+
+	visitSet = func(set *ast.SelectionSet) string {
+		if set == nil {
+			return ""
+		}
+
+		for _, selection := range set.Selections {
+			var cycle string
+
+			switch selection := selection.(type) {
+			case *ast.Field:
+				cycle = visitSet(selection.SelectionSet)
+			case *ast.InlineFragment:
+				cycle = visitSet(selection.SelectionSet)
+			case *ast.FragmentSpread:
+				cycle = visit(selection.Name.Value)
+			}
+
+			if cycle != "" {
+				return cycle
+			}
+		}
+
+		return ""
+	}
+
+	for name := range fragments {
+		if cycle := visit(name); cycle != "" {
+			return cycle
+		}
+	}
+
+	return ""
+}
+
+// pageSize reads a field's pageSize or first argument, falling back to its default.
+func (l *graphqlLimiter) pageSize(field *ast.Field, definition *graphql.FieldDefinition) int {
+	for _, arg := range definition.Args {
+		if arg.Name() != "pageSize" && arg.Name() != "first" {
+			continue
+		}
+
+		size, _ := arg.DefaultValue.(int)
+
+		for _, given := range field.Arguments {
+			if given.Name.Value != arg.Name() {
+				continue
+			}
+
+			switch value := given.Value.(type) {
+			case *ast.IntValue:
+				fmt.Sscan(value.Value, &size)
+			case *ast.Variable:
+				// Variables are decoded from JSON, so numbers arrive as float64.
+				if n, ok := l.variables[value.Name.Value].(float64); ok {
+					size = int(n)
+				}
+			}
+		}
+
+		// Out of range sizes fail validation later; they mustn't make the cost negative
+		// first.
+		return max(size, 0)
+	}
+
+	return 0
+}
+
+func isGraphqlList(t graphql.Type) bool {
+	if nonNull, ok := t.(*graphql.NonNull); ok {
+		t = nonNull.OfType
+	}
+
+	_, ok := t.(*graphql.List)
+	return ok
+}
+
+func graphqlNamedType(t graphql.Type) graphql.Type {
+	for {
+		switch wrapped := t.(type) {
+		case *graphql.NonNull:
+			t = wrapped.OfType
+		case *graphql.List:
+			t = wrapped.OfType
+		default:
+			return t
+		}
+	}
+}
@@ -0,0 +1,633 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/captainmango/greenlight/internal/data"
+	"github.com/captainmango/greenlight/internal/dataloader"
+	"github.com/captainmango/greenlight/internal/validator"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// graphqlLoaders batch the nested lookups in one GraphQL request. Every movie on a page
+// asks for its credits separately, and the loader turns that into one query.
+type graphqlLoaders struct {
+	movies        *dataloader.Loader[int64, *data.Movie]
+	people        *dataloader.Loader[int64, *data.Person]
+	credits       *dataloader.Loader[int64, []data.Credit]
+	filmographies *dataloader.Loader[int64, []data.Credit]
+	locks         *dataloader.Loader[int64, *data.MovieLock]
+	listItems     *dataloader.Loader[int64, []data.ListItem]
+	// reviews has a loader per page size, since the size is part of the query.
+	reviews map[int]*dataloader.Loader[int64, []data.Review]
+}
+
+func (a *application) newGraphqlLoaders() *graphqlLoaders {
+	return &graphqlLoaders{
+		movies:        dataloader.New(a.dao.Movies.GetMany),
+		people:        dataloader.New(a.dao.People.GetMany),
+		credits:       dataloader.New(a.dao.Credits.GetForMovies),
+		filmographies: dataloader.New(a.dao.Credits.GetFilmographies),
+		locks:         dataloader.New(a.dao.Locks.GetAll),
+		listItems:     dataloader.New(a.dao.Lists.GetItemsForLists),
+		reviews:       make(map[int]*dataloader.Loader[int64, []data.Review]),
+	}
+}
+
+func (l *graphqlLoaders) reviewsLoader(a *application, first int) *dataloader.Loader[int64, []data.Review] {
+	if l.reviews[first] == nil {
+		l.reviews[first] = dataloader.New(func(movieIDs []int64) (map[int64][]data.Review, error) {
+			return a.dao.Reviews.GetLatestForMovies(movieIDs, first)
+		})
+	}
+
+	return l.reviews[first]
+}
+
+const graphqlLoadersContextKey = contextKey("graphql_loaders")
+
+func graphqlContextLoaders(ctx context.Context) *graphqlLoaders {
+	return ctx.Value(graphqlLoadersContextKey).(*graphqlLoaders)
+}
+
+// graphqlContextUser returns the user set by the authenticate middleware.
+func graphqlContextUser(ctx context.Context) *data.User {
+	return ctx.Value(userContextKey).(*data.User)
+}
+
+// load adapts a dataloader thunk to the signature graphql-go expects, converting the
+// slice to pointers so every object resolver gets the same source type.
+func load[V any](thunk func() (V, error)) func() (any, error) {
+	return func() (any, error) {
+		return thunk()
+	}
+}
+
+func loadSlice[V any](thunk func() ([]V, error)) func() (any, error) {
+	return func() (any, error) {
+		values, err := thunk()
+		return pointers(values), err
+	}
+}
+
+func pointers[V any](values []V) []*V {
+	ptrs := make([]*V, len(values))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+
+	return ptrs
+}
+
+// field builds a field that reads a value straight off its source.
+func field[T any](typ graphql.Output, get func(*T) any) *graphql.Field {
+	return &graphql.Field{
+		Type: typ,
+		Resolve: func(p graphql.ResolveParams) (any, error) {
+			return get(p.Source.(*T)), nil
+		},
+	}
+}
+
+// nullable turns a zero value into null, for optional columns stored as zero.
+func nullable[V comparable](value V) any {
+	var zero V
+	if value == zero {
+		return nil
+	}
+
+	return value
+}
+
+// graphqlID reads an ID argument. IDs that aren't numbers can't match anything, so
+// they're reported as not found rather than as a bad request.
+func graphqlID(p graphql.ResolveParams, name string) (int64, error) {
+	id, err := strconv.ParseInt(p.Args[name].(string), 10, 64)
+	if err != nil || id < 1 {
+		return 0, graphqlNotFound
+	}
+
+	return id, nil
+}
+
+var runtimeScalar = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "Runtime",
+	Description: `A running time in the "<minutes> mins" format, like "102 mins".`,
+	Serialize: func(value any) any {
+		if runtime, ok := value.(data.Runtime); ok {
+			return strconv.Itoa(int(runtime)) + " mins"
+		}
+		return nil
+	},
+	ParseValue: func(value any) any {
+		if s, ok := value.(string); ok {
+			if runtime, err := data.ParseRuntime(s); err == nil {
+				return runtime
+			}
+		}
+		return nil
+	},
+	ParseLiteral: func(valueAST ast.Value) any {
+		if s, ok := valueAST.(*ast.StringValue); ok {
+			if runtime, err := data.ParseRuntime(s.Value); err == nil {
+				return runtime
+			}
+		}
+		return nil
+	},
+})
+
+// graphqlSchema builds the schema once at startup. Types mirror the JSON the REST API
+// returns, with camelCase field names, and nested fields go through the request's
+// loaders.
+func (a *application) graphqlSchema() (graphql.Schema, error) {
+	nonNull := graphql.NewNonNull
+	listOf := func(t graphql.Type) graphql.Output { return nonNull(graphql.NewList(nonNull(t))) }
+
+	metadataType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Metadata",
+		Fields: graphql.Fields{
+			"currentPage":  field(nonNull(graphql.Int), func(m *data.Metadata) any { return m.CurrentPage }),
+			"pageSize":     field(nonNull(graphql.Int), func(m *data.Metadata) any { return m.PageSize }),
+			"firstPage":    field(nonNull(graphql.Int), func(m *data.Metadata) any { return m.FirstPage }),
+			"lastPage":     field(nonNull(graphql.Int), func(m *data.Metadata) any { return m.LastPage }),
+			"totalRecords": field(nonNull(graphql.Int), func(m *data.Metadata) any { return m.TotalRecords }),
+		},
+	})
+
+	genreType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Genre",
+		Fields: graphql.Fields{
+			"id":      field(nonNull(graphql.ID), func(g *data.Genre) any { return g.ID }),
+			"slug":    field(nonNull(graphql.String), func(g *data.Genre) any { return g.Slug }),
+			"name":    field(nonNull(graphql.String), func(g *data.Genre) any { return g.Name }),
+			"version": field(nonNull(graphql.Int), func(g *data.Genre) any { return g.Version }),
+		},
+	})
+
+	lockType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "MovieLock",
+		Fields: graphql.Fields{
+			"userId":     field(nonNull(graphql.ID), func(l *data.MovieLock) any { return l.UserID }),
+			"userName":   field(nonNull(graphql.String), func(l *data.MovieLock) any { return l.UserName }),
+			"acquiredAt": field(nonNull(graphql.DateTime), func(l *data.MovieLock) any { return l.AcquiredAt }),
+			"expiresAt":  field(nonNull(graphql.DateTime), func(l *data.MovieLock) any { return l.ExpiresAt }),
+		},
+	})
+
+	reviewType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Review",
+		Fields: graphql.Fields{
+			"id":           field(nonNull(graphql.ID), func(r *data.Review) any { return r.ID }),
+			"createdAt":    field(nonNull(graphql.DateTime), func(r *data.Review) any { return r.CreatedAt }),
+			"updatedAt":    field(nonNull(graphql.DateTime), func(r *data.Review) any { return r.UpdatedAt }),
+			"userId":       field(nonNull(graphql.ID), func(r *data.Review) any { return r.UserID }),
+			"userName":     field(nonNull(graphql.String), func(r *data.Review) any { return r.UserName }),
+			"rating":       field(nonNull(graphql.Int), func(r *data.Review) any { return r.Rating }),
+			"body":         field(nonNull(graphql.String), func(r *data.Review) any { return r.Body }),
+			"helpfulCount": field(nonNull(graphql.Int), func(r *data.Review) any { return r.HelpfulCount }),
+			"version":      field(nonNull(graphql.Int), func(r *data.Review) any { return r.Version }),
+		},
+	})
+
+	movieType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Movie",
+		Fields: graphql.Fields{
+			"id":            field(nonNull(graphql.ID), func(m *data.Movie) any { return m.ID }),
+			"title":         field(nonNull(graphql.String), func(m *data.Movie) any { return m.Title }),
+			"year":          field(graphql.Int, func(m *data.Movie) any { return nullable(m.Year) }),
+			"runtime":       field(runtimeScalar, func(m *data.Movie) any { return nullable(m.Runtime) }),
+			"genres":        field(listOf(graphql.String), func(m *data.Movie) any { return data.NonNilStrings(m.Genres) }),
+			"version":       field(nonNull(graphql.Int), func(m *data.Movie) any { return m.Version }),
+			"averageRating": field(nonNull(graphql.Float), func(m *data.Movie) any { return m.AverageRating }),
+			"ratingCount":   field(nonNull(graphql.Int), func(m *data.Movie) any { return m.RatingCount }),
+			"reviews": &graphql.Field{
+				Type:        listOf(reviewType),
+				Description: "The newest reviews of the movie.",
+				Args: graphql.FieldConfigArgument{
+					"first": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 10},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					first := p.Args["first"].(int)
+					if first < 1 || first > 100 {
+						return nil, graphqlInvalid(validator.ValidationErrors{"first": "must be between 1 and 100"})
+					}
+
+					loader := graphqlContextLoaders(p.Context).reviewsLoader(a, first)
+					return loadSlice(loader.Load(p.Source.(*data.Movie).ID)), nil
+				},
+			},
+			"lock": &graphql.Field{
+				Type:        lockType,
+				Description: "Who is editing the movie, if anyone.",
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return load(graphqlContextLoaders(p.Context).locks.Load(p.Source.(*data.Movie).ID)), nil
+				},
+			},
+		},
+	})
+
+	personType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Person",
+		Fields: graphql.Fields{
+			"id":        field(nonNull(graphql.ID), func(p *data.Person) any { return p.ID }),
+			"name":      field(nonNull(graphql.String), func(p *data.Person) any { return p.Name }),
+			"birthYear": field(graphql.Int, func(p *data.Person) any { return nullable(p.BirthYear) }),
+			"version":   field(nonNull(graphql.Int), func(p *data.Person) any { return p.Version }),
+		},
+	})
+
+	creditType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Credit",
+		Fields: graphql.Fields{
+			"id":           field(nonNull(graphql.ID), func(c *data.Credit) any { return c.ID }),
+			"role":         field(nonNull(graphql.String), func(c *data.Credit) any { return c.Role }),
+			"character":    field(graphql.String, func(c *data.Credit) any { return nullable(c.Character) }),
+			"billingOrder": field(nonNull(graphql.Int), func(c *data.Credit) any { return c.BillingOrder }),
+			"person": &graphql.Field{
+				Type: personType,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return load(graphqlContextLoaders(p.Context).people.Load(p.Source.(*data.Credit).PersonID)), nil
+				},
+			},
+			"movie": &graphql.Field{
+				Type: movieType,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return load(graphqlContextLoaders(p.Context).movies.Load(p.Source.(*data.Credit).MovieID)), nil
+				},
+			},
+		},
+	})
+
+	movieType.AddFieldConfig("credits", &graphql.Field{
+		Type: listOf(creditType),
+		Resolve: func(p graphql.ResolveParams) (any, error) {
+			return loadSlice(graphqlContextLoaders(p.Context).credits.Load(p.Source.(*data.Movie).ID)), nil
+		},
+	})
+
+	personType.AddFieldConfig("filmography", &graphql.Field{
+		Type:        listOf(creditType),
+		Description: "Every credit for the person, newest movies first.",
+		Resolve: func(p graphql.ResolveParams) (any, error) {
+			return loadSlice(graphqlContextLoaders(p.Context).filmographies.Load(p.Source.(*data.Person).ID)), nil
+		},
+	})
+
+	listItemType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ListItem",
+		Fields: graphql.Fields{
+			"position": field(nonNull(graphql.Int), func(i *data.ListItem) any { return i.Position }),
+			"addedAt":  field(nonNull(graphql.DateTime), func(i *data.ListItem) any { return i.AddedAt }),
+			"movie": &graphql.Field{
+				Type: movieType,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return load(graphqlContextLoaders(p.Context).movies.Load(p.Source.(*data.ListItem).MovieID)), nil
+				},
+			},
+		},
+	})
+
+	listType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "List",
+		Fields: graphql.Fields{
+			"id":        field(nonNull(graphql.ID), func(l *data.List) any { return l.ID }),
+			"createdAt": field(nonNull(graphql.DateTime), func(l *data.List) any { return l.CreatedAt }),
+			"name":      field(nonNull(graphql.String), func(l *data.List) any { return l.Name }),
+			"public":    field(nonNull(graphql.Boolean), func(l *data.List) any { return l.Public }),
+			"shareSlug": field(graphql.String, func(l *data.List) any { return nullable(l.ShareSlug) }),
+			"version":   field(nonNull(graphql.Int), func(l *data.List) any { return l.Version }),
+			"items": &graphql.Field{
+				Type: listOf(listItemType),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return loadSlice(graphqlContextLoaders(p.Context).listItems.Load(p.Source.(*data.List).ID)), nil
+				},
+			},
+		},
+	})
+
+	moviePageType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "MoviePage",
+		Fields: graphql.Fields{
+			"movies":   field(listOf(movieType), func(p *moviePage) any { return pointers(p.movies) }),
+			"metadata": field(nonNull(metadataType), func(p *moviePage) any { return &p.metadata }),
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"movie": &graphql.Field{
+				Type: movieType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: nonNull(graphql.ID)},
+				},
+				Resolve: a.resolveMovie,
+			},
+			"movies": &graphql.Field{
+				Type:        nonNull(moviePageType),
+				Description: "One page of movies, filtered and sorted like GET /v1/movies.",
+				Args: graphql.FieldConfigArgument{
+					"title":    &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: ""},
+					"genres":   &graphql.ArgumentConfig{Type: graphql.NewList(nonNull(graphql.String))},
+					"page":     &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 1},
+					"pageSize": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 20},
+					"sort":     &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: "id"},
+				},
+				Resolve: a.resolveMovies,
+			},
+			"person": &graphql.Field{
+				Type: personType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: nonNull(graphql.ID)},
+				},
+				Resolve: a.resolvePerson,
+			},
+			"genres": &graphql.Field{
+				Type: listOf(genreType),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					genres, err := a.dao.Genres.GetAll()
+					return pointers(genres), err
+				},
+			},
+			"lists": &graphql.Field{
+				Type:        listOf(listType),
+				Description: "The signed in user's lists.",
+				Resolve:     a.resolveLists,
+			},
+			"list": &graphql.Field{
+				Type:        listType,
+				Description: "One of the signed in user's lists.",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: nonNull(graphql.ID)},
+				},
+				Resolve: a.resolveList,
+			},
+		},
+	})
+
+	movieInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "MovieInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"title":   &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"year":    &graphql.InputObjectFieldConfig{Type: graphql.Int},
+			"runtime": &graphql.InputObjectFieldConfig{Type: runtimeScalar},
+			"genres":  &graphql.InputObjectFieldConfig{Type: graphql.NewList(nonNull(graphql.String))},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createMovie": &graphql.Field{
+				Type: nonNull(movieType),
+				Args: graphql.FieldConfigArgument{
+					"input": &graphql.ArgumentConfig{Type: nonNull(movieInput)},
+				},
+				Resolve: a.resolveCreateMovie,
+			},
+			"updateMovie": &graphql.Field{
+				Type:        nonNull(movieType),
+				Description: "Changes the fields given in input and leaves the rest alone. If version is given it must match the movie's current version.",
+				Args: graphql.FieldConfigArgument{
+					"id":      &graphql.ArgumentConfig{Type: nonNull(graphql.ID)},
+					"version": &graphql.ArgumentConfig{Type: graphql.Int},
+					"input":   &graphql.ArgumentConfig{Type: nonNull(movieInput)},
+				},
+				Resolve: a.resolveUpdateMovie,
+			},
+			"deleteMovie": &graphql.Field{
+				Type:        nonNull(graphql.ID),
+				Description: "Moves a movie to the trash and returns its ID.",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: nonNull(graphql.ID)},
+				},
+				Resolve: a.resolveDeleteMovie,
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query:    query,
+		Mutation: mutation,
+	})
+}
+
+type moviePage struct {
+	movies   []data.Movie
+	metadata data.Metadata
+}
+
+func (a *application) resolveMovie(p graphql.ResolveParams) (any, error) {
+	id, err := graphqlID(p, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	movie, err := a.dao.Movies.Get(id)
+	if err != nil {
+		return nil, graphqlDataError(err)
+	}
+
+	return movie, nil
+}
+
+func (a *application) resolveMovies(p graphql.ResolveParams) (any, error) {
+	var genres []string
+	if list, ok := p.Args["genres"].([]any); ok {
+		for _, genre := range list {
+			genres = append(genres, genre.(string))
+		}
+	}
+
+	filters := data.Filters{
+		Page:         p.Args["page"].(int),
+		PageSize:     p.Args["pageSize"].(int),
+		Sort:         p.Args["sort"].(string),
+		SortSafelist: movieSortSafelist,
+	}
+
+	v := validator.New()
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		return nil, graphqlInvalid(v.Errors)
+	}
+
+	movies, metadata, err := a.dao.Movies.GetAll(p.Args["title"].(string), genres, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	return &moviePage{movies: movies, metadata: metadata}, nil
+}
+
+func (a *application) resolvePerson(p graphql.ResolveParams) (any, error) {
+	id, err := graphqlID(p, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	person, err := a.dao.People.Get(id)
+	if err != nil {
+		return nil, graphqlDataError(err)
+	}
+
+	return person, nil
+}
+
+func (a *application) resolveLists(p graphql.ResolveParams) (any, error) {
+	user := graphqlContextUser(p.Context)
+	if user.IsAnonymous() {
+		return nil, graphqlUnauthenticated
+	}
+
+	lists, err := a.dao.Lists.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pointers(lists), nil
+}
+
+// resolveList only returns the user's own lists. Anyone else's is not found, like
+// GET /v1/lists/:id.
+func (a *application) resolveList(p graphql.ResolveParams) (any, error) {
+	user := graphqlContextUser(p.Context)
+	if user.IsAnonymous() {
+		return nil, graphqlUnauthenticated
+	}
+
+	id, err := graphqlID(p, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := a.dao.Lists.Get(id)
+	if err != nil {
+		return nil, graphqlDataError(err)
+	}
+
+	if list.UserID != user.ID {
+		return nil, graphqlNotFound
+	}
+
+	return list, nil
+}
+
+func (a *application) resolveCreateMovie(p graphql.ResolveParams) (any, error) {
+	movie := &data.Movie{}
+	applyMovieInput(movie, p.Args["input"].(map[string]any))
+
+	if err := a.graphqlValidateMovie(movie); err != nil {
+		return nil, err
+	}
+
+	if err := a.dao.Movies.Insert(movie, graphqlContextUser(p.Context).ID); err != nil {
+		return nil, err
+	}
+
+	return movie, nil
+}
+
+func (a *application) resolveUpdateMovie(p graphql.ResolveParams) (any, error) {
+	id, err := graphqlID(p, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.graphqlCheckMovieLock(p.Context, id); err != nil {
+		return nil, err
+	}
+
+	movie, err := a.dao.Movies.Get(id)
+	if err != nil {
+		return nil, graphqlDataError(err)
+	}
+
+	if version, ok := p.Args["version"].(int); ok && int32(version) != movie.Version {
+		return nil, graphqlDataError(data.ErrEditConflict)
+	}
+
+	applyMovieInput(movie, p.Args["input"].(map[string]any))
+
+	if err := a.graphqlValidateMovie(movie); err != nil {
+		return nil, err
+	}
+
+	if err := a.dao.Movies.Update(movie, graphqlContextUser(p.Context).ID); err != nil {
+		return nil, graphqlDataError(err)
+	}
+
+	return movie, nil
+}
+
+func (a *application) resolveDeleteMovie(p graphql.ResolveParams) (any, error) {
+	id, err := graphqlID(p, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.graphqlCheckMovieLock(p.Context, id); err != nil {
+		return nil, err
+	}
+
+	if err := a.dao.Movies.Delete(id, graphqlContextUser(p.Context).ID); err != nil {
+		return nil, graphqlDataError(err)
+	}
+
+	return id, nil
+}
+
+// applyMovieInput copies the fields present in a MovieInput onto movie. Fields left out
+// (or null) keep their current value, like a plain JSON PATCH.
+func applyMovieInput(movie *data.Movie, input map[string]any) {
+	if title, ok := input["title"].(string); ok {
+		movie.Title = title
+	}
+
+	if year, ok := input["year"].(int); ok {
+		movie.Year = int32(year)
+	}
+
+	if runtime, ok := input["runtime"].(data.Runtime); ok {
+		movie.Runtime = runtime
+	}
+
+	if genres, ok := input["genres"].([]any); ok {
+		movie.Genres = make([]string, len(genres))
+		for i, genre := range genres {
+			movie.Genres[i] = genre.(string)
+		}
+	}
+}
+
+// graphqlValidateMovie runs the same checks as the REST handlers, returning failures
+// as a BAD_USER_INPUT error.
+func (a *application) graphqlValidateMovie(movie *data.Movie) error {
+	v := validator.New()
+
+	if err := a.validateMovie(v, movie); err != nil {
+		return err
+	}
+
+	if !v.Valid() {
+		return graphqlInvalid(v.Errors)
+	}
+
+	return nil
+}
+
+func (a *application) graphqlCheckMovieLock(ctx context.Context, movieID int64) error {
+	lock, err := a.heldMovieLock(movieID, graphqlContextUser(ctx))
+	if !errors.Is(err, data.ErrMovieLocked) {
+		return err
+	}
+
+	return &graphqlError{
+		message:    "this movie is locked for editing by " + lock.UserName,
+		code:       "LOCKED",
+		extensions: map[string]any{"lock": lock},
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/testutil"
+)
+
+func testGraphqlSchema(t *testing.T) graphql.Schema {
+	t.Helper()
+
+	schema, err := (&application{}).graphqlSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return schema
+}
+
+// spreadTwice builds n fragments that each spread the next one twice, so expanding
+// them in full means 2^n selections.
+func spreadTwice(n int) string {
+	var b strings.Builder
+
+	b.WriteString("query { ...F0 }\n")
+	for i := range n {
+		b.WriteString("fragment F" + strconv.Itoa(i) + " on Query { ")
+		if i == n-1 {
+			b.WriteString("genres { name }")
+		} else {
+			b.WriteString("...F" + strconv.Itoa(i+1) + " ...F" + strconv.Itoa(i+1))
+		}
+		b.WriteString(" }\n")
+	}
+
+	return b.String()
+}
+
+func TestCheckGraphqlLimits(t *testing.T) {
+	schema := testGraphqlSchema(t)
+
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]any
+		code      string
+	}{
+		{
+			name:  "a page of movies",
+			query: `{ movies(pageSize: 50) { movies { title credits { person { name } } } metadata { totalRecords } } }`,
+		},
+		{
+			name:  "as deep as allowed",
+			query: `{ movie(id: 1) { credits { person { filmography { movie { credits { person { name } } } } } } } }`,
+		},
+		{
+			name:  "too deep",
+			query: `{ movie(id: 1) { credits { person { filmography { movie { credits { person { filmography { role } } } } } } } } }`,
+			code:  "QUERY_TOO_DEEP",
+		},
+		{
+			name:  "fragments don't hide depth",
+			query: `{ movie(id: 1) { ...Credits } } fragment Credits on Movie { credits { person { filmography { movie { credits { person { filmography { role } } } } } } } }`,
+			code:  "QUERY_TOO_DEEP",
+		},
+		{
+			name:  "too complex",
+			query: `{ movies(pageSize: 100) { movies { credits { person { filmography { movie { reviews(first: 100) { body } } } } } } } }`,
+			code:  "QUERY_TOO_COMPLEX",
+		},
+		{
+			name:      "page sizes from variables",
+			query:     `query($size: Int) { movies(pageSize: $size) { movies { credits { person { filmography { movie { title } } } } } } }`,
+			variables: map[string]any{"size": float64(100)},
+			code:      "QUERY_TOO_COMPLEX",
+		},
+		{
+			name:  "a fragment that spreads itself",
+			query: `query { ...A } fragment A on Query { ...A }`,
+			code:  "GRAPHQL_VALIDATION_FAILED",
+		},
+		{
+			name:  "fragments that spread each other",
+			query: `query { movie(id: 1) { ...A } } fragment A on Movie { title ...B } fragment B on Movie { credits { movie { ...A } } }`,
+			code:  "GRAPHQL_VALIDATION_FAILED",
+		},
+		{
+			name:  "a cycle in an unused fragment",
+			query: `query { genres { name } } fragment A on Query { ...B } fragment B on Query { ... on Query { ...A } }`,
+			code:  "GRAPHQL_VALIDATION_FAILED",
+		},
+		{
+			name:  "a fragment spread twice",
+			query: `query { movie(id: 1) { ...Title credits { movie { ...Title } } } } fragment Title on Movie { title }`,
+		},
+		{
+			name:  "fragments spread many times over",
+			query: spreadTwice(30),
+			code:  "QUERY_TOO_COMPLEX",
+		},
+		{
+			name:  "GraphiQL's introspection query",
+			query: testutil.IntrospectionQuery,
+		},
+		{
+			name:  "__typename",
+			query: `{ __typename movie(id: 1) { __typename title } }`,
+		},
+		{
+			name:  "deep introspection",
+			query: `{ __schema { types { fields { type { fields { type { fields { type { fields { type { fields { type { fields { type { fields { name } } } } } } } } } } } } } } } }`,
+			code:  "QUERY_TOO_DEEP",
+		},
+		{
+			name:  "wide introspection",
+			query: `{ __schema { types { fields { args { type { fields { args { type { fields { name } } } } } } } } } }`,
+			code:  "QUERY_TOO_COMPLEX",
+		},
+		{
+			name:  "introspection under a fragment",
+			query: `{ ...Schema } fragment Schema on Query { __schema { types { fields { type { fields { type { fields { type { fields { type { fields { type { fields { type { fields { name } } } } } } } } } } } } } } } }`,
+			code:  "QUERY_TOO_DEEP",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkGraphqlLimits(schema, tt.query, tt.variables)
+
+			var gqlErr *graphqlError
+
+			switch {
+			case tt.code == "" && err != nil:
+				t.Errorf("got %v, want the query allowed", err)
+			case tt.code != "" && (!errors.As(err, &gqlErr) || gqlErr.code != tt.code):
+				t.Errorf("got %v, want %s", err, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/captainmango/greenlight/internal/data"
+	"github.com/captainmango/greenlight/internal/validator"
+	greenlightv1 "github.com/captainmango/greenlight/proto/greenlight/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// serveGRPC serves the gRPC API on lis until ctx is cancelled. Like the HTTP server,
+// in-flight calls get 30 seconds to finish, after which they're cut off; long
+// ListMovies streams would otherwise hold up the shutdown.
+func (a *application) serveGRPC(ctx context.Context, lis net.Listener) {
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(a.grpcUnaryInterceptor),
+		grpc.ChainStreamInterceptor(a.grpcStreamInterceptor),
+	)
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(greenlightv1.MovieService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+
+	greenlightv1.RegisterMovieServiceServer(server, &movieServer{app: a})
+	healthpb.RegisterHealthServer(server, healthServer)
+	reflection.Register(server)
+
+	go func() {
+		<-ctx.Done()
+
+		a.logger.Info("shutting down grpc server", "addr", lis.Addr().String())
+
+		// Tell health checkers first, so load balancers stop sending new calls.
+		healthServer.Shutdown()
+
+		stopped := make(chan struct{})
+		go func() {
+			server.GracefulStop()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+		case <-time.After(30 * time.Second):
+			server.Stop()
+		}
+	}()
+
+	a.logger.Info("starting grpc server", "addr", lis.Addr().String())
+
+	err := server.Serve(lis)
+	if err != nil {
+		a.logger.Error(err.Error())
+		return
+	}
+
+	a.logger.Info("stopped grpc server", "addr", lis.Addr().String())
+}
+
+// grpcUnaryInterceptor does for unary calls what the recoverPanic and authenticate
+// middleware do for HTTP requests. It also makes sure errors the client wasn't meant
+// to see are logged and replaced with a plain INTERNAL.
+func (a *application) grpcUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("%s", rec)
+		}
+
+		err = a.grpcError(info.FullMethod, err)
+	}()
+
+	ctx, err = a.grpcAuthenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (a *application) grpcStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("%s", rec)
+		}
+
+		err = a.grpcError(info.FullMethod, err)
+	}()
+
+	ctx, err := a.grpcAuthenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, &grpcServerStream{ServerStream: ss, ctx: ctx})
+}
+
+// grpcServerStream swaps the context of a stream for one carrying the user.
+type grpcServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// grpcAuthenticate adds the user that owns the "authorization" metadata to ctx. As with
+// the REST API, calls without it are made as the AnonymousUser.
+func (a *application) grpcAuthenticate(ctx context.Context) (context.Context, error) {
+	values := metadata.ValueFromIncomingContext(ctx, "authorization")
+	if len(values) == 0 {
+		return context.WithValue(ctx, userContextKey, data.AnonymousUser), nil
+	}
+
+	user, err := a.userForAuthorization(values[0])
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidAuthenticationToken):
+			return nil, status.Error(codes.Unauthenticated, errInvalidAuthenticationToken.Error())
+		default:
+			return nil, err
+		}
+	}
+
+	return context.WithValue(ctx, userContextKey, user), nil
+}
+
+func grpcContextUser(ctx context.Context) *data.User {
+	return ctx.Value(userContextKey).(*data.User)
+}
+
+// grpcError passes status errors through. Anything else is unexpected, so it's logged
+// and the client is told something went wrong.
+func (a *application) grpcError(method string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	a.logger.Error(err.Error(), "method", method)
+
+	return status.Error(codes.Internal, "the server encountered a problem and could not process your request")
+}
+
+// grpcDataError maps the DAO's sentinel errors to status codes. Anything else is
+// passed through and reported as an internal error.
+func grpcDataError(err error) error {
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		return status.Error(codes.NotFound, "the requested resource could not be found")
+	case errors.Is(err, data.ErrEditConflict):
+		return status.Error(codes.Aborted, "unable to update the record due to an edit conflict, please try again")
+	default:
+		return err
+	}
+}
+
+// grpcInvalid reports validation failures as INVALID_ARGUMENT, with a field violation
+// for each one.
+func grpcInvalid(errors validator.ValidationErrors) error {
+	badRequest := &errdetails.BadRequest{}
+	for field, description := range errors {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: description,
+		})
+	}
+
+	st, err := status.New(codes.InvalidArgument, "the input failed validation").WithDetails(badRequest)
+	if err != nil {
+		return err
+	}
+
+	return st.Err()
+}
+
+type movieServer struct {
+	greenlightv1.UnimplementedMovieServiceServer
+	app *application
+}
+
+func (s *movieServer) GetMovie(ctx context.Context, req *greenlightv1.GetMovieRequest) (*greenlightv1.Movie, error) {
+	movie, err := s.app.dao.Movies.Get(req.GetId())
+	if err != nil {
+		return nil, grpcDataError(err)
+	}
+
+	return movieToProto(movie), nil
+}
+
+func (s *movieServer) ListMovies(req *greenlightv1.ListMoviesRequest, stream grpc.ServerStreamingServer[greenlightv1.Movie]) error {
+	filters := data.Filters{
+		Sort:         req.GetSort(),
+		SortSafelist: movieSortSafelist,
+	}
+
+	if filters.Sort == "" {
+		filters.Sort = "id"
+	}
+
+	v := validator.New()
+	if v.Check(validator.PermittedValue(filters.Sort, filters.SortSafelist...), "sort", "invalid sort value"); !v.Valid() {
+		return grpcInvalid(v.Errors)
+	}
+
+	return s.app.dao.Movies.Export(stream.Context(), req.GetTitle(), req.GetGenres(), filters, func(movie *data.Movie) error {
+		return stream.Send(movieToProto(movie))
+	})
+}
+
+func (s *movieServer) CreateMovie(ctx context.Context, req *greenlightv1.CreateMovieRequest) (*greenlightv1.Movie, error) {
+	movie := &data.Movie{
+		Title:   req.GetMovie().GetTitle(),
+		Year:    req.GetMovie().GetYear(),
+		Runtime: data.Runtime(req.GetMovie().GetRuntimeMinutes()),
+		Genres:  req.GetMovie().GetGenres(),
+	}
+
+	if err := s.validateMovie(movie); err != nil {
+		return nil, err
+	}
+
+	if err := s.app.dao.Movies.Insert(movie, grpcContextUser(ctx).ID); err != nil {
+		return nil, err
+	}
+
+	return movieToProto(movie), nil
+}
+
+func (s *movieServer) UpdateMovie(ctx context.Context, req *greenlightv1.UpdateMovieRequest) (*greenlightv1.Movie, error) {
+	input := req.GetMovie()
+
+	paths := req.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		paths = []string{"title", "year", "runtime_minutes", "genres"}
+	}
+
+	if err := s.checkMovieLock(ctx, input.GetId()); err != nil {
+		return nil, err
+	}
+
+	movie, err := s.app.dao.Movies.Get(input.GetId())
+	if err != nil {
+		return nil, grpcDataError(err)
+	}
+
+	if input.GetVersion() != 0 && input.GetVersion() != movie.Version {
+		return nil, grpcDataError(data.ErrEditConflict)
+	}
+
+	v := validator.New()
+
+	for _, path := range paths {
+		switch path {
+		case "title":
+			movie.Title = input.GetTitle()
+		case "year":
+			movie.Year = input.GetYear()
+		case "runtime_minutes":
+			movie.Runtime = data.Runtime(input.GetRuntimeMinutes())
+		case "genres":
+			movie.Genres = input.GetGenres()
+		default:
+			v.AddError("update_mask", fmt.Sprintf("%q is not a field that can be updated", path))
+		}
+	}
+
+	if !v.Valid() {
+		return nil, grpcInvalid(v.Errors)
+	}
+
+	if err := s.validateMovie(movie); err != nil {
+		return nil, err
+	}
+
+	if err := s.app.dao.Movies.Update(movie, grpcContextUser(ctx).ID); err != nil {
+		return nil, grpcDataError(err)
+	}
+
+	return movieToProto(movie), nil
+}
+
+func (s *movieServer) DeleteMovie(ctx context.Context, req *greenlightv1.DeleteMovieRequest) (*greenlightv1.DeleteMovieResponse, error) {
+	if err := s.checkMovieLock(ctx, req.GetId()); err != nil {
+		return nil, err
+	}
+
+	if err := s.app.dao.Movies.Delete(req.GetId(), grpcContextUser(ctx).ID); err != nil {
+		return nil, grpcDataError(err)
+	}
+
+	return &greenlightv1.DeleteMovieResponse{}, nil
+}
+
+// validateMovie runs the same checks as the REST handlers.
+func (s *movieServer) validateMovie(movie *data.Movie) error {
+	v := validator.New()
+
+	if err := s.app.validateMovie(v, movie); err != nil {
+		return err
+	}
+
+	if !v.Valid() {
+		return grpcInvalid(v.Errors)
+	}
+
+	return nil
+}
+
+// checkMovieLock fails with FAILED_PRECONDITION if someone other than the caller holds
+// the lock on the movie. The details say who has it and until when.
+func (s *movieServer) checkMovieLock(ctx context.Context, movieID int64) error {
+	lock, err := s.app.heldMovieLock(movieID, grpcContextUser(ctx))
+	if !errors.Is(err, data.ErrMovieLocked) {
+		return err
+	}
+
+	st, err := status.New(codes.FailedPrecondition, "this movie is locked for editing by "+lock.UserName).WithDetails(&errdetails.ErrorInfo{
+		Reason: "MOVIE_LOCKED",
+		Domain: "greenlight",
+		Metadata: map[string]string{
+			"user_name":  lock.UserName,
+			"expires_at": lock.ExpiresAt.Format(time.RFC3339),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return st.Err()
+}
+
+func movieToProto(movie *data.Movie) *greenlightv1.Movie {
+	return &greenlightv1.Movie{
+		Id:             movie.ID,
+		Title:          movie.Title,
+		Year:           movie.Year,
+		RuntimeMinutes: int32(movie.Runtime),
+		Genres:         movie.Genres,
+		Version:        movie.Version,
+		AverageRating:  movie.AverageRating,
+		RatingCount:    movie.RatingCount,
+	}
+}
@@ -8,7 +8,7 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 	e := envelope{
 		"status": "available",
 		"system_info": envelope{
-			"environment": app.config.env,
+			"environment": app.config().env,
 			"version": version,
 		},
 	}
@@ -6,27 +6,112 @@ import (
 	"fmt"
 	"io"
 	"maps"
+	"mime"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
+	"github.com/captainmango/greenlight/internal/validator"
 	"github.com/julienschmidt/httprouter"
 )
 
 type envelope map[string]any
 
 func (a *application) readIdParam(r *http.Request) (int64, error) {
+	return a.readInt64Param(r, "id")
+}
+
+// readInt64Param reads a named integer route parameter, for routes that have more than
+// one ID in them (e.g. /v1/movies/:id/credits/:credit_id).
+func (a *application) readInt64Param(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 
 	if err != nil {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
 	return id, nil
 }
 
+// readCSV reads a comma-separated query string value into a slice, falling back to the
+// default when the key is missing.
+func (a *application) readCSV(qs url.Values, key string, defaultValue []string) []string {
+	csv := qs.Get(key)
+
+	if csv == "" {
+		return defaultValue
+	}
+
+	return strings.Split(csv, ",")
+}
+
+// readString returns a query string value, or the default if the key is missing.
+func (a *application) readString(qs url.Values, key string, defaultValue string) string {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	return s
+}
+
+// readInt reads an integer query string value. If it can't be converted we record the
+// problem on the validator and return the default, so callers can report every bad
+// parameter at once.
+func (a *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return defaultValue
+	}
+
+	return i
+}
+
+// readIfMatchVersion reads the record version a client expects to be editing from the
+// If-Match header. Both the bare number and the quoted ETag form ("3") are accepted.
+// The bool is false when the header wasn't sent.
+func (a *application) readIfMatchVersion(r *http.Request) (int32, bool, error) {
+	ifMatch := r.Header.Get("If-Match")
+
+	if ifMatch == "" {
+		return 0, false, nil
+	}
+
+	version, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`), 10, 32)
+	if err != nil {
+		return 0, false, errors.New("invalid If-Match header, expected a record version")
+	}
+
+	return int32(version), true, nil
+}
+
+// readMediaType returns the media type from the Content-Type header without any
+// parameters (like charset). A missing header is treated as application/json.
+func (a *application) readMediaType(r *http.Request) string {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return "application/json"
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+
+	return mediaType
+}
+
 func (a *application) writeJSON(w http.ResponseWriter, status int, data any, headers http.Header) error {
 	js, err := json.MarshalIndent(data, "", "\t")
 	if err != nil {
@@ -53,7 +138,14 @@ func (a *application) readJSON(w http.ResponseWriter, r *http.Request, dst any)
 	maxBytes := 1_048_576
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
 
-	dec := json.NewDecoder(r.Body)
+	return a.decodeJSON(r.Body, dst)
+}
+
+// decodeJSON does the work for readJSON. It's split out so JSON that didn't come
+// straight from the request body (like a movie after a patch has been applied to it)
+// gets the same strict decoding and error messages.
+func (a *application) decodeJSON(body io.Reader, dst any) error {
+	dec := json.NewDecoder(body)
 	dec.DisallowUnknownFields()
 	err := dec.Decode(dst)
 
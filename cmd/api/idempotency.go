@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"time"
+)
+
+// maxUnreadBody is how much of a streamed body the handler can leave unread, like the
+// closing boundary of a multipart form, and still have its response stored.
+const maxUnreadBody = 64 << 10
+
+// idempotencyRecorder passes a response through to the client while keeping a copy of
+// it, so it can be stored against the request's Idempotency-Key.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status      int
+	header      http.Header
+	body        bytes.Buffer
+	wroteHeader bool
+
+	// request is the streamed request body, if there is one.
+	request *hashingBody
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		// Once the response has started the rest of the request body may be gone, so
+		// this is the last chance to hash what the handler didn't read.
+		if rec.request != nil {
+			rec.request.finish()
+		}
+
+		rec.wroteHeader = true
+		rec.status = status
+		rec.header = rec.ResponseWriter.Header().Clone()
+	}
+
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+
+	rec.body.Write(b)
+
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (rec *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// hashingBody hashes a streamed request body as the handler reads it, so the body can
+// be part of the fingerprint without being held in memory.
+type hashingBody struct {
+	io.ReadCloser
+	hash     hash.Hash
+	complete bool
+}
+
+func (b *hashingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.hash.Write(p[:n])
+
+	if errors.Is(err, io.EOF) {
+		b.complete = true
+	}
+
+	return n, err
+}
+
+// finish reads what the handler left of the body, up to maxUnreadBody, and reports
+// whether all of it made it into the hash.
+func (b *hashingBody) finish() bool {
+	if !b.complete {
+		io.Copy(io.Discard, io.LimitReader(b, maxUnreadBody+1))
+	}
+
+	return b.complete
+}
+
+func (a *application) purgeIdempotencyKeys(ctx context.Context) {
+	ticker := time.NewTicker(a.config().idempotency.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := a.dao.Idempotency.DeleteExpired()
+		if err != nil {
+			a.logger.Error(err.Error(), "job", "purge_idempotency_keys")
+		} else if purged > 0 {
+			a.logger.Info("purged expired idempotency keys", "job", "purge_idempotency_keys", "count", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
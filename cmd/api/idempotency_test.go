@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A handler that only reads the part of a multipart form it wants must still leave the
+// whole body in the hash by the time it responds.
+func TestHashingBodyFinishesBeforeTheResponse(t *testing.T) {
+	var form bytes.Buffer
+
+	mw := multipart.NewWriter(&form)
+	part, _ := mw.CreateFormFile("file", "movies.csv")
+	io.WriteString(part, "title,year,runtime,genres\nAlien,1979,117 mins,horror\n")
+	mw.Close()
+
+	want := sha256.Sum256(form.Bytes())
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/movies/import", bytes.NewReader(form.Bytes()))
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+
+	body := &hashingBody{ReadCloser: r.Body, hash: sha256.New()}
+	r.Body = body
+
+	rec := &idempotencyRecorder{ResponseWriter: httptest.NewRecorder(), request: body}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, file)
+
+	if body.complete {
+		t.Fatal("the closing boundary was read; the test needs a handler that stops short of it")
+	}
+
+	rec.WriteHeader(http.StatusOK)
+
+	if !body.complete || !bytes.Equal(body.hash.Sum(nil), want[:]) {
+		t.Error("the hash doesn't cover the whole body")
+	}
+}
+
+func TestHashingBodyGivesUpOnLargeRemainders(t *testing.T) {
+	payload := strings.Repeat("x", 2*maxUnreadBody)
+
+	body := &hashingBody{ReadCloser: io.NopCloser(strings.NewReader(payload)), hash: sha256.New()}
+
+	if body.finish() {
+		t.Error("finish read more than maxUnreadBody")
+	}
+
+	small := &hashingBody{ReadCloser: io.NopCloser(strings.NewReader(payload[:maxUnreadBody])), hash: sha256.New()}
+
+	want := sha256.Sum256([]byte(payload[:maxUnreadBody]))
+
+	if !small.finish() || !bytes.Equal(small.hash.Sum(nil), want[:]) {
+		t.Error("finish didn't read a remainder of exactly maxUnreadBody")
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/captainmango/greenlight/internal/data"
+	"github.com/captainmango/greenlight/internal/jobs"
+)
+
+const jobMoviesImport = "movies.import"
+
+// registerJobs sets up the handler for every kind of background job. Both the API
+// process and the worker subcommand call it, so either can run any job.
+func (a *application) registerJobs() {
+	jobs.Handle(a.jobs, jobMoviesImport, a.runImportJob)
+	jobs.Handle(a.jobs, jobWebhookDeliver, a.deliverWebhook)
+}
+
+func (a *application) showJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIdParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	job, err := a.jobs.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, jobs.ErrNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Jobs started by a signed in user are only visible to that user (and admins).
+	// Like lists, anyone else gets a 404 so job IDs can't be probed.
+	user := a.contextGetUser(r)
+
+	if job.UserID != nil && *job.UserID != user.ID {
+		if user.IsAnonymous() {
+			a.notFoundResponse(w, r)
+			return
+		}
+
+		permissions, err := a.dao.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !permissions.Include(data.PermissionAdmin) {
+			a.notFoundResponse(w, r)
+			return
+		}
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"job": job}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+type importJobPayload struct {
+	Format string `json:"format"`
+	DryRun bool   `json:"dry_run"`
+}
+
+// enqueueImport stores the upload with a movies.import job and responds with 202
+// Accepted, pointing at the job for the client to poll.
+func (a *application) enqueueImport(w http.ResponseWriter, r *http.Request, body io.Reader, payload importJobPayload) {
+	input, err := io.ReadAll(body)
+	if err != nil {
+		a.badRequestResponse(w, r, importReadError(err))
+		return
+	}
+
+	// Rows that were written before a failure would be written again by a retry,
+	// duplicating any new movies, so imports only get the one attempt.
+	job, err := a.jobs.Enqueue(jobMoviesImport, payload, jobs.EnqueueOptions{
+		MaxAttempts: 1,
+		UserID:      a.contextGetUser(r).ID,
+		Input:       input,
+	})
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/jobs/%d", job.ID))
+
+	err = a.writeJSON(w, http.StatusAccepted, envelope{"job": job}, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// runImportJob runs an import queued by enqueueImport. The report the handler would
+// have responded with becomes the job's result.
+func (a *application) runImportJob(ctx context.Context, job *jobs.Job, payload importJobPayload) (any, error) {
+	body := bytes.NewReader(job.Input)
+
+	next, err := importRows(body, payload.Format)
+	if err != nil {
+		return nil, jobs.Permanent(err)
+	}
+
+	var userID int64
+	if job.UserID != nil {
+		userID = *job.UserID
+	}
+
+	afterChunk := func() error {
+		// Part of the file has been written, so stopping early can't be retried.
+		if err := ctx.Err(); err != nil {
+			return jobs.Permanent(err)
+		}
+
+		read := len(job.Input) - body.Len()
+
+		return job.SetProgress(100 * read / max(len(job.Input), 1))
+	}
+
+	report, err := a.importMovies(next, payload.DryRun, userID, afterChunk)
+	if err != nil {
+		if errors.Is(err, errImportRead) {
+			return nil, jobs.Permanent(err)
+		}
+
+		return nil, err
+	}
+
+	return report, nil
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/captainmango/greenlight/internal/data"
+	"github.com/captainmango/greenlight/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (a *application) getListsHandler(w http.ResponseWriter, r *http.Request) {
+	lists, err := a.dao.Lists.GetAllForUser(a.contextGetUser(r).ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"lists": lists}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *application) createListHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name   string `json:"name"`
+		Public bool   `json:"public"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	list := &data.List{
+		UserID: a.contextGetUser(r).ID,
+		Name:   input.Name,
+		Public: input.Public,
+	}
+
+	v := validator.New()
+
+	if data.ValidateList(v, list); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.dao.Lists.Insert(list)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateListName):
+			v.AddError("name", "you already have a list with this name")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/lists/%d", list.ID))
+
+	err = a.writeJSON(w, http.StatusCreated, envelope{"list": list}, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// readOwnList loads the list from the URL for its owner. Other users get a 404 rather
+// than a 403 so private lists can't be discovered by ID. It writes the error response
+// itself, so callers should just return when ok is false.
+func (a *application) readOwnList(w http.ResponseWriter, r *http.Request) (*data.List, bool) {
+	id, err := a.readIdParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	list, err := a.dao.Lists.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if list.UserID != a.contextGetUser(r).ID {
+		a.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	return list, true
+}
+
+func (a *application) showListHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := a.readOwnList(w, r)
+	if !ok {
+		return
+	}
+
+	var err error
+
+	list.Items, err = a.dao.Lists.GetItems(list.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"list": list}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *application) showSharedListHandler(w http.ResponseWriter, r *http.Request) {
+	slug := httprouter.ParamsFromContext(r.Context()).ByName("slug")
+
+	list, err := a.dao.Lists.GetPublicBySlug(slug)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	list.Items, err = a.dao.Lists.GetItems(list.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"list": list}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *application) updateListHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name   *string `json:"name"`
+		Public *bool   `json:"public"`
+	}
+
+	list, ok := a.readOwnList(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		list.Name = *input.Name
+	}
+
+	if input.Public != nil {
+		list.Public = *input.Public
+	}
+
+	v := validator.New()
+
+	if data.ValidateList(v, list); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.dao.Lists.Update(list)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConfilctResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateListName):
+			v.AddError("name", "you already have a list with this name")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"list": list}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *application) deleteListHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := a.readOwnList(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.dao.Lists.Delete(list.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"message": "successfully deleted list"}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *application) addListItemHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MovieID  int64 `json:"movie_id"`
+		Position int32 `json:"position"`
+	}
+
+	list, ok := a.readOwnList(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.MovieID > 0, "movie_id", "must be provided")
+	v.Check(input.Position >= 0, "position", "must not be negative")
+
+	if !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.dao.Lists.AddItem(list.ID, input.MovieID, input.Position)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("movie_id", "must be an existing movie")
+			a.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateListItem):
+			v.AddError("movie_id", "this movie is already on the list")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	a.writeListItems(w, r, list, http.StatusCreated)
+}
+
+func (a *application) removeListItemHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := a.readOwnList(w, r)
+	if !ok {
+		return
+	}
+
+	movieID, err := a.readInt64Param(r, "movie_id")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.dao.Lists.RemoveItem(list.ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	a.writeListItems(w, r, list, http.StatusOK)
+}
+
+func (a *application) reorderListItemsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MovieIDs []int64 `json:"movie_ids"`
+	}
+
+	list, ok := a.readOwnList(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	items, err := a.dao.Lists.GetItems(list.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateListOrder(v, input.MovieIDs, items); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.dao.Lists.Reorder(list.ID, input.MovieIDs)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	a.writeListItems(w, r, list, http.StatusOK)
+}
+
+// writeListItems responds with the list and its items after they have been changed.
+func (a *application) writeListItems(w http.ResponseWriter, r *http.Request, list *data.List, status int) {
+	var err error
+
+	list.Items, err = a.dao.Lists.GetItems(list.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, status, envelope{"list": list}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
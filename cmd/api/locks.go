@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/captainmango/greenlight/internal/data"
+	"github.com/captainmango/greenlight/internal/validator"
+)
+
+// minLockTTL stops clients from taking leases so short they'd spend all their time
+// renewing them.
+const minLockTTL = 30 * time.Second
+
+// acquireMovieLockHandler takes the edit lock on a movie, or renews it if the user
+// already holds it. The lease lasts for "ttl" (a Go duration like "90s") or the
+// default, and has to be renewed before then to keep it.
+func (a *application) acquireMovieLockHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TTL *string `json:"ttl"`
+	}
+
+	movieId, err := a.readIdParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	// The body is optional, so a bare POST takes the lock with the default TTL.
+	if r.ContentLength != 0 {
+		if err := a.readJSON(w, r, &input); err != nil {
+			a.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	// Read the config once, so a reload can't change the limits halfway through.
+	cfg := a.config()
+	ttl := cfg.locks.ttl
+
+	v := validator.New()
+
+	if input.TTL != nil {
+		d, err := time.ParseDuration(*input.TTL)
+		v.Check(err == nil, "ttl", "must be a duration like 90s or 5m")
+		v.Check(err != nil || d >= minLockTTL, "ttl", fmt.Sprintf("must be at least %s", minLockTTL))
+		v.Check(err != nil || d <= cfg.locks.maxTTL, "ttl", fmt.Sprintf("must not be more than %s", cfg.locks.maxTTL))
+		ttl = d
+	}
+
+	if !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	lock, renewed, err := a.dao.Locks.Acquire(movieId, a.contextGetUser(r).ID, ttl)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrMovieLocked):
+			a.movieLockedResponse(w, r, lock)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	status := http.StatusCreated
+	if renewed {
+		status = http.StatusOK
+	}
+
+	err = a.writeJSON(w, status, envelope{"lock": lock}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *application) showMovieLockHandler(w http.ResponseWriter, r *http.Request) {
+	movieId, err := a.readIdParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	lock, err := a.dao.Locks.Get(movieId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"lock": lock}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// releaseMovieLockHandler gives up the lock on a movie. Admins can also break a lock
+// someone else holds, for when an editor has wandered off.
+func (a *application) releaseMovieLockHandler(w http.ResponseWriter, r *http.Request) {
+	movieId, err := a.readIdParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	user := a.contextGetUser(r)
+
+	lock, err := a.dao.Locks.Release(movieId, user.ID, false)
+	if errors.Is(err, data.ErrMovieLocked) {
+		permissions, permErr := a.dao.Permissions.GetAllForUser(user.ID)
+		if permErr != nil {
+			a.serverErrorResponse(w, r, permErr)
+			return
+		}
+
+		if permissions.Include(data.PermissionAdmin) {
+			lock, err = a.dao.Locks.Release(movieId, user.ID, true)
+		}
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrMovieLocked):
+			a.movieLockedResponse(w, r, lock)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"message": "lock successfully released"}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// heldMovieLock is the lock check shared by REST, GraphQL and gRPC before a movie is
+// changed. If someone other than user holds the lock, it returns ErrMovieLocked along
+// with their lock, and each API turns that into its own error.
+func (a *application) heldMovieLock(movieID int64, user *data.User) (*data.MovieLock, error) {
+	lock, err := a.dao.Locks.Get(movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	if !user.IsAnonymous() && lock.UserID == user.ID {
+		return nil, nil
+	}
+
+	return lock, data.ErrMovieLocked
+}
+
+// checkMovieLock is called by handlers that change a movie. If someone other than the
+// current user holds the lock, it sends a 423 naming them and returns false.
+func (a *application) checkMovieLock(w http.ResponseWriter, r *http.Request, movieID int64) bool {
+	lock, err := a.heldMovieLock(movieID, a.contextGetUser(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrMovieLocked):
+			a.movieLockedResponse(w, r, lock)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+
+	return true
+}
+
+// reapMovieLocks deletes expired locks. They already stopped counting when they
+// expired; deleting them is what tells WebSocket clients the movie is free again.
+func (a *application) reapMovieLocks(ctx context.Context) {
+	ticker := time.NewTicker(a.config().locks.reapInterval)
+	defer ticker.Stop()
+
+	for {
+		reaped, err := a.dao.Locks.DeleteExpired()
+		if err != nil {
+			a.logger.Error(err.Error(), "job", "reap_movie_locks")
+		} else if reaped > 0 {
+			a.logger.Info("reaped expired movie locks", "job", "reap_movie_locks", "count", reaped)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -3,14 +3,17 @@ package main
 import (
 	"context"
 	"database/sql"
-	"flag"
-	"fmt"
+	"errors"
+	"io/fs"
 	"log/slog"
-	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/captainmango/greenlight/internal/data"
+	"github.com/captainmango/greenlight/internal/jobs"
+	"github.com/graphql-go/graphql"
 
 	// Import the pq driver so that it can register itself with the database/sql
 	// package. Note that we alias this import to the blank identifier, to stop the Go
@@ -22,48 +25,60 @@ import (
 const version = "1.0.0"
 
 type (
-	config struct {
-		port int
-		env  string
-		db   struct {
-			dsn          string
-			maxOpenConns int
-			maxIdleConns int
-			maxIdleTime  time.Duration
-		}
-	}
-
 	application struct {
-		config config
-		logger *slog.Logger
-		dao    data.DataAccessObjects
+		// current is read with a.config(), since a reload can swap it at any time.
+		current  atomic.Pointer[config]
+		reloader configReloader
+		logLevel *slog.LevelVar
+		logger   *slog.Logger
+		dao      data.DataAccessObjects
+		jobs     *jobs.Queue
+		events   *movieEventHub
+		graphql  graphql.Schema
+		// websockets holds a token for every open WebSocket connection, which caps
+		// how many there can be.
+		websockets chan struct{}
+		wg         sync.WaitGroup
 	}
 )
 
 func main() {
-	// Create the logger
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	// Create the logger. Its level is set once the config has been read, and again
+	// whenever it is reloaded.
+	logLevel := new(slog.LevelVar)
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
 	slog.SetDefault(logger)
-	var cfg config
+
+	// .env is a convenience for development, so it's fine for it not to be there.
 	err := godotenv.Load()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logger.Error("Failed to read .env", "error", err.Error())
+		os.Exit(1)
+	}
 
+	// `api worker [flags]` only runs background jobs. The subcommand has to come off
+	// the arguments before the flags are parsed.
+	args := os.Args[1:]
+	worker := len(args) > 0 && args[0] == "worker"
+	if worker {
+		args = args[1:]
+	}
+
+	cfg, printConfig, err := loadConfig(os.Args[0], args)
 	if err != nil {
-		logger.Error("Failed to read .env")
+		logger.Error(err.Error())
 		os.Exit(1)
 	}
 
-	/*
-		Get vars passed in as flags and set on a struct to be read later. Defaults provided
-	*/
-	flag.IntVar(&cfg.port, "port", 4000, "API server port")
-	flag.StringVar(&cfg.env, "env", os.Getenv("ENVIRONMENT"), "Environment (development|staging|production)")
-	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("PG_DSN"), "PostgreSQL DSN")
-	// Read the connection pool settings from command-line flags into the config struct.
-	// Notice that the default values we're using are the ones we discussed above?
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
-	flag.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL max connection idle time")
-	flag.Parse()
+	if printConfig {
+		if err := writeConfig(os.Stdout, cfg.values); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	logLevel.Set(cfg.logLevel())
 
 	db, err := openDB(cfg)
 	if err != nil {
@@ -81,26 +96,38 @@ func main() {
 	logger.Info("Established connection pool for database")
 
 	// Create the application. Could have embedded the config, but we want to use DI to access these things really
+	dao := data.NewDataAccessObjects(db)
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		dao:    data.NewDataAccessObjects(db),
+		reloader: configReloader{name: os.Args[0], args: args},
+		logLevel: logLevel,
+		logger:   logger,
+		dao:      dao,
+		jobs:     jobs.New(db, logger),
+		events:   newMovieEventHub(cfg.db.dsn, dao.Events, logger),
+
+		websockets: make(chan struct{}, cfg.websockets.maxConnections),
 	}
+	app.current.Store(&cfg)
+
+	app.registerJobs()
 
-	server := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.port),
-		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
+	app.graphql, err = app.graphqlSchema()
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
 
-	logger.Info("starting server", "addr", server.Addr, "env", cfg.env)
+	if worker {
+		err = app.runWorker()
+	} else {
+		err = app.serve()
+	}
 
-	err = server.ListenAndServe()
-	logger.Error(err.Error())
-	os.Exit(1)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
 }
 
 func openDB(cfg config) (*sql.DB, error) {
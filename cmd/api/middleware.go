@@ -1,8 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
+
+	"github.com/captainmango/greenlight/internal/data"
+	"github.com/captainmango/greenlight/internal/validator"
+	"github.com/julienschmidt/httprouter"
 )
 
 func (app *application) recoverPanic(next http.Handler) http.Handler {
@@ -18,7 +27,7 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 				// automatically close the current connection after a response has been
 				// sent.
 				w.Header().Set("Connection", "close")
-				
+
 				// The value returned by recover() has the type any, so we use
 				// fmt.Errorf() to normalize it into an error and call our
 				// serverErrorResponse() helper. In turn, this will log the error using
@@ -30,3 +39,280 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// authenticate adds the user that owns the bearer token to the request context. Requests
+// without an Authorization header carry on as the AnonymousUser so that handlers can
+// decide for themselves whether they need a real user.
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Responses depend on the Authorization header, so tell any caches about it.
+		w.Header().Add("Vary", "Authorization")
+
+		authorizationHeader := r.Header.Get("Authorization")
+
+		if authorizationHeader == "" {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := app.userForAuthorization(authorizationHeader)
+		if err != nil {
+			switch {
+			case errors.Is(err, errInvalidAuthenticationToken):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		r = app.contextSetUser(r, user)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+var errInvalidAuthenticationToken = errors.New("invalid or missing authentication token")
+
+// userForAuthorization returns the user that owns the token in an Authorization value
+// of the form "Bearer <token>". It's shared by the REST and gRPC servers. Malformed,
+// unknown and expired tokens all give errInvalidAuthenticationToken.
+func (app *application) userForAuthorization(authorization string) (*data.User, error) {
+	headerParts := strings.Split(authorization, " ")
+	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		return nil, errInvalidAuthenticationToken
+	}
+
+	token := headerParts[1]
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+		return nil, errInvalidAuthenticationToken
+	}
+
+	user, err := app.dao.Users.GetForToken(data.ScopeAuthentication, token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, errInvalidAuthenticationToken
+		default:
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// idempotency makes POST requests that carry an Idempotency-Key header safe to retry.
+// The first request with a key is handled as normal and its response stored; repeats
+// get the stored response back. Keys belong to the user that sent them, so this has to
+// run after authenticate.
+func (app *application) idempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+
+		if r.Method != http.MethodPost || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > 255 {
+			app.badRequestResponse(w, r, errors.New("Idempotency-Key must not be more than 255 bytes long"))
+			return
+		}
+
+		hash := sha256.New()
+		fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.RequestURI())
+
+		// JSON bodies are read up front so they can be part of the fingerprint, then
+		// put back for the handler. They get the same 1MB limit as readJSON. Other
+		// bodies, like movie import uploads, can be far bigger, so they stream to the
+		// handler and are hashed as it reads them. Until then the fingerprint covers
+		// the type, which leaves out parameters like a multipart boundary, because
+		// that is picked at random for each request.
+		mediaType := app.readMediaType(r)
+
+		var streamed *hashingBody
+
+		if mediaType == "application/json" {
+			r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				app.badRequestResponse(w, r, err)
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			hash.Write(body)
+		} else {
+			fmt.Fprintf(hash, "%s\n", mediaType)
+
+			streamed = &hashingBody{ReadCloser: r.Body, hash: hash}
+			r.Body = streamed
+		}
+
+		fingerprint := hash.Sum(nil)
+
+		userID := app.contextGetUser(r).ID
+
+		record, err := app.dao.Idempotency.Reserve(userID, key, fingerprint, app.config().idempotency.ttl)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.idempotencyInFlightResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if record != nil {
+			// A stored response to a streamed body is only replayed for the same body,
+			// which means reading this one to the end. No handler takes more than an
+			// import, so anything longer can't match.
+			if streamed != nil && record.Status != 0 {
+				_, err = io.Copy(io.Discard, http.MaxBytesReader(w, streamed, maxImportBytes))
+				if err != nil {
+					var maxBytesError *http.MaxBytesError
+					if !errors.As(err, &maxBytesError) {
+						app.badRequestResponse(w, r, err)
+						return
+					}
+				}
+
+				fingerprint = hash.Sum(nil)
+			}
+
+			switch {
+			case !bytes.Equal(record.Fingerprint, fingerprint):
+				app.idempotencyKeyReusedResponse(w, r)
+			case record.Status == 0:
+				app.idempotencyInFlightResponse(w, r)
+			default:
+				for name, values := range record.Header {
+					w.Header()[name] = values
+				}
+
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(record.Status)
+				w.Write(record.Body)
+			}
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w, request: streamed}
+
+		// Server errors (and panics, which recoverPanic turns into a 500 further out)
+		// release the key, so the client can retry with it.
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+
+			if err := app.dao.Idempotency.Release(userID, key); err != nil {
+				app.logError(r, err)
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		if rec.status >= http.StatusInternalServerError {
+			return
+		}
+
+		// A response to a streamed body is stored against all of it. If the handler
+		// left too much unread, like when it turned the upload away, the key is
+		// released instead.
+		if streamed != nil {
+			if !streamed.finish() {
+				return
+			}
+
+			fingerprint = hash.Sum(nil)
+		}
+
+		err = app.dao.Idempotency.Complete(userID, key, fingerprint, rec.status, rec.header, rec.body.Bytes())
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+
+		completed = true
+	})
+}
+
+// staticID serves fixed paths like /v1/movies/batch. httprouter won't register those
+// next to a /v1/movies/:id wildcard for the same method, so they're matched by the
+// wildcard and picked out here by name. Anything else goes to next.
+func (app *application) staticID(routes map[string]http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := httprouter.ParamsFromContext(r.Context()).ByName("id")
+
+		if handler, ok := routes[name]; ok {
+			handler(w, r)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requirePermission wraps requireAuthenticatedUser, so the permission check only ever
+// runs for real users.
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !app.checkPermission(w, r, code) {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return app.requireAuthenticatedUser(fn)
+}
+
+// checkPermission is for handlers that only need a permission for some requests (like
+// DELETE with ?hard=true). It writes the error response itself and returns false if
+// the current user doesn't hold the permission.
+func (app *application) checkPermission(w http.ResponseWriter, r *http.Request, code string) bool {
+	user := app.contextGetUser(r)
+
+	if user.IsAnonymous() {
+		app.authenticationRequiredResponse(w, r)
+		return false
+	}
+
+	permissions, err := app.dao.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !permissions.Include(code) {
+		app.notPermittedResponse(w, r)
+		return false
+	}
+
+	return true
+}
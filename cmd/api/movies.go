@@ -1,11 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"slices"
 
 	"github.com/captainmango/greenlight/internal/data"
+	"github.com/captainmango/greenlight/internal/jsonpatch"
 	"github.com/captainmango/greenlight/internal/validator"
 )
 
@@ -33,13 +39,18 @@ func (a *application) createMovieHandler(w http.ResponseWriter, r *http.Request)
 
 	v := validator.New()
 
-	if data.ValidateMovieJSON(v, movie); !v.Valid() {
+	if err = a.validateMovie(v, movie); err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
 		a.failedValidationResponse(w, r, v.Errors)
 
 		return
 	}
 
-	if err = a.dao.Movies.Insert(movie); err != nil {
+	if err = a.dao.Movies.Insert(movie, a.contextGetUser(r).ID); err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
 	}
@@ -77,6 +88,15 @@ func (a *application) showMovieHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Related data is opt-in with ?include=credits to keep the default payload small.
+	if slices.Contains(a.readCSV(r.URL.Query(), "include", nil), "credits") {
+		movie.Credits, err = a.dao.Credits.GetForMovie(movie.ID)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
 	err = a.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
 
 	if err != nil {
@@ -100,6 +120,10 @@ func (a *application) updateMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if !a.checkMovieLock(w, r, movieId) {
+		return
+	}
+
 	movie, err := a.dao.Movies.Get(movieId)
 	if err != nil {
 		switch {
@@ -108,38 +132,206 @@ func (a *application) updateMovieHandler(w http.ResponseWriter, r *http.Request)
 		default:
 			a.serverErrorResponse(w, r, err)
 		}
+
+		return
+	}
+
+	// The patch format is picked from the Content-Type. Plain JSON keeps the original
+	// behaviour where omitted (or null) fields are left alone.
+	switch mediaType := a.readMediaType(r); mediaType {
+	case "application/merge-patch+json", "application/json-patch+json":
+		err = a.applyMoviePatch(w, r, mediaType, movie)
+		if err != nil {
+			switch {
+			case errors.Is(err, jsonpatch.ErrTestFailed):
+				a.patchTestFailedResponse(w, r, err)
+			default:
+				a.badRequestResponse(w, r, err)
+			}
+			return
+		}
+	case "application/json":
+		err = a.readJSON(w, r, &updateMovieJson)
+		if err != nil {
+			a.badRequestResponse(w, r, err)
+			return
+		}
+
+		if updateMovieJson.Title != nil {
+			movie.Title = *updateMovieJson.Title
+		}
+
+		if updateMovieJson.Genres != nil {
+			movie.Genres = updateMovieJson.Genres
+		}
+
+		if updateMovieJson.Year != nil {
+			movie.Year = *updateMovieJson.Year
+		}
+
+		if updateMovieJson.Runtime != nil {
+			movie.Runtime = *updateMovieJson.Runtime
+		}
+	default:
+		a.unsupportedMediaTypeResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	if err = a.validateMovie(v, movie); err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
 	}
 
-	err = a.readJSON(w, r, &updateMovieJson)
+	err = a.dao.Movies.Update(movie, a.contextGetUser(r).ID)
 	if err != nil {
-		a.badRequestResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConfilctResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// replaceMovieHandler handles PUT, which replaces every editable field of a movie. The
+// version being replaced must be given in If-Match or as "version" in the body. A
+// version of zero (or If-None-Match: *) creates the movie under the ID in the URL
+// instead, so sync jobs can push movies they have already numbered.
+func (a *application) replaceMovieHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title   string       `json:"title"`
+		Year    int32        `json:"year"`
+		Runtime data.Runtime `json:"runtime"`
+		Genres  []string     `json:"genres"`
+		Version *int32       `json:"version"`
+	}
+
+	movieId, err := a.readIdParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
 		return
 	}
 
+	if !a.checkMovieLock(w, r, movieId) {
+		return
+	}
+
+	headerVersion, hasHeader, err := a.readIfMatchVersion(r)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
 
-	if updateMovieJson.Title != nil {
-		movie.Title = *updateMovieJson.Title
+	if r.Header.Get("If-None-Match") == "*" {
+		headerVersion, hasHeader = 0, true
 	}
 
-	if updateMovieJson.Genres != nil {
-		movie.Genres = updateMovieJson.Genres
+	err = a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
 	}
 
-	if updateMovieJson.Year != nil {
-		movie.Year = *updateMovieJson.Year
+	var expectedVersion int32
+
+	switch {
+	case hasHeader && input.Version != nil && *input.Version != headerVersion:
+		a.badRequestResponse(w, r, errors.New("the version in the body does not match the request precondition"))
+		return
+	case hasHeader:
+		expectedVersion = headerVersion
+	case input.Version != nil:
+		expectedVersion = *input.Version
+	default:
+		a.preconditionRequiredResponse(w, r)
+		return
 	}
 
-	if updateMovieJson.Runtime != nil {
-		movie.Runtime = *updateMovieJson.Runtime
+	movie := &data.Movie{
+		ID:      movieId,
+		Title:   input.Title,
+		Year:    input.Year,
+		Runtime: input.Runtime,
+		Genres:  input.Genres,
 	}
 
 	v := validator.New()
-	if data.ValidateMovieJSON(v, movie); !v.Valid() {
+
+	if err = a.validateMovie(v, movie); err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v.Check(expectedVersion >= 0, "version", "must not be negative")
+
+	if !v.Valid() {
 		a.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = a.dao.Movies.Update(movie)
+	current, err := a.dao.Movies.Get(movieId)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if expectedVersion == 0 {
+		// A retried create that already went through is answered with the movie as
+		// it stands, rather than a conflict, so sync jobs can safely repeat requests.
+		if current != nil && sameMovieFields(current, movie) {
+			err = a.writeJSON(w, http.StatusOK, envelope{"movie": current}, nil)
+			if err != nil {
+				a.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		err = a.dao.Movies.InsertWithID(movie, a.contextGetUser(r).ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				a.editConfilctResponse(w, r)
+			default:
+				a.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		headers := make(http.Header)
+		headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+
+		err = a.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if current == nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	movie.Version = expectedVersion
+	movie.CreatedAt = current.CreatedAt
+	movie.AverageRating = current.AverageRating
+	movie.RatingCount = current.RatingCount
+
+	err = a.dao.Movies.Update(movie, a.contextGetUser(r).ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -147,17 +339,23 @@ func (a *application) updateMovieHandler(w http.ResponseWriter, r *http.Request)
 		default:
 			a.serverErrorResponse(w, r, err)
 		}
-
 		return
 	}
 
 	err = a.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
-		return
 	}
 }
 
+// sameMovieFields reports whether two movies have the same editable fields.
+func sameMovieFields(a, b *data.Movie) bool {
+	return a.Title == b.Title &&
+		a.Year == b.Year &&
+		a.Runtime == b.Runtime &&
+		slices.Equal(a.Genres, b.Genres)
+}
+
 func (a *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
 	movieId, err := a.readIdParam(r)
 	if err != nil {
@@ -165,7 +363,22 @@ func (a *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = a.dao.Movies.Delete(movieId)
+	if !a.checkMovieLock(w, r, movieId) {
+		return
+	}
+
+	// By default movies go to the trash. Admins can skip it with ?hard=true.
+	hard := r.URL.Query().Get("hard") == "true"
+
+	if hard {
+		if !a.checkPermission(w, r, data.PermissionAdmin) {
+			return
+		}
+
+		err = a.dao.Movies.HardDelete(movieId, a.contextGetUser(r).ID)
+	} else {
+		err = a.dao.Movies.Delete(movieId, a.contextGetUser(r).ID)
+	}
 
 	if err != nil {
 		switch {
@@ -184,11 +397,158 @@ func (a *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// getMoviesHandler lists the movies matching the filters. Paging is opt in: without
+// page or page_size every match comes back, with no metadata, as it always has.
 func (a *application) getMoviesHandler(w http.ResponseWriter, r *http.Request) {
-	movies, err := a.dao.Movies.GetAll()
+	v := validator.New()
+	qs := r.URL.Query()
+
+	title, genres, filters := a.readMovieFilters(qs, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	paged := qs.Has("page") || qs.Has("page_size")
+	if !paged {
+		filters.PageSize = 0
+	}
+
+	movies, metadata, err := a.dao.Movies.GetAll(title, genres, filters)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
+		return
 	}
 
-	a.writeJSON(w, http.StatusOK, envelope{"movies": movies}, nil)
+	// Credits for every movie on the page are fetched with a single query, rather
+	// than one query per movie.
+	if slices.Contains(a.readCSV(r.URL.Query(), "include", nil), "credits") {
+		ids := make([]int64, len(movies))
+		for i := range movies {
+			ids[i] = movies[i].ID
+		}
+
+		credits, err := a.dao.Credits.GetForMovies(ids)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+
+		for i := range movies {
+			movies[i].Credits = credits[movies[i].ID]
+		}
+	}
+
+	env := envelope{"movies": movies}
+	if paged {
+		env["metadata"] = metadata
+	}
+
+	a.writeJSON(w, http.StatusOK, env, nil)
+}
+
+// movieSortSafelist is shared by every way of listing movies: REST, exports and
+// GraphQL.
+var movieSortSafelist = []string{
+	"id", "title", "year", "runtime", "average_rating",
+	"-id", "-title", "-year", "-runtime", "-average_rating",
+}
+
+// readMovieFilters reads the query string parameters shared by the movie list and
+// export endpoints. Bad integers are recorded on v; call data.ValidateFilters after.
+func (a *application) readMovieFilters(qs url.Values, v *validator.Validator) (string, []string, data.Filters) {
+	title := a.readString(qs, "title", "")
+	genres := a.readCSV(qs, "genres", []string{})
+
+	filters := data.Filters{
+		Page:         a.readInt(qs, "page", 1, v),
+		PageSize:     a.readInt(qs, "page_size", 20, v),
+		Sort:         a.readString(qs, "sort", "id"),
+		SortSafelist: movieSortSafelist,
+	}
+
+	return title, genres, filters
+}
+
+// applyMoviePatch applies a JSON Merge Patch (RFC 7396) or JSON Patch (RFC 6902) body
+// to the editable fields of movie. The patch is applied to a JSON copy of the movie and
+// the result decoded back, so nothing on movie changes unless the whole patch applies.
+// In a merge patch null removes the field, leaving it at its zero value for validation
+// to deal with.
+func (a *application) applyMoviePatch(w http.ResponseWriter, r *http.Request, mediaType string, movie *data.Movie) error {
+	maxBytes := 1_048_576
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+		}
+
+		return err
+	}
+
+	doc, err := json.Marshal(data.MovieJSON{
+		Title:   movie.Title,
+		Year:    movie.Year,
+		Runtime: movie.Runtime,
+		Genres:  movie.Genres,
+	})
+	if err != nil {
+		return err
+	}
+
+	switch mediaType {
+	case "application/merge-patch+json":
+		var patch map[string]json.RawMessage
+
+		if err = a.decodeJSON(bytes.NewReader(body), &patch); err != nil {
+			return err
+		}
+
+		doc, err = jsonpatch.MergePatch(doc, body)
+	default:
+		var ops []jsonpatch.Operation
+
+		if err = a.decodeJSON(bytes.NewReader(body), &ops); err != nil {
+			return err
+		}
+
+		doc, err = jsonpatch.Apply(doc, ops)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var patched data.MovieJSON
+
+	if err = a.decodeJSON(bytes.NewReader(doc), &patched); err != nil {
+		return err
+	}
+
+	movie.Title = patched.Title
+	movie.Year = patched.Year
+	movie.Runtime = patched.Runtime
+	movie.Genres = patched.Genres
+
+	return nil
+}
+
+// validateMovie runs the field rules from ValidateMovieJSON and then checks the genres
+// against the managed vocabulary. Any returned error is from the database lookup, not
+// the movie itself; validation failures end up in v.
+func (a *application) validateMovie(v *validator.Validator, movie *data.Movie) error {
+	data.ValidateMovieJSON(v, movie)
+
+	permitted, err := a.dao.Genres.Slugs()
+	if err != nil {
+		return err
+	}
+
+	data.ValidateMovieGenres(v, movie.Genres, permitted)
+
+	return nil
 }
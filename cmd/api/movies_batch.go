@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/captainmango/greenlight/internal/data"
+	"github.com/captainmango/greenlight/internal/validator"
+)
+
+// batchResult is the outcome of one operation in a batch. Status is the HTTP status
+// the operation would have got as a single request.
+type batchResult struct {
+	Op     string                     `json:"op"`
+	Status int                        `json:"status"`
+	ID     int64                      `json:"id,omitempty"`
+	Movie  *data.Movie                `json:"movie,omitempty"`
+	Errors validator.ValidationErrors `json:"error,omitempty"`
+}
+
+// batchMoviesHandler creates, updates and deletes movies in one request. Updates
+// replace every field, like PUT, and need the version being replaced. By default the
+// batch is all or nothing; with ?atomic=false the valid operations are applied and each
+// gets its own status in the results.
+func (a *application) batchMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Operations []struct {
+			Op      string          `json:"op"`
+			ID      int64           `json:"id"`
+			Version int32           `json:"version"`
+			Movie   *data.MovieJSON `json:"movie"`
+		} `json:"operations"`
+	}
+
+	v := validator.New()
+
+	atomic := a.readString(r.URL.Query(), "atomic", "true")
+	v.Check(validator.PermittedValue(atomic, "true", "false"), "atomic", "must be true or false")
+
+	if !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v.Check(len(input.Operations) > 0, "operations", "must contain at least 1 operation")
+	v.Check(len(input.Operations) <= data.MaxMovieBatchSize, "operations", fmt.Sprintf("must not contain more than %d operations", data.MaxMovieBatchSize))
+
+	if !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	permitted, err := a.dao.Genres.Slugs()
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	results := make([]batchResult, len(input.Operations))
+	seen := make(map[int64]bool)
+
+	// Only operations that pass validation are sent to the database. indexes maps each
+	// of them back to its place in the request.
+	var (
+		ops     []data.MovieBatchOp
+		indexes []int
+	)
+
+	for i, operation := range input.Operations {
+		opV := validator.New()
+		movie := data.Movie{ID: operation.ID, Version: operation.Version}
+
+		if operation.Movie != nil {
+			movie.Title = operation.Movie.Title
+			movie.Year = operation.Movie.Year
+			movie.Runtime = operation.Movie.Runtime
+			movie.Genres = operation.Movie.Genres
+		}
+
+		switch operation.Op {
+		case data.BatchCreate, data.BatchUpdate:
+			if operation.Op == data.BatchUpdate {
+				opV.Check(operation.ID > 0, "id", "must be provided")
+				opV.Check(operation.Version > 0, "version", "must be provided")
+			}
+
+			if opV.Check(operation.Movie != nil, "movie", "must be provided"); operation.Movie != nil {
+				data.ValidateMovieJSON(opV, &movie)
+				data.ValidateMovieGenres(opV, movie.Genres, permitted)
+			}
+		case data.BatchDelete:
+			opV.Check(operation.ID > 0, "id", "must be provided")
+		default:
+			opV.AddError("op", "must be one of create, update or delete")
+		}
+
+		if operation.ID > 0 {
+			opV.Check(!seen[operation.ID], "id", "must not appear more than once in a batch")
+			seen[operation.ID] = true
+		}
+
+		results[i] = batchResult{Op: operation.Op, ID: operation.ID}
+
+		if !opV.Valid() {
+			for key, message := range opV.Errors {
+				v.AddError(fmt.Sprintf("operations[%d].%s", i, key), message)
+			}
+
+			results[i].Status = http.StatusUnprocessableEntity
+			results[i].Errors = opV.Errors
+			continue
+		}
+
+		ops = append(ops, data.MovieBatchOp{Op: operation.Op, Movie: movie})
+		indexes = append(indexes, i)
+	}
+
+	if atomic == "true" && !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Updates and deletes of movies someone else has locked fail like they would on
+	// their own.
+	ops, indexes, err = a.dropLockedBatchOps(r, ops, indexes, results, v)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if atomic == "true" && !v.Valid() {
+		a.errorResponse(w, r, http.StatusLocked, v.Errors)
+		return
+	}
+
+	var opErrors []error
+
+	if len(ops) > 0 {
+		opErrors, err = a.dao.Movies.Batch(ops, a.contextGetUser(r).ID, atomic == "true")
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	failures := make(map[string]string)
+
+	// An atomic batch that failed is a 404 if every failure was a missing movie, and a
+	// 409 if any was a version clash.
+	failureStatus := http.StatusNotFound
+
+	for n, i := range indexes {
+		switch {
+		case errors.Is(opErrors[n], data.ErrEditConflict):
+			failureStatus = http.StatusConflict
+			results[i].Status = http.StatusConflict
+			results[i].Errors = validator.ValidationErrors{"version": "does not match the current version of the movie"}
+			failures[fmt.Sprintf("operations[%d].version", i)] = results[i].Errors["version"]
+		case errors.Is(opErrors[n], data.ErrRecordNotFound):
+			results[i].Status = http.StatusNotFound
+			results[i].Errors = validator.ValidationErrors{"id": "must be an existing movie"}
+			failures[fmt.Sprintf("operations[%d].id", i)] = results[i].Errors["id"]
+		case ops[n].Op == data.BatchCreate:
+			results[i].Status = http.StatusCreated
+			results[i].ID = ops[n].Movie.ID
+			results[i].Movie = &ops[n].Movie
+		case ops[n].Op == data.BatchUpdate:
+			results[i].Status = http.StatusOK
+			results[i].Movie = &ops[n].Movie
+		default:
+			results[i].Status = http.StatusOK
+		}
+	}
+
+	// In atomic mode any failure means the transaction was rolled back, so nothing in
+	// the batch was saved.
+	if atomic == "true" && len(failures) > 0 {
+		a.errorResponse(w, r, failureStatus, failures)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"results": results}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// dropLockedBatchOps takes out the updates and deletes of movies locked by someone
+// other than the current user, marking them 423 in results and adding them to v.
+func (a *application) dropLockedBatchOps(r *http.Request, ops []data.MovieBatchOp, indexes []int, results []batchResult, v *validator.Validator) ([]data.MovieBatchOp, []int, error) {
+	var ids []int64
+
+	for _, op := range ops {
+		if op.Op != data.BatchCreate {
+			ids = append(ids, op.Movie.ID)
+		}
+	}
+
+	if len(ids) == 0 {
+		return ops, indexes, nil
+	}
+
+	locks, err := a.dao.Locks.GetAll(ids)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user := a.contextGetUser(r)
+
+	var (
+		keptOps     []data.MovieBatchOp
+		keptIndexes []int
+	)
+
+	for n, op := range ops {
+		lock := locks[op.Movie.ID]
+
+		if op.Op == data.BatchCreate || lock == nil || (!user.IsAnonymous() && lock.UserID == user.ID) {
+			keptOps = append(keptOps, op)
+			keptIndexes = append(keptIndexes, indexes[n])
+			continue
+		}
+
+		i := indexes[n]
+		message := fmt.Sprintf("is locked for editing by %s", lock.UserName)
+
+		results[i].Status = http.StatusLocked
+		results[i].Errors = validator.ValidationErrors{"id": message}
+		v.AddError(fmt.Sprintf("operations[%d].id", i), message)
+	}
+
+	return keptOps, keptIndexes, nil
+}
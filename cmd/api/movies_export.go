@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/captainmango/greenlight/internal/data"
+	"github.com/captainmango/greenlight/internal/validator"
+)
+
+const (
+	exportFlushEvery    = 500
+	exportWriteDeadline = 30 * time.Second
+)
+
+// movieCSVHeader is the column order for CSV exports. Genres are joined with "|" so a
+// movie stays on one CSV field.
+var movieCSVHeader = []string{"id", "title", "year", "runtime", "genres", "version", "average_rating", "rating_count"}
+
+// exportMoviesHandler streams every movie matching the list filters as NDJSON (the
+// default) or CSV, picked with ?format= or the Accept header. Rows are written as they
+// come off the database cursor rather than built up in memory like writeJSON does.
+func (a *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	title, genres, filters := a.readMovieFilters(qs, v)
+
+	format := a.readString(qs, "format", "")
+	if format == "" {
+		format = "ndjson"
+		if strings.Contains(r.Header.Get("Accept"), "text/csv") {
+			format = "csv"
+		}
+	}
+
+	v.Check(validator.PermittedValue(format, "ndjson", "csv"), "format", "must be ndjson or csv")
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var (
+		writeRow func(*data.Movie) error
+		flush    func() error
+		rc       = http.NewResponseController(w)
+	)
+
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.csv"`)
+
+		// The header sits in the csv.Writer buffer until the first flush, so an early
+		// error can still be sent as a normal JSON response.
+		cw := csv.NewWriter(w)
+		cw.Write(movieCSVHeader)
+
+		writeRow = func(movie *data.Movie) error {
+			return cw.Write([]string{
+				strconv.FormatInt(movie.ID, 10),
+				movie.Title,
+				strconv.Itoa(int(movie.Year)),
+				strconv.Itoa(int(movie.Runtime)) + " mins",
+				strings.Join(movie.Genres, "|"),
+				strconv.Itoa(int(movie.Version)),
+				strconv.FormatFloat(movie.AverageRating, 'f', 2, 64),
+				strconv.Itoa(int(movie.RatingCount)),
+			})
+		}
+
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.ndjson"`)
+
+		enc := json.NewEncoder(w)
+
+		writeRow = func(movie *data.Movie) error {
+			return enc.Encode(movie)
+		}
+
+		flush = func() error {
+			return nil
+		}
+	}
+
+	// The server's WriteTimeout is far too short for a large export, so the deadline
+	// is pushed back every time a chunk is flushed instead. A client that stops
+	// reading will still be cut off.
+	extend := func() error {
+		if err := flush(); err != nil {
+			return err
+		}
+
+		if err := rc.Flush(); err != nil {
+			return err
+		}
+
+		return rc.SetWriteDeadline(time.Now().Add(exportWriteDeadline))
+	}
+
+	if err := rc.SetWriteDeadline(time.Now().Add(exportWriteDeadline)); err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Left alone, the server's ReadTimeout cancels the request context (and with it
+	// the database cursor) a few seconds into the export.
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	rows := 0
+
+	err := a.dao.Movies.Export(r.Context(), title, genres, filters, func(movie *data.Movie) error {
+		if err := writeRow(movie); err != nil {
+			return err
+		}
+
+		rows++
+		if rows%exportFlushEvery == 0 {
+			return extend()
+		}
+
+		return nil
+	})
+
+	if err == nil {
+		err = extend()
+	}
+
+	if err != nil {
+		// Once rows have gone out the status line has been sent, so all we can do is
+		// log the problem and cut the response short.
+		if rows == 0 && r.Context().Err() == nil {
+			w.Header().Del("Content-Disposition")
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+
+		a.logError(r, err)
+	}
+}
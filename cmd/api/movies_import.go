@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/captainmango/greenlight/internal/data"
+	"github.com/captainmango/greenlight/internal/validator"
+)
+
+const (
+	maxImportBytes     = 100 << 20
+	importChunkSize    = 500
+	maxImportErrors    = 1000
+	importReadDeadline = 30 * time.Second
+)
+
+// importRow is one movie read from an import file. Rows with an ID update that movie;
+// rows without one are created. A version, if given, must match the current movie.
+type importRow struct {
+	line   int
+	movie  data.Movie
+	errors validator.ValidationErrors
+}
+
+type importLineError struct {
+	Line   int                        `json:"line"`
+	Errors validator.ValidationErrors `json:"error"`
+}
+
+type importReport struct {
+	DryRun   bool              `json:"dry_run"`
+	Rows     int               `json:"rows"`
+	Inserted int               `json:"inserted"`
+	Updated  int               `json:"updated"`
+	Skipped  int               `json:"skipped"`
+	Errors   []importLineError `json:"errors"`
+}
+
+func (report *importReport) skip(line int, errors validator.ValidationErrors) {
+	report.Skipped++
+
+	// Only the first errors are kept, so a file full of bad rows can't make the
+	// report as large as the upload.
+	if errors != nil && len(report.Errors) < maxImportErrors {
+		report.Errors = append(report.Errors, importLineError{Line: line, Errors: errors})
+	}
+}
+
+// importMoviesHandler loads movies from a CSV or NDJSON file, sent either as the whole
+// request body or as the "file" part of a multipart form. The file is read as a stream
+// and written in chunks, so it isn't limited by readJSON's 1MB cap. Invalid rows are
+// skipped and reported by line, and with ?dry_run=true nothing is written at all.
+// With ?async=true the file is handed to a background job instead, and the response
+// points at the job to poll for the report.
+//
+// CSV files need a header row naming the title, year, runtime and genres columns (id
+// and version are optional), which is the layout the export endpoint produces.
+func (a *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	dryRun := a.readString(qs, "dry_run", "false")
+	v.Check(validator.PermittedValue(dryRun, "true", "false"), "dry_run", "must be true or false")
+
+	async := a.readString(qs, "async", "false")
+	v.Check(validator.PermittedValue(async, "true", "false"), "async", "must be true or false")
+
+	if !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Big files take longer than the server's read and write timeouts allow, so the
+	// deadlines are pushed back as each chunk is processed.
+	rc := http.NewResponseController(w)
+	extendDeadlines := func() error {
+		if err := rc.SetReadDeadline(time.Now().Add(importReadDeadline)); err != nil {
+			return err
+		}
+
+		return rc.SetWriteDeadline(time.Now().Add(2 * importReadDeadline))
+	}
+
+	if err := extendDeadlines(); err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	body, format, err := a.readImportFile(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnsupportedImport):
+			a.unsupportedMediaTypeResponse(w, r)
+		default:
+			a.badRequestResponse(w, r, importReadError(err))
+		}
+		return
+	}
+
+	if async == "true" {
+		a.enqueueImport(w, r, body, importJobPayload{Format: format, DryRun: dryRun == "true"})
+		return
+	}
+
+	next, err := importRows(body, format)
+	if err != nil {
+		v.AddError("file", err.Error())
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	report, err := a.importMovies(next, dryRun == "true", a.contextGetUser(r).ID, extendDeadlines)
+	if err != nil {
+		switch {
+		case errors.Is(err, errImportRead):
+			a.badRequestResponse(w, r, err)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"import": report}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+var errImportRead = errors.New("reading import")
+
+// importReadError gives the size limit error a clearer message. Other read errors are
+// passed back unchanged.
+func importReadError(err error) error {
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		return fmt.Errorf("import must not be larger than %d bytes", maxBytesError.Limit)
+	}
+
+	return err
+}
+
+// importRows returns a function reading one row at a time in the given format. An
+// error means the file can't be imported at all, like a CSV file missing a column.
+func importRows(body io.Reader, format string) (func() (*importRow, error), error) {
+	switch format {
+	case "csv":
+		return csvImportRows(body)
+	default:
+		return ndjsonImportRows(body), nil
+	}
+}
+
+// importMovies validates every row from next and writes the valid ones in chunks.
+// afterChunk is called once each chunk has been written, and stops the import if it
+// returns an error. Errors reading the file are wrapped with errImportRead; anything
+// else went wrong on our side.
+func (a *application) importMovies(next func() (*importRow, error), dryRun bool, userID int64, afterChunk func() error) (*importReport, error) {
+	permitted, err := a.dao.Genres.Slugs()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &importReport{DryRun: dryRun, Errors: []importLineError{}}
+	seen := make(map[int64]int)
+	chunk := make([]*importRow, 0, importChunkSize)
+
+	for {
+		row, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errImportRead, importReadError(err))
+		}
+
+		report.Rows++
+
+		if row.errors == nil {
+			rowV := validator.New()
+
+			data.ValidateMovieJSON(rowV, &row.movie)
+			data.ValidateMovieGenres(rowV, row.movie.Genres, permitted)
+			rowV.Check(row.movie.ID >= 0, "id", "must be a positive integer")
+
+			if row.movie.ID > 0 {
+				if first, ok := seen[row.movie.ID]; ok {
+					rowV.AddError("id", fmt.Sprintf("must not appear more than once in an import (first seen on line %d)", first))
+				} else {
+					seen[row.movie.ID] = row.line
+				}
+			}
+
+			if !rowV.Valid() {
+				row.errors = rowV.Errors
+			}
+		}
+
+		if row.errors != nil {
+			report.skip(row.line, row.errors)
+			continue
+		}
+
+		chunk = append(chunk, row)
+
+		if len(chunk) == importChunkSize {
+			if err = a.importChunk(chunk, report, userID); err != nil {
+				return nil, err
+			}
+
+			chunk = chunk[:0]
+
+			if err = afterChunk(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err = a.importChunk(chunk, report, userID); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// importChunk works out which rows create, update or leave a movie alone, and then
+// writes them with one batch. Rows that match their movie exactly are skipped without
+// an error. In a dry run the counts are worked out the same way, but nothing is saved.
+func (a *application) importChunk(chunk []*importRow, report *importReport, userID int64) error {
+	if len(chunk) == 0 {
+		return nil
+	}
+
+	var ids []int64
+	for _, row := range chunk {
+		if row.movie.ID > 0 {
+			ids = append(ids, row.movie.ID)
+		}
+	}
+
+	current, err := a.dao.Movies.GetMany(ids)
+	if err != nil {
+		return err
+	}
+
+	var (
+		ops  []data.MovieBatchOp
+		rows []*importRow
+	)
+
+	for _, row := range chunk {
+		if row.movie.ID == 0 {
+			ops = append(ops, data.MovieBatchOp{Op: data.BatchCreate, Movie: row.movie})
+			rows = append(rows, row)
+			continue
+		}
+
+		movie, ok := current[row.movie.ID]
+
+		switch {
+		case !ok:
+			report.skip(row.line, validator.ValidationErrors{"id": "must be an existing movie"})
+			continue
+		case row.movie.Version != 0 && row.movie.Version != movie.Version:
+			report.skip(row.line, validator.ValidationErrors{"version": "does not match the current version of the movie"})
+			continue
+		case sameMovieFields(movie, &row.movie):
+			report.skip(row.line, nil)
+			continue
+		}
+
+		row.movie.Version = movie.Version
+		ops = append(ops, data.MovieBatchOp{Op: data.BatchUpdate, Movie: row.movie})
+		rows = append(rows, row)
+	}
+
+	opErrors := make([]error, len(ops))
+
+	if !report.DryRun && len(ops) > 0 {
+		opErrors, err = a.dao.Movies.Batch(ops, userID, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	for n, op := range ops {
+		switch {
+		case errors.Is(opErrors[n], data.ErrEditConflict):
+			report.skip(rows[n].line, validator.ValidationErrors{"version": "does not match the current version of the movie"})
+		case op.Op == data.BatchCreate:
+			report.Inserted++
+		default:
+			report.Updated++
+		}
+	}
+
+	return nil
+}
+
+var errUnsupportedImport = errors.New("unsupported import format")
+
+// readImportFile finds the file to import and its format. Multipart forms are read
+// part by part with MultipartReader rather than ParseMultipartForm, so the upload is
+// streamed instead of being spooled to memory or disk first.
+func (a *application) readImportFile(r *http.Request) (io.Reader, string, error) {
+	switch a.readMediaType(r) {
+	case "text/csv":
+		return r.Body, "csv", nil
+	case "application/x-ndjson":
+		return r.Body, "ndjson", nil
+	case "multipart/form-data":
+	default:
+		return nil, "", errUnsupportedImport
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", err
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, "", errors.New(`multipart form must contain a "file" part`)
+		}
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		if part.FormName() != "file" {
+			continue
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+
+		switch {
+		case mediaType == "text/csv" || strings.EqualFold(filepath.Ext(part.FileName()), ".csv"):
+			return part, "csv", nil
+		case mediaType == "application/x-ndjson" || strings.EqualFold(filepath.Ext(part.FileName()), ".ndjson"):
+			return part, "ndjson", nil
+		default:
+			return nil, "", errUnsupportedImport
+		}
+	}
+}
+
+// csvImportRows reads the header row and returns a function that reads one movie at a
+// time. Columns are matched by name, and ones it doesn't know (like average_rating
+// from an export) are ignored.
+func csvImportRows(body io.Reader) (func() (*importRow, error), error) {
+	cr := csv.NewReader(body)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("must have a header row")
+		}
+
+		return nil, err
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, name := range []string{"title", "year", "runtime", "genres"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("must have a %q column", name)
+		}
+	}
+
+	next := func() (*importRow, error) {
+		record, err := cr.Read()
+		if err != nil {
+			var parseError *csv.ParseError
+			if errors.As(err, &parseError) {
+				return &importRow{
+					line:   parseError.Line,
+					errors: validator.ValidationErrors{"row": parseError.Err.Error()},
+				}, nil
+			}
+
+			return nil, err
+		}
+
+		line, _ := cr.FieldPos(0)
+		row := &importRow{line: line}
+		v := validator.New()
+
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+
+			return strings.TrimSpace(record[i])
+		}
+
+		if id := field("id"); id != "" {
+			n, err := strconv.ParseInt(id, 10, 64)
+			v.Check(err == nil && n > 0, "id", "must be a positive integer")
+			row.movie.ID = n
+		}
+
+		if version := field("version"); version != "" {
+			n, err := strconv.ParseInt(version, 10, 32)
+			v.Check(err == nil && n > 0, "version", "must be a positive integer")
+			row.movie.Version = int32(n)
+		}
+
+		if year := field("year"); year != "" {
+			n, err := strconv.ParseInt(year, 10, 32)
+			v.Check(err == nil, "year", "must be an integer")
+			row.movie.Year = int32(n)
+		}
+
+		if runtime := field("runtime"); runtime != "" {
+			row.movie.Runtime, err = data.ParseRuntime(runtime)
+			v.Check(err == nil, "runtime", data.ErrInvalidRuntimeFormat.Error())
+		}
+
+		row.movie.Title = field("title")
+
+		row.movie.Genres = []string{}
+		if genres := field("genres"); genres != "" {
+			for _, genre := range strings.Split(genres, "|") {
+				row.movie.Genres = append(row.movie.Genres, strings.TrimSpace(genre))
+			}
+		}
+
+		if !v.Valid() {
+			row.errors = v.Errors
+		}
+
+		return row, nil
+	}
+
+	return next, nil
+}
+
+// ndjsonImportRows returns a function that reads one movie per line. Blank lines are
+// skipped. Fields are the same as the movie JSON, and unknown ones are ignored.
+func ndjsonImportRows(body io.Reader) func() (*importRow, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1_048_576)
+
+	line := 0
+
+	return func() (*importRow, error) {
+		for scanner.Scan() {
+			line++
+
+			text := scanner.Bytes()
+			if len(strings.TrimSpace(string(text))) == 0 {
+				continue
+			}
+
+			var input struct {
+				ID      int64        `json:"id"`
+				Title   string       `json:"title"`
+				Year    int32        `json:"year"`
+				Runtime data.Runtime `json:"runtime"`
+				Genres  []string     `json:"genres"`
+				Version int32        `json:"version"`
+			}
+
+			row := &importRow{line: line}
+
+			err := json.Unmarshal(text, &input)
+			if err != nil {
+				var unmarshalTypeError *json.UnmarshalTypeError
+
+				switch {
+				case errors.Is(err, data.ErrInvalidRuntimeFormat):
+					row.errors = validator.ValidationErrors{"runtime": err.Error()}
+				case errors.As(err, &unmarshalTypeError) && unmarshalTypeError.Field != "":
+					row.errors = validator.ValidationErrors{unmarshalTypeError.Field: "has the wrong type"}
+				default:
+					row.errors = validator.ValidationErrors{"row": "must be a JSON object"}
+				}
+
+				return row, nil
+			}
+
+			row.movie = data.Movie{
+				ID:      input.ID,
+				Title:   input.Title,
+				Year:    input.Year,
+				Runtime: input.Runtime,
+				Genres:  input.Genres,
+				Version: input.Version,
+			}
+
+			return row, nil
+		}
+
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+
+		return nil, io.EOF
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"embed"
+	"net/http"
+)
+
+// The OpenAPI document and the page that renders it are compiled in, so the docs
+// always match the binary and the page doesn't need anything from a CDN.
+//
+//go:embed docs
+var docsFS embed.FS
+
+func (a *application) openapiHandler(w http.ResponseWriter, r *http.Request) {
+	a.serveDocsFile(w, r, "docs/openapi.json", "application/json")
+}
+
+func (a *application) docsHandler(w http.ResponseWriter, r *http.Request) {
+	a.serveDocsFile(w, r, "docs/index.html", "text/html; charset=utf-8")
+}
+
+func (a *application) serveDocsFile(w http.ResponseWriter, r *http.Request, name, contentType string) {
+	content, err := docsFS.ReadFile(name)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(content)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestOpenAPI fails when a route is added or removed without updating
+// docs/openapi.json, which is maintained by hand.
+func TestOpenAPI(t *testing.T) {
+	problems, err := (&application{}).checkOpenAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, problem := range problems {
+		t.Error(problem)
+	}
+}
+
+type openapiDocument struct {
+	Paths map[string]map[string]json.RawMessage `json:"paths"`
+}
+
+// checkOpenAPI compares the OpenAPI document with the router, returning a line for
+// each registered route with no operation in the document, and each operation the
+// router doesn't serve.
+func (a *application) checkOpenAPI() ([]string, error) {
+	content, err := docsFS.ReadFile("docs/openapi.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var doc openapiDocument
+
+	err = json.Unmarshal(content, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("docs/openapi.json: %w", err)
+	}
+
+	router, routes := a.router()
+
+	var problems []string
+
+	for _, route := range routes {
+		documented := false
+
+		for path, operations := range doc.Paths {
+			if _, ok := operations[strings.ToLower(route.method)]; ok && openapiPathFits(path, route.path) {
+				documented = true
+				break
+			}
+		}
+
+		if !documented {
+			problems = append(problems, fmt.Sprintf("%s %s is not in the OpenAPI document", route.method, route.path))
+		}
+	}
+
+	for path, operations := range doc.Paths {
+		// Any value will do for the parameters, since handlers aren't run.
+		example := path
+		for strings.Contains(example, "{") {
+			start, end := strings.Index(example, "{"), strings.Index(example, "}")
+			example = example[:start] + "1" + example[end+1:]
+		}
+
+		for method := range operations {
+			if !slices.Contains(openapiMethods, method) {
+				continue
+			}
+
+			if handle, _, _ := router.Lookup(strings.ToUpper(method), example); handle == nil {
+				problems = append(problems, fmt.Sprintf("%s %s is documented but has no route", strings.ToUpper(method), path))
+			}
+		}
+	}
+
+	slices.Sort(problems)
+
+	return problems, nil
+}
+
+// openapiMethods are the keys of an OpenAPI path item that are operations, rather
+// than shared parameters or descriptions.
+var openapiMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// openapiPathFits reports whether an OpenAPI path like /v1/movies/{id} documents the
+// router path /v1/movies/:id. Parameters must have the same names.
+func openapiPathFits(openapiPath, routerPath string) bool {
+	openapiSegments := strings.Split(openapiPath, "/")
+	routerSegments := strings.Split(routerPath, "/")
+
+	if len(openapiSegments) != len(routerSegments) {
+		return false
+	}
+
+	for i, segment := range routerSegments {
+		name, isParam := strings.CutPrefix(segment, ":")
+
+		switch {
+		case isParam && openapiSegments[i] == "{"+name+"}":
+		case !isParam && segment == openapiSegments[i]:
+		default:
+			return false
+		}
+	}
+
+	return true
+}
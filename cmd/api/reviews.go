@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/captainmango/greenlight/internal/data"
+	"github.com/captainmango/greenlight/internal/validator"
+)
+
+func (a *application) getMovieReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := a.readIdParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := data.Filters{
+		Page:         a.readInt(qs, "page", 1, v),
+		PageSize:     a.readInt(qs, "page_size", 20, v),
+		Sort:         a.readString(qs, "sort", "-created_at"),
+		SortSafelist: []string{"created_at", "helpful_count", "rating", "-created_at", "-helpful_count", "-rating"},
+	}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Check the movie exists so an unknown ID is a 404 rather than an empty list.
+	_, err = a.dao.Movies.Get(movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	reviews, metadata, err := a.dao.Reviews.GetAllForMovie(movieID, filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"reviews": reviews, "metadata": metadata}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *application) createMovieReviewHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Rating int32  `json:"rating"`
+		Body   string `json:"body"`
+	}
+
+	movieID, err := a.readIdParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := a.contextGetUser(r)
+
+	review := &data.Review{
+		MovieID:  movieID,
+		UserID:   user.ID,
+		UserName: user.Name,
+		Rating:   input.Rating,
+		Body:     input.Body,
+	}
+
+	v := validator.New()
+
+	if data.ValidateReview(v, review); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.dao.Reviews.Insert(review)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateReview):
+			v.AddError("review", "you have already reviewed this movie")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusCreated, envelope{"review": review}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// readOwnReview loads the review from the URL and checks it belongs to the current user.
+// It writes the error response itself, so callers should just return when ok is false.
+func (a *application) readOwnReview(w http.ResponseWriter, r *http.Request) (*data.Review, bool) {
+	movieID, err := a.readIdParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	reviewID, err := a.readInt64Param(r, "review_id")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	review, err := a.dao.Reviews.Get(movieID, reviewID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if review.UserID != a.contextGetUser(r).ID {
+		a.notPermittedResponse(w, r)
+		return nil, false
+	}
+
+	// If the client tells us which version it last saw, fail early rather than
+	// overwriting someone else's change.
+	version, ok, err := a.readIfMatchVersion(r)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return nil, false
+	}
+
+	if ok && version != review.Version {
+		a.editConfilctResponse(w, r)
+		return nil, false
+	}
+
+	return review, true
+}
+
+func (a *application) updateMovieReviewHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Rating *int32  `json:"rating"`
+		Body   *string `json:"body"`
+	}
+
+	review, ok := a.readOwnReview(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Rating != nil {
+		review.Rating = *input.Rating
+	}
+
+	if input.Body != nil {
+		review.Body = *input.Body
+	}
+
+	v := validator.New()
+
+	if data.ValidateReview(v, review); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.dao.Reviews.Update(review)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConfilctResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"review": review}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *application) deleteMovieReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := a.readOwnReview(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.dao.Reviews.Delete(review)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConfilctResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"message": "successfully deleted review"}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *application) voteMovieReviewHelpfulHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := a.readIdParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	reviewID, err := a.readInt64Param(r, "review_id")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	review, err := a.dao.Reviews.Get(movieID, reviewID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := a.contextGetUser(r)
+
+	v := validator.New()
+
+	if v.Check(review.UserID != user.ID, "review", "you cannot vote for your own review"); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.dao.Reviews.AddHelpfulVote(review, user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"review": review}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/captainmango/greenlight/internal/data"
+	"github.com/captainmango/greenlight/internal/validator"
+)
+
+func (a *application) getMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIdParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	revisions, err := a.dao.Revisions.GetAllForMovie(id)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Every movie has at least its insert revision, so nothing at all means the ID
+	// has never existed.
+	if len(revisions) == 0 {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"revisions": revisions}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *application) showMovieRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIdParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	version, err := a.readInt64Param(r, "version")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	revision, err := a.dao.Revisions.Get(id, int32(version))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"revision": revision}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *application) diffMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIdParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	from := a.readInt(qs, "from", 0, v)
+	to := a.readInt(qs, "to", 0, v)
+
+	v.Check(from > 0, "from", "must be provided")
+	v.Check(to > 0, "to", "must be provided")
+
+	if !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	fromRevision, err := a.dao.Revisions.Get(id, int32(from))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	toRevision, err := a.dao.Revisions.Get(id, int32(to))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	e := envelope{
+		"from":    fromRevision.Version,
+		"to":      toRevision.Version,
+		"changes": data.DiffMovieRevisions(fromRevision.Movie, toRevision.Movie),
+	}
+
+	err = a.writeJSON(w, http.StatusOK, e, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// revertMovieHandler restores the fields from an earlier revision as a new version.
+// The snapshot is validated again because the rules (or the genre vocabulary) may have
+// changed since it was saved.
+func (a *application) revertMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIdParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	version, err := a.readInt64Param(r, "version")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	if !a.checkMovieLock(w, r, id) {
+		return
+	}
+
+	movie, err := a.dao.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	expectedVersion, ok, err := a.readIfMatchVersion(r)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	if ok && expectedVersion != movie.Version {
+		a.editConfilctResponse(w, r)
+		return
+	}
+
+	revision, err := a.dao.Revisions.Get(id, int32(version))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(revision.Version != movie.Version, "version", "is already the current version"); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie.Title = revision.Movie.Title
+	movie.Year = revision.Movie.Year
+	movie.Runtime = revision.Movie.Runtime
+	movie.Genres = revision.Movie.Genres
+
+	if err = a.validateMovie(v, movie); err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.dao.Movies.Revert(movie, revision.Version, a.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConfilctResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
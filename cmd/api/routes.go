@@ -3,26 +3,132 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
+	"github.com/captainmango/greenlight/internal/data"
 	"github.com/julienschmidt/httprouter"
 )
 
+// A route is a method and path registered on the router. They're kept so that
+// TestOpenAPI can compare them with the OpenAPI document.
+type route struct {
+	method string
+	path   string
+}
+
 func (a *application) routes() http.Handler {
+	router, _ := a.router()
+
+	return a.recoverPanic(a.authenticate(a.idempotency(router)))
+}
+
+func (a *application) router() (*httprouter.Router, []route) {
 	router := httprouter.New()
 
+	var registered []route
+
+	handle := func(method, path string, handler http.HandlerFunc) {
+		router.HandlerFunc(method, path, handler)
+		registered = append(registered, route{method: method, path: path})
+	}
+
+	// handleStatic is handle for paths whose :id can also be a fixed name, like
+	// /v1/movies/export. Each name is recorded as a route of its own. Other IDs go to
+	// next, or get a 405 if it's nil.
+	handleStatic := func(method, path string, static map[string]http.HandlerFunc, next http.HandlerFunc) {
+		for name := range static {
+			registered = append(registered, route{method: method, path: strings.Replace(path, ":id", name, 1)})
+		}
+
+		if next == nil {
+			router.HandlerFunc(method, path, a.staticID(static, a.methodNotAllowedResponse))
+			return
+		}
+
+		handle(method, path, a.staticID(static, next))
+	}
+
 	router.PanicHandler = a.panicHandler
 
 	router.NotFound = http.HandlerFunc(a.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(a.methodNotAllowedResponse)
 
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", a.healthcheckHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/movies", a.getMoviesHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/movies", a.createMovieHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", a.showMovieHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", a.updateMovieHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", a.deleteMovieHandler)
+	handle(http.MethodGet, "/v1/healthcheck", a.healthcheckHandler)
+	handle(http.MethodGet, "/v1/openapi.json", a.openapiHandler)
+	handle(http.MethodGet, "/v1/docs", a.docsHandler)
+	handle(http.MethodGet, "/v1/admin/config", a.requirePermission(data.PermissionAdmin, a.showConfigHandler))
+	handle(http.MethodGet, "/v1/movies", a.getMoviesHandler)
+	handle(http.MethodPost, "/v1/movies", a.createMovieHandler)
+	handleStatic(http.MethodPost, "/v1/movies/:id", map[string]http.HandlerFunc{
+		"batch":  a.batchMoviesHandler,
+		"import": a.importMoviesHandler,
+	}, nil)
+	handleStatic(http.MethodGet, "/v1/movies/:id", map[string]http.HandlerFunc{
+		"export": a.exportMoviesHandler,
+		"events": a.movieEventsHandler,
+	}, a.showMovieHandler)
+	handle(http.MethodPatch, "/v1/movies/:id", a.updateMovieHandler)
+	handle(http.MethodPut, "/v1/movies/:id", a.replaceMovieHandler)
+	handle(http.MethodDelete, "/v1/movies/:id", a.deleteMovieHandler)
+	handle(http.MethodPost, "/v1/movies/:id/restore", a.requireAuthenticatedUser(a.restoreMovieHandler))
+	handle(http.MethodGet, "/v1/movies/:id/lock", a.showMovieLockHandler)
+	handle(http.MethodPost, "/v1/movies/:id/lock", a.requireAuthenticatedUser(a.acquireMovieLockHandler))
+	handle(http.MethodDelete, "/v1/movies/:id/lock", a.requireAuthenticatedUser(a.releaseMovieLockHandler))
+	handle(http.MethodGet, "/v1/trash/movies", a.requireAuthenticatedUser(a.getTrashedMoviesHandler))
+	handle(http.MethodGet, "/v1/movies/:id/revisions", a.getMovieRevisionsHandler)
+	handle(http.MethodGet, "/v1/movies/:id/revisions/:version", a.showMovieRevisionHandler)
+	handle(http.MethodPost, "/v1/movies/:id/revisions/:version/revert", a.revertMovieHandler)
+	handle(http.MethodGet, "/v1/movies/:id/diff", a.diffMovieRevisionsHandler)
+	handle(http.MethodGet, "/v1/movies/:id/credits", a.getMovieCreditsHandler)
+	handle(http.MethodPost, "/v1/movies/:id/credits", a.createMovieCreditHandler)
+	handle(http.MethodDelete, "/v1/movies/:id/credits/:credit_id", a.deleteMovieCreditHandler)
+	handle(http.MethodGet, "/v1/movies/:id/reviews", a.getMovieReviewsHandler)
+	handle(http.MethodPost, "/v1/movies/:id/reviews", a.requireAuthenticatedUser(a.createMovieReviewHandler))
+	handle(http.MethodPatch, "/v1/movies/:id/reviews/:review_id", a.requireAuthenticatedUser(a.updateMovieReviewHandler))
+	handle(http.MethodDelete, "/v1/movies/:id/reviews/:review_id", a.requireAuthenticatedUser(a.deleteMovieReviewHandler))
+	handle(http.MethodPost, "/v1/movies/:id/reviews/:review_id/helpful", a.requireAuthenticatedUser(a.voteMovieReviewHelpfulHandler))
+
+	handle(http.MethodGet, "/v1/genres", a.getGenresHandler)
+	handle(http.MethodPost, "/v1/genres", a.requirePermission(data.PermissionAdmin, a.createGenreHandler))
+	handle(http.MethodGet, "/v1/genres/:id", a.showGenreHandler)
+	handle(http.MethodPatch, "/v1/genres/:id", a.requirePermission(data.PermissionAdmin, a.updateGenreHandler))
+	handle(http.MethodDelete, "/v1/genres/:id", a.requirePermission(data.PermissionAdmin, a.deleteGenreHandler))
+
+	handle(http.MethodGet, "/v1/people", a.getPeopleHandler)
+	handle(http.MethodPost, "/v1/people", a.createPersonHandler)
+	handle(http.MethodGet, "/v1/people/:id", a.showPersonHandler)
+	handle(http.MethodPatch, "/v1/people/:id", a.updatePersonHandler)
+	handle(http.MethodDelete, "/v1/people/:id", a.deletePersonHandler)
+	handle(http.MethodGet, "/v1/people/:id/filmography", a.showFilmographyHandler)
+
+	handle(http.MethodGet, "/v1/lists", a.requireAuthenticatedUser(a.getListsHandler))
+	handle(http.MethodPost, "/v1/lists", a.requireAuthenticatedUser(a.createListHandler))
+	handle(http.MethodGet, "/v1/lists/:id", a.requireAuthenticatedUser(a.showListHandler))
+	handle(http.MethodPatch, "/v1/lists/:id", a.requireAuthenticatedUser(a.updateListHandler))
+	handle(http.MethodDelete, "/v1/lists/:id", a.requireAuthenticatedUser(a.deleteListHandler))
+	handle(http.MethodPost, "/v1/lists/:id/items", a.requireAuthenticatedUser(a.addListItemHandler))
+	handle(http.MethodPut, "/v1/lists/:id/items", a.requireAuthenticatedUser(a.reorderListItemsHandler))
+	handle(http.MethodDelete, "/v1/lists/:id/items/:movie_id", a.requireAuthenticatedUser(a.removeListItemHandler))
+	handle(http.MethodGet, "/v1/shared/lists/:slug", a.showSharedListHandler)
+
+	handle(http.MethodGet, "/v1/webhooks", a.requireAuthenticatedUser(a.getWebhooksHandler))
+	handle(http.MethodPost, "/v1/webhooks", a.requireAuthenticatedUser(a.createWebhookHandler))
+	handle(http.MethodGet, "/v1/webhooks/:id", a.requireAuthenticatedUser(a.showWebhookHandler))
+	handle(http.MethodPatch, "/v1/webhooks/:id", a.requireAuthenticatedUser(a.updateWebhookHandler))
+	handle(http.MethodDelete, "/v1/webhooks/:id", a.requireAuthenticatedUser(a.deleteWebhookHandler))
+	handle(http.MethodGet, "/v1/webhooks/:id/deliveries", a.requireAuthenticatedUser(a.getWebhookDeliveriesHandler))
+	handle(http.MethodPost, "/v1/webhooks/:id/deliveries/:delivery_id/redeliver", a.requireAuthenticatedUser(a.redeliverWebhookHandler))
+
+	handle(http.MethodGet, "/v1/jobs/:id", a.showJobHandler)
+
+	handle(http.MethodGet, "/v1/ws", a.requireAuthenticatedUser(a.websocketHandler))
+
+	handle(http.MethodPost, "/v1/graphql", a.graphqlHandler)
+
+	handle(http.MethodPost, "/v1/users", a.registerUserHandler)
+	handle(http.MethodPost, "/v1/tokens/authentication", a.createAuthenticationTokenHandler)
 
-	return a.recoverPanic(router)
+	return router, registered
 }
 
 func (a *application) panicHandler(w http.ResponseWriter, r *http.Request, rcv any) {
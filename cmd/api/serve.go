@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func (a *application) serve() error {
+	server := &http.Server{
+		Addr:         fmt.Sprintf(":%d", a.config().port),
+		Handler:      a.routes(),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		ErrorLog:     slog.NewLogLogger(a.logger.Handler(), slog.LevelError),
+	}
+
+	// ctx is cancelled on SIGINT or SIGTERM. Background jobs watch it so they can stop
+	// at the same time as the HTTP server.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// The gRPC server listens up front so a port clash stops startup, rather than
+	// just being logged.
+	if a.config().grpc.port > 0 {
+		lis, err := net.Listen("tcp", fmt.Sprintf(":%d", a.config().grpc.port))
+		if err != nil {
+			return err
+		}
+
+		a.background(func() {
+			a.serveGRPC(ctx, lis)
+		})
+	}
+
+	a.background(func() {
+		a.watchConfig(ctx)
+	})
+
+	a.background(func() {
+		a.purgeTrash(ctx)
+	})
+
+	a.background(func() {
+		a.purgeIdempotencyKeys(ctx)
+	})
+
+	a.background(func() {
+		a.events.run(ctx)
+	})
+
+	a.background(func() {
+		a.reapMovieLocks(ctx)
+	})
+
+	a.background(func() {
+		a.dispatchWebhooks(ctx)
+	})
+
+	a.background(func() {
+		a.purgeWebhookEvents(ctx)
+	})
+
+	if a.config().jobs.workers > 0 {
+		a.background(func() {
+			a.jobs.Run(ctx, a.config().jobs.workers, a.config().jobs.pollInterval)
+		})
+	}
+
+	shutdownError := make(chan error)
+
+	go func() {
+		<-ctx.Done()
+
+		a.logger.Info("shutting down server", "addr", server.Addr)
+
+		// Give in-flight requests up to 30 seconds to finish.
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+
+		a.logger.Info("completing background tasks", "addr", server.Addr)
+
+		a.wg.Wait()
+		shutdownError <- nil
+	}()
+
+	a.logger.Info("starting server", "addr", server.Addr, "env", a.config().env)
+
+	// Shutdown() makes ListenAndServe() return http.ErrServerClosed straight away, so
+	// that isn't an error. We then wait for the shutdown goroutine to report back.
+	err := server.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	a.logger.Info("stopped server", "addr", server.Addr)
+
+	return nil
+}
+
+// background runs fn in a goroutine that the graceful shutdown waits for. Panics are
+// logged rather than taking down the whole server.
+func (a *application) background(fn func()) {
+	a.wg.Add(1)
+
+	go func() {
+		defer a.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				a.logger.Error(fmt.Sprintf("%v", err))
+			}
+		}()
+
+		fn()
+	}()
+}
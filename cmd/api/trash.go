@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/captainmango/greenlight/internal/data"
+	"github.com/captainmango/greenlight/internal/validator"
+)
+
+// trashSortSafelist is the movie list's, plus when the movie was deleted.
+var trashSortSafelist = append(slices.Clone(movieSortSafelist), "deleted_at", "-deleted_at")
+
+// getTrashedMoviesHandler lists the trash with the same filters and paging as the movie
+// list, most recently deleted first by default.
+func (a *application) getTrashedMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+	title, genres, filters := a.readMovieFilters(qs, v)
+	filters.Sort = a.readString(qs, "sort", "-deleted_at")
+	filters.SortSafelist = trashSortSafelist
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := a.dao.Movies.GetAllDeleted(title, genres, filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIdParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.dao.Movies.Restore(id, a.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movie, err := a.dao.Movies.Get(id)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// purgeTrash hard-deletes movies that have been in the trash for longer than the
+// retention period. It runs once at startup and then on every tick until ctx is done.
+func (a *application) purgeTrash(ctx context.Context) {
+	ticker := time.NewTicker(a.config().trash.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := a.dao.Movies.PurgeDeleted(time.Now().Add(-a.config().trash.retention))
+		if err != nil {
+			a.logger.Error(err.Error(), "job", "purge_trash")
+		} else if purged > 0 {
+			a.logger.Info("purged trashed movies", "job", "purge_trash", "count", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,532 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/captainmango/greenlight/internal/data"
+	"github.com/captainmango/greenlight/internal/jobs"
+	"github.com/captainmango/greenlight/internal/validator"
+)
+
+const (
+	jobWebhookDeliver = "webhooks.deliver"
+
+	// webhookMaxAttempts with the job queue's backoff keeps retrying a failing
+	// endpoint for a few hours before the delivery is marked failed.
+	webhookMaxAttempts = 10
+
+	// webhookDispatchBatch is how many outbox events are fanned out per tick.
+	webhookDispatchBatch = 500
+
+	// maxWebhookResponseBody is how much of the receiver's response is kept in the
+	// delivery log.
+	maxWebhookResponseBody = 1024
+)
+
+// webhookClient sends deliveries. It only connects to public addresses: webhook URLs
+// are checked when they are saved, but a name can resolve somewhere else by the time a
+// delivery goes out, so the address actually dialled is checked again.
+var webhookClient = newWebhookClient(dialPublicOnly)
+
+// errWebhookAddress is returned for deliveries to an address webhooks can't reach.
+var errWebhookAddress = errors.New("webhooks can't be delivered to private or local addresses")
+
+// newWebhookClient returns a client for deliveries that runs control before every
+// connection. Redirects aren't followed, as they could send a signed payload somewhere
+// the subscriber never chose. Proxies from the environment aren't used either, as the
+// proxy's address would be the one checked rather than the receiver's.
+func newWebhookClient(control func(network, address string, c syscall.RawConn) error) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   5 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   control,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// dialPublicOnly is the dialer's Control function for webhookClient. It sees the
+// resolved address, so it catches names that point (or are re-bound) at the API's own
+// network.
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+
+	if !data.IsPublicAddress(ip) {
+		return errWebhookAddress
+	}
+
+	return nil
+}
+
+func (a *application) getWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := a.dao.Webhooks.GetAllForUser(a.contextGetUser(r).ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"webhooks": webhooks}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// createWebhookHandler subscribes a URL to movie events. A secret is generated if one
+// isn't given, and this is the only response it's returned in.
+func (a *application) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL    string   `json:"url"`
+		Secret string   `json:"secret"`
+		Events []string `json:"events"`
+		Active *bool    `json:"active"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	webhook := &data.Webhook{
+		UserID: a.contextGetUser(r).ID,
+		URL:    input.URL,
+		Secret: input.Secret,
+		Events: input.Events,
+		Active: input.Active == nil || *input.Active,
+	}
+
+	if webhook.Secret == "" {
+		webhook.Secret = data.GenerateWebhookSecret()
+	}
+
+	v := validator.New()
+
+	if data.ValidateWebhook(v, webhook); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.dao.Webhooks.Insert(webhook)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/webhooks/%d", webhook.ID))
+
+	err = a.writeJSON(w, http.StatusCreated, envelope{"webhook": webhook, "secret": webhook.Secret}, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// readOwnWebhook loads the webhook from the URL for its owner. Like lists, other users
+// get a 404. It writes the error response itself, so callers should just return when
+// ok is false.
+func (a *application) readOwnWebhook(w http.ResponseWriter, r *http.Request) (*data.Webhook, bool) {
+	id, err := a.readIdParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	webhook, err := a.dao.Webhooks.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if webhook.UserID != a.contextGetUser(r).ID {
+		a.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	return webhook, true
+}
+
+func (a *application) showWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := a.readOwnWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.writeJSON(w, http.StatusOK, envelope{"webhook": webhook}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateWebhookHandler changes a webhook. Sending a new secret rotates it, and the new
+// secret is echoed back once.
+func (a *application) updateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL    *string  `json:"url"`
+		Secret *string  `json:"secret"`
+		Events []string `json:"events"`
+		Active *bool    `json:"active"`
+	}
+
+	webhook, ok := a.readOwnWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.URL != nil {
+		webhook.URL = *input.URL
+	}
+
+	if input.Secret != nil {
+		webhook.Secret = *input.Secret
+	}
+
+	if input.Events != nil {
+		webhook.Events = input.Events
+	}
+
+	if input.Active != nil {
+		webhook.Active = *input.Active
+	}
+
+	v := validator.New()
+
+	if data.ValidateWebhook(v, webhook); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.dao.Webhooks.Update(webhook)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConfilctResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"webhook": webhook}
+	if input.Secret != nil {
+		env["secret"] = webhook.Secret
+	}
+
+	err = a.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *application) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := a.readOwnWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.dao.Webhooks.Delete(webhook.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"message": "successfully deleted webhook"}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// getWebhookDeliveriesHandler is the delivery log for a webhook, optionally filtered by
+// ?status=.
+func (a *application) getWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := a.readOwnWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	status := a.readString(qs, "status", "")
+	v.Check(validator.PermittedValue(status, "", data.DeliveryPending, data.DeliveryRetrying, data.DeliverySucceeded, data.DeliveryFailed),
+		"status", "must be pending, retrying, succeeded or failed")
+
+	filters := data.Filters{
+		Page:         a.readInt(qs, "page", 1, v),
+		PageSize:     a.readInt(qs, "page_size", 20, v),
+		Sort:         a.readString(qs, "sort", "-id"),
+		SortSafelist: []string{"id", "-id"},
+	}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	deliveries, metadata, err := a.dao.Webhooks.GetDeliveries(webhook.ID, status, filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"deliveries": deliveries, "metadata": metadata}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// redeliverWebhookHandler queues the event from an earlier delivery to be sent again.
+// The original delivery is left as it was, and the new one links back to it.
+func (a *application) redeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := a.readOwnWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := a.readInt64Param(r, "delivery_id")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	delivery, err := a.dao.Webhooks.Redeliver(webhook.ID, deliveryID, a.enqueueWebhookDelivery)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusAccepted, envelope{"delivery": delivery}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+type webhookDeliveryPayload struct {
+	DeliveryID int64 `json:"delivery_id"`
+}
+
+// enqueueWebhookDelivery queues the job that sends a delivery, inside the transaction
+// that created it.
+func (a *application) enqueueWebhookDelivery(ctx context.Context, tx *sql.Tx, deliveryID int64) error {
+	_, err := a.jobs.EnqueueTx(ctx, tx, jobWebhookDeliver, webhookDeliveryPayload{DeliveryID: deliveryID}, jobs.EnqueueOptions{
+		MaxAttempts: webhookMaxAttempts,
+	})
+	return err
+}
+
+// dispatchWebhooks drains the movie_events outbox into webhook deliveries on every
+// tick until ctx is done.
+func (a *application) dispatchWebhooks(ctx context.Context) {
+	ticker := time.NewTicker(a.config().webhooks.dispatchInterval)
+	defer ticker.Stop()
+
+	for {
+		dispatched, err := a.dao.Webhooks.Dispatch(webhookDispatchBatch, a.enqueueWebhookDelivery)
+		if err != nil {
+			a.logger.Error(err.Error(), "job", "dispatch_webhooks")
+		} else if dispatched > 0 {
+			a.logger.Info("queued webhook deliveries", "job", "dispatch_webhooks", "count", dispatched)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeWebhookEvents deletes outbox events, and their deliveries, once they are older
+// than the retention period. It runs once at startup and then on every tick.
+func (a *application) purgeWebhookEvents(ctx context.Context) {
+	ticker := time.NewTicker(a.config().webhooks.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := a.dao.Webhooks.PurgeEvents(time.Now().Add(-a.config().webhooks.retention))
+		if err != nil {
+			a.logger.Error(err.Error(), "job", "purge_webhook_events")
+		} else if purged > 0 {
+			a.logger.Info("purged webhook events", "job", "purge_webhook_events", "count", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliverWebhook is the webhooks.deliver job. It makes one attempt at a delivery and
+// records the outcome in the delivery log. Anything other than a 2xx response is
+// returned as an error, so the job queue retries it with backoff.
+func (a *application) deliverWebhook(ctx context.Context, job *jobs.Job, payload webhookDeliveryPayload) (any, error) {
+	delivery, err := a.dao.Webhooks.GetDelivery(payload.DeliveryID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			// The webhook was deleted, taking its deliveries with it.
+			return nil, jobs.Permanent(err)
+		}
+
+		return nil, err
+	}
+
+	webhook, err := a.dao.Webhooks.Get(delivery.WebhookID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, jobs.Permanent(err)
+		}
+
+		return nil, err
+	}
+
+	if !webhook.Active {
+		delivery.Status = data.DeliveryFailed
+		delivery.Error = "webhook is disabled"
+
+		if err := a.dao.Webhooks.RecordAttempt(delivery); err != nil {
+			return nil, err
+		}
+
+		return nil, jobs.Permanent(errors.New(delivery.Error))
+	}
+
+	err = sendWebhook(ctx, webhookClient, webhook, delivery, job.Attempts, job.MaxAttempts)
+	if err != nil {
+		return nil, jobs.Permanent(err)
+	}
+
+	if err := a.dao.Webhooks.RecordAttempt(delivery); err != nil {
+		return nil, err
+	}
+
+	if delivery.Status != data.DeliverySucceeded {
+		return nil, errors.New(delivery.Error)
+	}
+
+	return envelope{"delivery_id": delivery.ID, "response_status": delivery.ResponseStatus}, nil
+}
+
+// sendWebhook makes one attempt at a delivery with client and records the outcome on
+// delivery, ready for the delivery log. Whether a failed attempt is retried depends on
+// how many attempts are left. The error is only for deliveries that can't be sent at
+// all, such as a webhook with a broken URL.
+func sendWebhook(ctx context.Context, client *http.Client, webhook *data.Webhook, delivery *data.WebhookDelivery, attempt, maxAttempts int) error {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Greenlight-Webhooks/"+version)
+	req.Header.Set("Greenlight-Event", delivery.Event.Event)
+	req.Header.Set("Greenlight-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("Greenlight-Timestamp", timestamp)
+	req.Header.Set("Greenlight-Signature", signWebhook(webhook.Secret, timestamp, body))
+
+	delivery.ResponseStatus = nil
+	delivery.ResponseBody = ""
+	delivery.Error = ""
+
+	start := time.Now()
+	res, err := client.Do(req)
+
+	if err != nil {
+		delivery.Error = err.Error()
+	} else {
+		responseBody, _ := io.ReadAll(io.LimitReader(res.Body, maxWebhookResponseBody))
+		res.Body.Close()
+
+		delivery.ResponseStatus = &res.StatusCode
+		delivery.ResponseBody = string(bytes.ToValidUTF8(responseBody, nil))
+
+		if res.StatusCode < 200 || res.StatusCode > 299 {
+			delivery.Error = fmt.Sprintf("receiver responded with status %d", res.StatusCode)
+		}
+	}
+
+	delivery.DurationMs = int(time.Since(start).Milliseconds())
+
+	switch {
+	case delivery.Error == "":
+		delivery.Status = data.DeliverySucceeded
+	case attempt >= maxAttempts:
+		delivery.Status = data.DeliveryFailed
+	default:
+		delivery.Status = data.DeliveryRetrying
+	}
+
+	return nil
+}
+
+// signWebhook returns the Greenlight-Signature header for a delivery: an HMAC-SHA256
+// of "<timestamp>.<body>" keyed with the webhook's secret. Signing the timestamp lets
+// receivers reject old requests that are replayed at them.
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
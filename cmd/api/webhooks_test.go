@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/captainmango/greenlight/internal/data"
+	"github.com/captainmango/greenlight/internal/validator"
+)
+
+// receivedWebhook is what the test receiver saw of one delivery.
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+// newWebhookReceiver starts a receiver that answers each delivery with the next of
+// statuses, and sends what it receives down the returned channel.
+func newWebhookReceiver(t *testing.T, statuses ...int) (*httptest.Server, <-chan receivedWebhook) {
+	t.Helper()
+
+	received := make(chan receivedWebhook, len(statuses))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- receivedWebhook{header: r.Header.Clone(), body: body}
+
+		status := statuses[0]
+		statuses = statuses[1:]
+
+		w.WriteHeader(status)
+		io.WriteString(w, strings.Repeat("x", 2*maxWebhookResponseBody))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, received
+}
+
+func testDelivery(t *testing.T, url string) (*data.Webhook, *data.WebhookDelivery) {
+	t.Helper()
+
+	webhook := &data.Webhook{ID: 1, URL: url, Secret: "a-very-secret-secret", Active: true}
+
+	delivery := &data.WebhookDelivery{
+		ID:        7,
+		WebhookID: webhook.ID,
+		Event: data.MovieEvent{
+			ID:      3,
+			Event:   data.EventMovieCreated,
+			MovieID: 12,
+			Movie:   json.RawMessage(`{"id":12,"title":"Alien"}`),
+		},
+	}
+
+	return webhook, delivery
+}
+
+func TestSendWebhookSignsDeliveries(t *testing.T) {
+	srv, received := newWebhookReceiver(t, http.StatusNoContent)
+	webhook, delivery := testDelivery(t, srv.URL)
+
+	err := sendWebhook(context.Background(), newWebhookClient(nil), webhook, delivery, 1, webhookMaxAttempts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-received
+
+	if got.header.Get("Greenlight-Event") != data.EventMovieCreated {
+		t.Errorf("Greenlight-Event is %q", got.header.Get("Greenlight-Event"))
+	}
+	if got.header.Get("Greenlight-Delivery") != "7" {
+		t.Errorf("Greenlight-Delivery is %q", got.header.Get("Greenlight-Delivery"))
+	}
+
+	// Check the signature the way the README tells receivers to.
+	mac := hmac.New(sha256.New, []byte(webhook.Secret))
+	mac.Write([]byte(got.header.Get("Greenlight-Timestamp") + "."))
+	mac.Write(got.body)
+	want := "v1=" + hex.EncodeToString(mac.Sum(nil))
+
+	if got.header.Get("Greenlight-Signature") != want {
+		t.Errorf("Greenlight-Signature is %q, want %q", got.header.Get("Greenlight-Signature"), want)
+	}
+
+	var event data.MovieEvent
+	if err := json.Unmarshal(got.body, &event); err != nil || event.MovieID != 12 {
+		t.Errorf("body is %s", got.body)
+	}
+
+	if delivery.Status != data.DeliverySucceeded || *delivery.ResponseStatus != http.StatusNoContent || delivery.Error != "" {
+		t.Errorf("delivery log has status %q, response %d, error %q", delivery.Status, *delivery.ResponseStatus, delivery.Error)
+	}
+}
+
+func TestSendWebhookRetries(t *testing.T) {
+	srv, _ := newWebhookReceiver(t, http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK)
+	webhook, delivery := testDelivery(t, srv.URL)
+	client := newWebhookClient(nil)
+
+	tests := []struct {
+		attempt, maxAttempts int
+		status               string
+		responseStatus       int
+		error                string
+	}{
+		{1, 3, data.DeliveryRetrying, http.StatusInternalServerError, "receiver responded with status 500"},
+		{2, 2, data.DeliveryFailed, http.StatusBadGateway, "receiver responded with status 502"},
+		// A redelivery starts again after the original gave up.
+		{1, 3, data.DeliverySucceeded, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		err := sendWebhook(context.Background(), client, webhook, delivery, tt.attempt, tt.maxAttempts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if delivery.Status != tt.status || delivery.Error != tt.error {
+			t.Errorf("attempt %d of %d: got status %q, error %q; want %q, %q", tt.attempt, tt.maxAttempts, delivery.Status, delivery.Error, tt.status, tt.error)
+		}
+
+		if delivery.ResponseStatus == nil || *delivery.ResponseStatus != tt.responseStatus {
+			t.Errorf("attempt %d of %d: got response status %v, want %d", tt.attempt, tt.maxAttempts, delivery.ResponseStatus, tt.responseStatus)
+		}
+
+		// Only the start of the receiver's response is kept in the log.
+		if len(delivery.ResponseBody) != maxWebhookResponseBody {
+			t.Errorf("attempt %d of %d: logged %d bytes of the response", tt.attempt, tt.maxAttempts, len(delivery.ResponseBody))
+		}
+	}
+}
+
+func TestSendWebhookRefusesLocalAddresses(t *testing.T) {
+	srv, received := newWebhookReceiver(t, http.StatusOK)
+	webhook, delivery := testDelivery(t, srv.URL)
+
+	// httptest listens on loopback, which is exactly what webhookClient won't dial.
+	err := sendWebhook(context.Background(), webhookClient, webhook, delivery, 1, webhookMaxAttempts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if delivery.Status != data.DeliveryRetrying || delivery.ResponseStatus != nil || !strings.Contains(delivery.Error, errWebhookAddress.Error()) {
+		t.Errorf("delivery log has status %q, response %v, error %q", delivery.Status, delivery.ResponseStatus, delivery.Error)
+	}
+
+	select {
+	case <-received:
+		t.Error("the receiver got the delivery")
+	default:
+	}
+}
+
+func TestDialPublicOnly(t *testing.T) {
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"93.184.215.14:443", true},
+		{"[2606:2800:21f:cb07:6820:80da:af6b:8b2c]:443", true},
+		{"127.0.0.1:80", false},
+		{"[::1]:80", false},
+		{"10.1.2.3:80", false},
+		{"172.16.0.1:80", false},
+		{"192.168.1.1:80", false},
+		{"169.254.169.254:80", false},
+		{"[fe80::1%eth0]:80", false},
+		{"[fc00::1]:80", false},
+		{"0.0.0.0:80", false},
+		{"[::]:80", false},
+		{"100.64.0.1:80", false},
+		{"[::ffff:127.0.0.1]:80", false},
+		{"224.0.0.1:80", false},
+	}
+
+	for _, tt := range tests {
+		err := dialPublicOnly("tcp", tt.address, nil)
+
+		switch {
+		case tt.allowed && err != nil:
+			t.Errorf("%s: got %v, want it allowed", tt.address, err)
+		case !tt.allowed && !errors.Is(err, errWebhookAddress):
+			t.Errorf("%s: got %v, want %v", tt.address, err, errWebhookAddress)
+		}
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{"https://hooks.example.com/greenlight", true},
+		{"http://93.184.215.14/hook", true},
+		{"http://localhost:4000/hook", false},
+		{"http://api.localhost/hook", false},
+		{"http://LOCALHOST./hook", false},
+		{"http://127.0.0.1/hook", false},
+		{"http://[::1]/hook", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"http://10.0.0.5/hook", false},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		data.ValidateWebhook(v, &data.Webhook{URL: tt.url, Secret: "a-very-secret-secret", Events: []string{data.EventMovieCreated}})
+
+		if v.Valid() != tt.valid {
+			t.Errorf("%s: got errors %v, want valid %t", tt.url, v.Errors, tt.valid)
+		}
+	}
+
+	// IPv4-mapped IPv6 addresses are judged by the IPv4 address inside.
+	if data.IsPublicAddress(netip.MustParseAddr("::ffff:10.0.0.1")) {
+		t.Error("IPv4-mapped private addresses must not be public")
+	}
+}
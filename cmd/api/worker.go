@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"os/signal"
+	"syscall"
+)
+
+// runWorker is the `api worker` subcommand. It runs background jobs without serving
+// HTTP, so imports and the like can be scaled separately from the API. On SIGINT or
+// SIGTERM it stops claiming jobs and waits for the running ones to finish. SIGHUP
+// reloads the config, as it does for the API.
+func (a *application) runWorker() error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	workers := max(a.config().jobs.workers, 1)
+
+	a.logger.Info("starting worker", "workers", workers, "env", a.config().env)
+
+	a.background(func() {
+		a.watchConfig(ctx)
+	})
+
+	a.jobs.Run(ctx, workers, a.config().jobs.pollInterval)
+	a.wg.Wait()
+
+	a.logger.Info("stopped worker")
+
+	return nil
+}
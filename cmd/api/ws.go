@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/captainmango/greenlight/internal/data"
+	"github.com/captainmango/greenlight/internal/validator"
+	"github.com/captainmango/greenlight/internal/websocket"
+)
+
+const (
+	// wsPingInterval is how often the server pings. The client has wsReadTimeout to
+	// send something back (a pong will do) before it's treated as gone.
+	wsPingInterval = 30 * time.Second
+	wsReadTimeout  = 2 * wsPingInterval
+
+	// wsWriteTimeout is how long a write can block before the client is treated as
+	// too slow and disconnected.
+	wsWriteTimeout = 10 * time.Second
+
+	wsMaxMessageSize   = 64 * 1024
+	wsMaxSubscriptions = 100
+	wsMaxMovieIDs      = 1000
+)
+
+// wsRequest is a message from the client. Subscriptions are named by the client, and
+// the name is sent back with every event they match.
+type wsRequest struct {
+	Type     string   `json:"type"`
+	ID       string   `json:"id"`
+	MovieIDs []int64  `json:"movie_ids"`
+	Query    *wsQuery `json:"query"`
+	// After replays events from the log that came after this event ID, so a client
+	// can pick up where a dropped connection left off.
+	After int64 `json:"after"`
+}
+
+// wsQuery matches movies like the list filters: every genre must be present, and
+// every word of the title must appear in the movie's title.
+type wsQuery struct {
+	Title  string   `json:"title"`
+	Genres []string `json:"genres"`
+}
+
+type wsSubscription struct {
+	movieIDs   map[int64]bool
+	titleWords []string
+	genres     []string
+}
+
+func (s *wsSubscription) matches(event *data.MovieEvent, movie eventMovie) bool {
+	if s.movieIDs != nil {
+		return s.movieIDs[event.MovieID]
+	}
+
+	title := strings.ToLower(movie.Title)
+
+	for _, word := range s.titleWords {
+		if !strings.Contains(title, word) {
+			return false
+		}
+	}
+
+	for _, genre := range s.genres {
+		if !slices.Contains(movie.Genres, genre) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// websocketOriginAllowed stops other websites from opening connections with a visitor's
+// browser. Browsers always send Origin with a handshake, and it has to be the API's own
+// origin or one of websockets.allowed_origins. Other clients don't send it, and are let
+// through.
+func (a *application) websocketOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	return slices.ContainsFunc(strings.Fields(a.config().websockets.allowedOrigins), func(allowed string) bool {
+		return strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin)
+	})
+}
+
+// websocketHandler is a two-way alternative to the SSE feed. Clients send subscribe
+// and unsubscribe messages and get an event message for every change that matches one
+// of their subscriptions. Clients that can't keep up are disconnected rather than
+// letting events pile up in memory.
+func (a *application) websocketHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.websocketOriginAllowed(r) {
+		a.originNotAllowedResponse(w, r)
+		return
+	}
+
+	select {
+	case a.websockets <- struct{}{}:
+		defer func() { <-a.websockets }()
+	default:
+		a.tooManyConnectionsResponse(w, r)
+		return
+	}
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, websocket.ErrBadHandshake):
+			a.badRequestResponse(w, r, err)
+		default:
+			a.logError(r, err)
+		}
+		return
+	}
+	defer conn.Close()
+
+	conn.MaxMessageSize = wsMaxMessageSize
+	conn.ReadTimeout = wsReadTimeout
+	conn.WriteTimeout = wsWriteTimeout
+
+	events, unsubscribe := a.events.subscribe()
+	defer unsubscribe()
+
+	// Reads happen on their own goroutine so the loop below can wait on the client,
+	// the event hub and the ping ticker at the same time.
+	requests := make(chan []byte)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+
+			if messageType != websocket.TextMessage {
+				conn.WriteClose(websocket.CloseUnsupportedData, "only text messages are supported")
+				readErr <- websocket.ErrClosed
+				return
+			}
+
+			select {
+			case requests <- message:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	subscriptions := make(map[string]*wsSubscription)
+	replayed := make(map[int64]bool)
+
+	send := func(message envelope) error {
+		js, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+
+		return conn.WriteMessage(websocket.TextMessage, js)
+	}
+
+	sendEvent := func(event *data.MovieEvent, only string) error {
+		movie, err := decodeEventMovie(event)
+		if err != nil {
+			return err
+		}
+
+		for id, subscription := range subscriptions {
+			if (only == "" || id == only) && subscription.matches(event, movie) {
+				if err := send(envelope{"type": "event", "subscription": id, "event": event}); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	// Lock changes have no movie snapshot to match a query against, so they only go to
+	// subscriptions that name the movie.
+	sendLock := func(lock *data.MovieLock) error {
+		for id, subscription := range subscriptions {
+			if subscription.movieIDs[lock.MovieID] {
+				if err := send(envelope{"type": "lock", "subscription": id, "lock": lock}); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case err := <-readErr:
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) && !errors.Is(err, websocket.ErrClosed) {
+				a.logger.Info("websocket read failed", "error", err.Error())
+			}
+			return
+		case message := <-requests:
+			var req wsRequest
+
+			if err := json.Unmarshal(message, &req); err != nil {
+				err = send(envelope{"type": "error", "error": "the message must be a JSON object"})
+			} else {
+				err = a.handleWebsocketRequest(&req, subscriptions, func(events []data.MovieEvent) error {
+					for i := range events {
+						replayed[events[i].ID] = true
+
+						if err := sendEvent(&events[i], req.ID); err != nil {
+							return err
+						}
+					}
+					return nil
+				}, send)
+			}
+
+			if err != nil {
+				conn.WriteClose(websocket.CloseInternalError, "")
+				return
+			}
+		case message, ok := <-events:
+			if !ok {
+				conn.WriteClose(websocket.CloseTryAgainLater, "reconnect and resubscribe with after")
+				return
+			}
+
+			if message.lock != nil {
+				if err := sendLock(message.lock); err != nil {
+					return
+				}
+				continue
+			}
+
+			if replayed[message.event.ID] {
+				continue
+			}
+
+			if err := sendEvent(message.event, ""); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleWebsocketRequest applies a subscribe or unsubscribe message and sends the
+// reply. replay is called with any missed events a subscription asked for, after the
+// subscription has been confirmed.
+func (a *application) handleWebsocketRequest(req *wsRequest, subscriptions map[string]*wsSubscription, replay func([]data.MovieEvent) error, send func(envelope) error) error {
+	v := validator.New()
+
+	v.Check(req.ID != "", "id", "must be provided")
+	v.Check(len(req.ID) <= 100, "id", "must not be more than 100 bytes long")
+
+	switch req.Type {
+	case "subscribe":
+		subscription, events := a.readWebsocketSubscription(v, req, subscriptions)
+
+		if !v.Valid() {
+			return send(envelope{"type": "error", "id": req.ID, "error": v.Errors})
+		}
+
+		subscriptions[req.ID] = subscription
+
+		if err := send(envelope{"type": "subscribed", "id": req.ID}); err != nil {
+			return err
+		}
+
+		return replay(events)
+	case "unsubscribe":
+		v.Check(subscriptions[req.ID] != nil, "id", "must be an active subscription")
+
+		if !v.Valid() {
+			return send(envelope{"type": "error", "id": req.ID, "error": v.Errors})
+		}
+
+		delete(subscriptions, req.ID)
+
+		return send(envelope{"type": "unsubscribed", "id": req.ID})
+	default:
+		v.AddError("type", "must be subscribe or unsubscribe")
+		return send(envelope{"type": "error", "id": req.ID, "error": v.Errors})
+	}
+}
+
+// readWebsocketSubscription validates a subscribe message and loads any events it
+// wants replayed. Problems are added to v.
+func (a *application) readWebsocketSubscription(v *validator.Validator, req *wsRequest, subscriptions map[string]*wsSubscription) (*wsSubscription, []data.MovieEvent) {
+	v.Check(subscriptions[req.ID] == nil, "id", "is already in use")
+	v.Check(len(subscriptions) < wsMaxSubscriptions, "id", "too many subscriptions on this connection")
+	v.Check((req.MovieIDs == nil) != (req.Query == nil), "movie_ids", "exactly one of movie_ids or query must be provided")
+	v.Check(req.After >= 0, "after", "must be a valid event ID")
+
+	subscription := &wsSubscription{}
+
+	if req.MovieIDs != nil {
+		v.Check(len(req.MovieIDs) > 0, "movie_ids", "must contain at least 1 movie")
+		v.Check(len(req.MovieIDs) <= wsMaxMovieIDs, "movie_ids", "must not contain more than 1000 movies")
+
+		subscription.movieIDs = make(map[int64]bool, len(req.MovieIDs))
+		for _, id := range req.MovieIDs {
+			subscription.movieIDs[id] = true
+		}
+	}
+
+	if req.Query != nil {
+		subscription.titleWords = strings.Fields(strings.ToLower(req.Query.Title))
+		subscription.genres = req.Query.Genres
+
+		if len(req.Query.Genres) > 0 {
+			permitted, err := a.dao.Genres.Slugs()
+			if err != nil {
+				v.AddError("query", "could not be checked, try again")
+				return nil, nil
+			}
+
+			data.ValidateMovieGenres(v, req.Query.Genres, permitted)
+		}
+	}
+
+	if !v.Valid() || req.After == 0 {
+		return subscription, nil
+	}
+
+	events, complete, err := a.dao.Events.GetSince(req.After, maxEventReplay)
+	if err != nil {
+		v.AddError("after", "could not be replayed, try again")
+		return nil, nil
+	}
+
+	v.Check(complete, "after", "too many events were missed, reload and subscribe without after")
+
+	return subscription, events
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebsocketOriginAllowed(t *testing.T) {
+	var cfg config
+	cfg.websockets.allowedOrigins = "https://app.example.com http://localhost:3000/"
+
+	a := &application{}
+	a.current.Store(&cfg)
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"", true},
+		{"https://api.example.com", true},
+		{"http://API.example.com", true},
+		{"https://app.example.com", true},
+		{"HTTPS://APP.EXAMPLE.COM", true},
+		{"http://localhost:3000", true},
+		{"http://app.example.com", false},
+		{"https://app.example.com:8443", false},
+		{"https://evil.example.net", false},
+		{"https://api.example.com.evil.example.net", false},
+		{"null", false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "http://api.example.com/v1/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+
+		if got := a.websocketOriginAllowed(r); got != tt.allowed {
+			t.Errorf("Origin %q: got %t, want %t", tt.origin, got, tt.allowed)
+		}
+	}
+}
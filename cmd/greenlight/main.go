@@ -0,0 +1,232 @@
+// Command greenlight administers a Greenlight deployment. Movie commands go through the
+// API with the Go client; user, token and migration commands need the database.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+
+	"github.com/captainmango/greenlight/internal/data"
+	"github.com/captainmango/greenlight/pkg/client"
+	_ "github.com/lib/pq"
+)
+
+const usage = `Usage: greenlight [-profile name] [-config file] [-o table|json|yaml] <command> [args]
+
+Commands:
+  movies list [-title t] [-genres a,b] [-page n] [-page-size n] [-sort field]
+  movies get <id>
+  movies create -title t -year y -runtime mins [-genres a,b]
+  movies update <id> [-title t] [-year y] [-runtime mins] [-genres a,b] [-version v]
+  movies delete <id>
+  import [-format csv|ndjson] [-dry-run] [-idempotency-key k] <file|->
+  export [-format csv|ndjson] [-title t] [-genres a,b] [-sort field] [-file path]
+  users create -name n -email e [-password p]
+  users grant-permission <email> <permission>
+  tokens issue [-ttl 24h] <email>
+  migrate up [n] | down [n] | down -all | version | force <version>
+
+Settings come from the profile file (~/.config/greenlight/config.yaml by default),
+overridden by GREENLIGHT_URL, GREENLIGHT_TOKEN, GREENLIGHT_DSN and GREENLIGHT_OUTPUT.
+`
+
+// errUsage is returned for bad command lines. The message has already been printed
+// with the usage.
+var errUsage = errors.New("invalid usage")
+
+type cli struct {
+	profile profile
+	output  string
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	c := &cli{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr}
+
+	err := c.run(ctx, os.Args[1:])
+
+	// API errors already start with "greenlight:".
+	var apiErr *client.Error
+
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	case errors.As(err, &apiErr):
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "greenlight:", err)
+		os.Exit(1)
+	}
+}
+
+func (c *cli) run(ctx context.Context, args []string) error {
+	var configPath, profileName string
+
+	global := c.flags("greenlight")
+	global.StringVar(&configPath, "config", "", "Profile file (default ~/.config/greenlight/config.yaml)")
+	global.StringVar(&profileName, "profile", "", "Profile to use (default $GREENLIGHT_PROFILE or the file's default_profile)")
+
+	if err := global.Parse(args); err != nil {
+		return err
+	}
+
+	explicitPath := configPath != ""
+	if !explicitPath {
+		configPath = defaultConfigPath()
+	}
+
+	var err error
+
+	c.profile, err = loadProfile(configPath, explicitPath, profileName)
+	if err != nil {
+		return err
+	}
+
+	if c.output == "" {
+		if err := c.setOutput(c.profile.Output); err != nil {
+			return fmt.Errorf("output %q: %w", c.profile.Output, err)
+		}
+	}
+
+	args = global.Args()
+	if len(args) == 0 {
+		return c.usageError("no command given")
+	}
+
+	commands := map[string]func(context.Context, []string) error{
+		"movies":  c.movies,
+		"import":  c.importMovies,
+		"export":  c.exportMovies,
+		"users":   c.users,
+		"tokens":  c.tokens,
+		"migrate": c.migrate,
+	}
+
+	command, ok := commands[args[0]]
+	if !ok {
+		return c.usageError("unknown command %q", args[0])
+	}
+
+	return command(ctx, args[1:])
+}
+
+// flags returns a flag set for a command. Every command accepts -o, so it can come
+// after the command as well as before it.
+func (c *cli) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		fmt.Fprint(c.stderr, usage)
+	}
+
+	fs.Func("o", "Output format: table, json or yaml", c.setOutput)
+	fs.Func("output", "Output format: table, json or yaml", c.setOutput)
+
+	return fs
+}
+
+func (c *cli) setOutput(format string) error {
+	switch format {
+	case "table", "json", "yaml":
+		c.output = format
+		return nil
+	default:
+		return fmt.Errorf("must be table, json or yaml")
+	}
+}
+
+func (c *cli) usageError(format string, args ...any) error {
+	fmt.Fprintf(c.stderr, "greenlight: "+format+"\n\n", args...)
+	fmt.Fprint(c.stderr, usage)
+
+	return errUsage
+}
+
+// subcommand dispatches the second word of commands like `movies list`.
+func (c *cli) subcommand(ctx context.Context, group string, args []string, commands map[string]func(context.Context, []string) error) error {
+	if len(args) == 0 {
+		return c.usageError("%s needs a subcommand", group)
+	}
+
+	command, ok := commands[args[0]]
+	if !ok {
+		return c.usageError("unknown command %q", group+" "+args[0])
+	}
+
+	return command(ctx, args[1:])
+}
+
+func (c *cli) api() (*client.Client, error) {
+	return client.New(c.profile.URL, client.WithToken(c.profile.Token), client.WithUserAgent("greenlight-cli"))
+}
+
+// dao connects to the database. The caller must close the returned DB.
+func (c *cli) dao(ctx context.Context) (data.DataAccessObjects, *sql.DB, error) {
+	if c.profile.DSN == "" {
+		return data.DataAccessObjects{}, nil, errors.New("this command needs the database: set dsn in the profile, or GREENLIGHT_DSN")
+	}
+
+	db, err := sql.Open("postgres", c.profile.DSN)
+	if err != nil {
+		return data.DataAccessObjects{}, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return data.DataAccessObjects{}, nil, err
+	}
+
+	return data.NewDataAccessObjects(db), db, nil
+}
+
+// parseID reads the single ID argument commands like `movies get` take.
+func (c *cli) parseID(command string, args []string) (int64, error) {
+	if len(args) != 1 {
+		return 0, c.usageError("%s needs exactly one ID", command)
+	}
+
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || id < 1 {
+		return 0, c.usageError("%q is not a valid ID", args[0])
+	}
+
+	return id, nil
+}
+
+// parse parses args with fs, allowing flags after positional arguments as well as
+// before them, as in `movies update 3 -title Alien`.
+func parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
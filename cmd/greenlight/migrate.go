@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/captainmango/greenlight/migrations"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// migrate applies the embedded migrations with golang-migrate, the same library as
+// the tool behind `task migrate-db`, so the two share the schema_migrations table and
+// behave the same way: `down` with no count rolls back every migration, after asking
+// (or straight away with -all).
+func (c *cli) migrate(ctx context.Context, args []string) error {
+	fs := c.flags("migrate")
+	all := fs.Bool("all", false, "With down, roll back every migration without asking")
+
+	rest, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		return c.usageError("migrate needs up, down, version or force")
+	}
+
+	command, rest := rest[0], rest[1:]
+
+	var n int
+	switch {
+	case command == "force" && len(rest) == 1:
+		n, err = strconv.Atoi(rest[0])
+		if err != nil || n < -1 {
+			return c.usageError("%q is not a valid version", rest[0])
+		}
+	case (command == "up" || command == "down") && len(rest) == 1 && !*all:
+		n, err = strconv.Atoi(rest[0])
+		if err != nil || n < 1 {
+			return c.usageError("%q is not a valid number of migrations", rest[0])
+		}
+	case command == "down" && len(rest) == 0:
+		if !*all && !c.confirm("Are you sure you want to apply all down migrations?") {
+			return c.message("Not applying down migrations")
+		}
+	case (command == "up" || command == "version") && len(rest) == 0 && !*all:
+	default:
+		return c.usageError("migrate needs up [n], down [n] | down -all, version or force <version>")
+	}
+
+	m, err := c.migrator(ctx)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	// golang-migrate doesn't take a context, but it stops after the migration it is
+	// running when asked.
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			m.GracefulStop <- true
+		case <-done:
+		}
+	}()
+
+	switch {
+	case command == "version":
+		return c.printMigrationVersion(m)
+	case command == "force":
+		err = m.Force(n)
+		if err != nil {
+			return err
+		}
+		return c.printMigrationVersion(m)
+	case command == "up" && n > 0:
+		err = m.Steps(n)
+	case command == "up":
+		err = m.Up()
+	case n > 0:
+		err = m.Steps(-n)
+	default:
+		err = m.Down()
+	}
+
+	var dirty migrate.ErrDirty
+
+	switch {
+	case errors.Is(err, migrate.ErrNoChange):
+		return c.message("No migrations to run")
+	case errors.As(err, &dirty):
+		return fmt.Errorf("the database is dirty at version %d: fix it by hand, then run `greenlight migrate force <version>`", dirty.Version)
+	case err != nil:
+		return err
+	}
+
+	version, _, err := m.Version()
+	switch {
+	case errors.Is(err, migrate.ErrNilVersion):
+		return c.message("Ran migrations down; no migrations are applied")
+	case err != nil:
+		return err
+	}
+
+	return c.message("Ran migrations %s; the database is at version %d", command, version)
+}
+
+// migrator connects golang-migrate to the database with the migrations compiled into
+// the binary as its source. Closing it closes the database too.
+func (c *cli) migrator(ctx context.Context) (*migrate.Migrate, error) {
+	_, db, err := c.dao(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	driver, err := postgres.WithInstance(db, &postgres.Config{})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	source, err := iofs.New(migrations.FS, ".")
+	if err != nil {
+		driver.Close()
+		return nil, err
+	}
+
+	m, err := migrate.NewWithInstance("iofs", source, "postgres", driver)
+	if err != nil {
+		source.Close()
+		driver.Close()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// confirm asks a yes or no question on stdin, defaulting to no.
+func (c *cli) confirm(question string) bool {
+	fmt.Fprintf(c.stderr, "%s [y/N] ", question)
+
+	answer, _ := bufio.NewReader(c.stdin).ReadString('\n')
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *cli) printMigrationVersion(m *migrate.Migrate) error {
+	version, dirty, err := m.Version()
+	switch {
+	case errors.Is(err, migrate.ErrNilVersion):
+		return c.message("No migrations have been run")
+	case err != nil:
+		return err
+	}
+
+	return c.print(map[string]any{"version": version, "dirty": dirty}, []string{"VERSION", "DIRTY"}, func() [][]string {
+		return [][]string{{strconv.FormatUint(uint64(version), 10), strconv.FormatBool(dirty)}}
+	})
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/captainmango/greenlight/internal/data"
+	"github.com/captainmango/greenlight/pkg/client"
+)
+
+func (c *cli) movies(ctx context.Context, args []string) error {
+	return c.subcommand(ctx, "movies", args, map[string]func(context.Context, []string) error{
+		"list":   c.listMovies,
+		"get":    c.getMovie,
+		"create": c.createMovie,
+		"update": c.updateMovie,
+		"delete": c.deleteMovie,
+	})
+}
+
+func (c *cli) listMovies(ctx context.Context, args []string) error {
+	var options client.ListMoviesOptions
+
+	fs := c.flags("movies list")
+	fs.StringVar(&options.Title, "title", "", "Only movies with these words in the title")
+	fs.Func("genres", "Only movies with all of these comma-separated genres", genresFlag(&options.Genres))
+	fs.IntVar(&options.Page, "page", 0, "Page to show")
+	fs.IntVar(&options.PageSize, "page-size", 0, "Movies per page")
+	fs.StringVar(&options.Sort, "sort", "", "Field to sort by, with - in front for descending")
+
+	rest, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return c.usageError("movies list takes no arguments")
+	}
+
+	api, err := c.api()
+	if err != nil {
+		return err
+	}
+
+	movies, metadata, err := api.Movies().List(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	if c.output != "table" {
+		out := map[string]any{"movies": movies}
+		if options.Page > 0 || options.PageSize > 0 {
+			out["metadata"] = metadata
+		}
+
+		return c.print(out, nil, nil)
+	}
+
+	err = c.printMovies(movies...)
+	if err != nil {
+		return err
+	}
+
+	if metadata.TotalRecords > 0 {
+		_, err = fmt.Fprintf(c.stdout, "\nPage %d of %d (%d movies)\n", metadata.CurrentPage, metadata.LastPage, metadata.TotalRecords)
+	}
+
+	return err
+}
+
+func (c *cli) getMovie(ctx context.Context, args []string) error {
+	rest, err := parse(c.flags("movies get"), args)
+	if err != nil {
+		return err
+	}
+
+	id, err := c.parseID("movies get", rest)
+	if err != nil {
+		return err
+	}
+
+	api, err := c.api()
+	if err != nil {
+		return err
+	}
+
+	movie, err := api.Movies().Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return c.printMovie(movie)
+}
+
+func (c *cli) createMovie(ctx context.Context, args []string) error {
+	var movie client.Movie
+
+	fs := c.flags("movies create")
+	movieFlags(fs, &movie)
+
+	rest, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return c.usageError("movies create takes no arguments")
+	}
+
+	api, err := c.api()
+	if err != nil {
+		return err
+	}
+
+	created, err := api.Movies().Create(ctx, &movie)
+	if err != nil {
+		return err
+	}
+
+	return c.printMovie(created)
+}
+
+// updateMovie changes only the fields given as flags. It reads the movie first and
+// updates from the version it read, unless -version is given, so a save by someone
+// else in between is still reported as a conflict.
+func (c *cli) updateMovie(ctx context.Context, args []string) error {
+	var changes client.Movie
+	var version int
+
+	fs := c.flags("movies update")
+	movieFlags(fs, &changes)
+	fs.IntVar(&version, "version", 0, "Version the change is based on (default the current version)")
+
+	rest, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+
+	id, err := c.parseID("movies update", rest)
+	if err != nil {
+		return err
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	api, err := c.api()
+	if err != nil {
+		return err
+	}
+
+	movie, err := api.Movies().Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if set["title"] {
+		movie.Title = changes.Title
+	}
+	if set["year"] {
+		movie.Year = changes.Year
+	}
+	if set["runtime"] {
+		movie.Runtime = changes.Runtime
+	}
+	if set["genres"] {
+		movie.Genres = changes.Genres
+	}
+	if set["version"] {
+		movie.Version = int32(version)
+	}
+
+	updated, err := api.Movies().Update(ctx, movie)
+	if err != nil {
+		return err
+	}
+
+	return c.printMovie(updated)
+}
+
+func (c *cli) deleteMovie(ctx context.Context, args []string) error {
+	rest, err := parse(c.flags("movies delete"), args)
+	if err != nil {
+		return err
+	}
+
+	id, err := c.parseID("movies delete", rest)
+	if err != nil {
+		return err
+	}
+
+	api, err := c.api()
+	if err != nil {
+		return err
+	}
+
+	err = api.Movies().Delete(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return c.message("Movie %d moved to the trash", id)
+}
+
+// movieFlags registers the editable movie fields on fs.
+func movieFlags(fs *flag.FlagSet, movie *client.Movie) {
+	fs.StringVar(&movie.Title, "title", "", "Title")
+	fs.Func("year", "Release year", func(s string) error {
+		year, err := strconv.ParseInt(s, 10, 32)
+		movie.Year = int32(year)
+		return err
+	})
+	fs.Func("runtime", `Runtime in minutes, like "102" or "102 mins"`, func(s string) error {
+		if !strings.HasSuffix(s, " mins") {
+			s += " mins"
+		}
+
+		runtime, err := data.ParseRuntime(s)
+		movie.Runtime = runtime
+		return err
+	})
+	fs.Func("genres", "Comma-separated genres", genresFlag(&movie.Genres))
+}
+
+func genresFlag(genres *[]string) func(string) error {
+	return func(s string) error {
+		*genres = []string{}
+		for genre := range strings.SplitSeq(s, ",") {
+			if genre = strings.TrimSpace(genre); genre != "" {
+				*genres = append(*genres, genre)
+			}
+		}
+		return nil
+	}
+}
+
+func (c *cli) printMovie(movie *client.Movie) error {
+	if c.output != "table" {
+		return c.print(map[string]any{"movie": movie}, nil, nil)
+	}
+
+	return c.printMovies(*movie)
+}
+
+func (c *cli) printMovies(movies ...client.Movie) error {
+	headers := []string{"ID", "TITLE", "YEAR", "RUNTIME", "GENRES", "RATING", "VERSION"}
+
+	return c.print(movies, headers, func() [][]string {
+		rows := make([][]string, 0, len(movies))
+		for _, m := range movies {
+			rating := "-"
+			if m.RatingCount > 0 {
+				rating = fmt.Sprintf("%.1f (%d)", m.AverageRating, m.RatingCount)
+			}
+
+			rows = append(rows, []string{
+				strconv.FormatInt(m.ID, 10),
+				m.Title,
+				strconv.Itoa(int(m.Year)),
+				fmt.Sprintf("%d mins", m.Runtime),
+				strings.Join(m.Genres, ", "),
+				rating,
+				strconv.Itoa(int(m.Version)),
+			})
+		}
+		return rows
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// print writes v in the chosen output format. For tables, rows turns the same value
+// into cells under headers.
+func (c *cli) print(v any, headers []string, rows func() [][]string) error {
+	switch c.output {
+	case "json":
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "yaml":
+		return c.printYAML(v)
+	default:
+		tw := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(headers, "\t"))
+		for _, row := range rows() {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	}
+}
+
+// printYAML writes v as YAML with the same field names and values as the JSON output,
+// since the API types only have JSON tags. JSON is valid YAML, so it is parsed into a
+// node tree (keeping the key order) and printed again in block style.
+func (c *cli) printYAML(v any) error {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	var node yaml.Node
+	if err := yaml.Unmarshal(js, &node); err != nil {
+		return err
+	}
+
+	var plain func(*yaml.Node)
+	plain = func(n *yaml.Node) {
+		n.Style = 0
+		for _, child := range n.Content {
+			plain(child)
+		}
+	}
+	plain(&node)
+
+	enc := yaml.NewEncoder(c.stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return err
+	}
+
+	return enc.Close()
+}
+
+// message reports the outcome of a command with nothing else to show, like a delete.
+func (c *cli) message(format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+
+	if c.output == "table" {
+		_, err := fmt.Fprintln(c.stdout, msg)
+		return err
+	}
+
+	return c.print(map[string]string{"message": msg}, nil, nil)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/captainmango/greenlight/pkg/client"
+)
+
+func (c *cli) importMovies(ctx context.Context, args []string) error {
+	var options client.ImportMoviesOptions
+
+	fs := c.flags("import")
+	fs.StringVar(&options.Format, "format", "", "csv or ndjson (default from the file extension, or ndjson)")
+	fs.BoolVar(&options.DryRun, "dry-run", false, "Check the file without saving anything")
+	fs.StringVar(&options.IdempotencyKey, "idempotency-key", "", "Key that makes it safe to run the same import again")
+
+	rest, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return c.usageError("import needs a file, or - for standard input")
+	}
+
+	r := c.stdin
+	if rest[0] != "-" {
+		f, err := os.Open(rest[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+
+		if options.Format == "" && strings.EqualFold(filepath.Ext(rest[0]), ".csv") {
+			options.Format = client.FormatCSV
+		}
+	}
+
+	api, err := c.api()
+	if err != nil {
+		return err
+	}
+
+	report, err := api.Movies().Import(ctx, r, options)
+	if err != nil {
+		return err
+	}
+
+	if c.output != "table" {
+		return c.print(map[string]any{"import": report}, nil, nil)
+	}
+
+	verb := "Imported"
+	if report.DryRun {
+		verb = "Checked (dry run)"
+	}
+
+	fmt.Fprintf(c.stdout, "%s %d rows: %d inserted, %d updated, %d skipped\n", verb, report.Rows, report.Inserted, report.Updated, report.Skipped)
+
+	if len(report.Errors) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(c.stdout)
+
+	return c.print(report.Errors, []string{"LINE", "FIELD", "ERROR"}, func() [][]string {
+		var rows [][]string
+		for _, e := range report.Errors {
+			fields := make([]string, 0, len(e.Errors))
+			for field := range e.Errors {
+				fields = append(fields, field)
+			}
+			slices.Sort(fields)
+
+			for _, field := range fields {
+				rows = append(rows, []string{strconv.Itoa(e.Line), field, e.Errors[field]})
+			}
+		}
+		return rows
+	})
+}
+
+// exportMovies writes the API's export as-is; -o doesn't apply, since the file is
+// meant to be imported again.
+func (c *cli) exportMovies(ctx context.Context, args []string) error {
+	var options client.ExportMoviesOptions
+	var path string
+
+	fs := c.flags("export")
+	fs.StringVar(&options.Format, "format", client.FormatNDJSON, "csv or ndjson")
+	fs.StringVar(&options.Title, "title", "", "Only movies with these words in the title")
+	fs.Func("genres", "Only movies with all of these comma-separated genres", genresFlag(&options.Genres))
+	fs.StringVar(&options.Sort, "sort", "", "Field to sort by, with - in front for descending")
+	fs.StringVar(&path, "file", "", "File to write (default standard output)")
+
+	rest, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return c.usageError("export takes no arguments")
+	}
+
+	api, err := c.api()
+	if err != nil {
+		return err
+	}
+
+	if path == "" {
+		return api.Movies().Export(ctx, options, c.stdout)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = api.Movies().Export(ctx, options, f)
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+
+	return f.Close()
+}
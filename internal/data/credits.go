@@ -0,0 +1,225 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/captainmango/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+var ErrDuplicateCredit = errors.New("duplicate credit")
+
+var CreditRoles = []string{"director", "writer", "producer", "cast"}
+
+// A Credit links a person to a movie. Depending on which side it is loaded from, either
+// the person or the movie details are filled in so clients don't need a second request.
+type Credit struct {
+	ID           int64  `json:"id"`
+	MovieID      int64  `json:"movie_id"`
+	PersonID     int64  `json:"person_id"`
+	Role         string `json:"role"`
+	Character    string `json:"character,omitempty"`
+	BillingOrder int32  `json:"billing_order"`
+	PersonName   string `json:"person_name,omitempty"`
+	MovieTitle   string `json:"movie_title,omitempty"`
+	MovieYear    int32  `json:"movie_year,omitzero"`
+}
+
+type CreditDAO struct {
+	DB *sql.DB
+}
+
+func ValidateCredit(v *validator.Validator, credit *Credit) {
+	v.Check(credit.PersonID > 0, "person_id", "must be provided")
+	v.Check(validator.PermittedValue(credit.Role, CreditRoles...), "role", "must be one of director, writer, producer or cast")
+	v.Check(credit.Role == "cast" || credit.Character == "", "character", "must only be provided for cast credits")
+	v.Check(len(credit.Character) <= 500, "character", "must not be more than 500 bytes long")
+	v.Check(credit.BillingOrder >= 0, "billing_order", "must not be negative")
+}
+
+// Insert adds a credit. A missing (or trashed) movie, or a missing person, is reported
+// as ErrRecordNotFound.
+func (m CreditDAO) Insert(credit *Credit) error {
+	query := `
+INSERT INTO credits (movie_id, person_id, role, character, billing_order)
+SELECT id, $2, $3, $4, $5
+FROM movies
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING id`
+
+	args := []any{credit.MovieID, credit.PersonID, credit.Role, credit.Character, credit.BillingOrder}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&credit.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) {
+			switch pqErr.Code.Name() {
+			case "foreign_key_violation":
+				return ErrRecordNotFound
+			case "unique_violation":
+				return ErrDuplicateCredit
+			}
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (m CreditDAO) Delete(movieID, creditID int64) error {
+	query := `
+DELETE FROM credits
+WHERE id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, creditID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetForMovies loads the credits for a set of movies in a single query, keyed by movie
+// ID. Use this instead of calling GetForMovie in a loop.
+func (m CreditDAO) GetForMovies(movieIDs []int64) (map[int64][]Credit, error) {
+	query := `
+SELECT credits.id, credits.movie_id, credits.person_id, credits.role, credits.character,
+	credits.billing_order, people.name
+FROM credits
+INNER JOIN people ON people.id = credits.person_id
+WHERE credits.movie_id = ANY($1)
+ORDER BY credits.movie_id, credits.billing_order, credits.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := make(map[int64][]Credit, len(movieIDs))
+	for rows.Next() {
+		var credit Credit
+
+		err := rows.Scan(
+			&credit.ID,
+			&credit.MovieID,
+			&credit.PersonID,
+			&credit.Role,
+			&credit.Character,
+			&credit.BillingOrder,
+			&credit.PersonName,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		credits[credit.MovieID] = append(credits[credit.MovieID], credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+func (m CreditDAO) GetForMovie(movieID int64) ([]Credit, error) {
+	credits, err := m.GetForMovies([]int64{movieID})
+	if err != nil {
+		return nil, err
+	}
+
+	if credits[movieID] == nil {
+		return []Credit{}, nil
+	}
+
+	return credits[movieID], nil
+}
+
+// GetFilmographies loads the filmographies of a set of people in a single query, keyed
+// by person ID, newest movies first.
+func (m CreditDAO) GetFilmographies(personIDs []int64) (map[int64][]Credit, error) {
+	query := `
+SELECT credits.id, credits.movie_id, credits.person_id, credits.role, credits.character,
+	credits.billing_order, movies.title, movies.year
+FROM credits
+INNER JOIN movies ON movies.id = credits.movie_id
+WHERE credits.person_id = ANY($1) AND movies.deleted_at IS NULL
+ORDER BY credits.person_id, movies.year DESC, movies.title, credits.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(personIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := make(map[int64][]Credit, len(personIDs))
+	for rows.Next() {
+		var credit Credit
+
+		err := rows.Scan(
+			&credit.ID,
+			&credit.MovieID,
+			&credit.PersonID,
+			&credit.Role,
+			&credit.Character,
+			&credit.BillingOrder,
+			&credit.MovieTitle,
+			&credit.MovieYear,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		credits[credit.PersonID] = append(credits[credit.PersonID], credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// GetFilmography returns every credit for a person, newest movies first.
+func (m CreditDAO) GetFilmography(personID int64) ([]Credit, error) {
+	credits, err := m.GetFilmographies([]int64{personID})
+	if err != nil {
+		return nil, err
+	}
+
+	if credits[personID] == nil {
+		return []Credit{}, nil
+	}
+
+	return credits[personID], nil
+}
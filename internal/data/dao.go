@@ -7,15 +7,41 @@ import (
 
 var (
 	ErrRecordNotFound = errors.New("record not found")
-	ErrEditConflict = errors.New("edit conflict")
+	ErrEditConflict   = errors.New("edit conflict")
 )
 
 type DataAccessObjects struct {
-	Movies MovieDAO
+	Movies      MovieDAO
+	Revisions   MovieRevisionDAO
+	Genres      GenreDAO
+	People      PersonDAO
+	Credits     CreditDAO
+	Reviews     ReviewDAO
+	Lists       ListDAO
+	Users       UserDAO
+	Tokens      TokenDAO
+	Permissions PermissionDAO
+	Idempotency IdempotencyKeyDAO
+	Webhooks    WebhookDAO
+	Events      MovieEventDAO
+	Locks       MovieLockDAO
 }
 
 func NewDataAccessObjects(db *sql.DB) DataAccessObjects {
 	return DataAccessObjects{
-		Movies: MovieDAO{DB: db},
+		Movies:      MovieDAO{DB: db},
+		Revisions:   MovieRevisionDAO{DB: db},
+		Genres:      GenreDAO{DB: db},
+		People:      PersonDAO{DB: db},
+		Credits:     CreditDAO{DB: db},
+		Reviews:     ReviewDAO{DB: db},
+		Lists:       ListDAO{DB: db},
+		Users:       UserDAO{DB: db},
+		Tokens:      TokenDAO{DB: db},
+		Permissions: PermissionDAO{DB: db},
+		Idempotency: IdempotencyKeyDAO{DB: db},
+		Webhooks:    WebhookDAO{DB: db},
+		Events:      MovieEventDAO{DB: db},
+		Locks:       MovieLockDAO{DB: db},
 	}
 }
@@ -0,0 +1,151 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	EventMovieCreated  = "movie.created"
+	EventMovieUpdated  = "movie.updated"
+	EventMovieDeleted  = "movie.deleted"
+	EventMovieRestored = "movie.restored"
+)
+
+// MovieEvents lists every event a webhook can subscribe to.
+var MovieEvents = []string{EventMovieCreated, EventMovieUpdated, EventMovieDeleted, EventMovieRestored}
+
+// revisionEvents maps each revision action to the event it's published as. Reverts
+// are just another update as far as subscribers are concerned.
+var revisionEvents = map[string]string{
+	RevisionInsert:  EventMovieCreated,
+	RevisionUpdate:  EventMovieUpdated,
+	RevisionRevert:  EventMovieUpdated,
+	RevisionDelete:  EventMovieDeleted,
+	RevisionRestore: EventMovieRestored,
+}
+
+// A MovieEvent is a change to a movie, written to the movie_events outbox in the same
+// transaction as the change itself. Movie holds the movie as it was after the change
+// (or just before it, for a hard delete).
+type MovieEvent struct {
+	ID        int64           `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Event     string          `json:"event"`
+	MovieID   int64           `json:"movie_id"`
+	Movie     json.RawMessage `json:"movie"`
+}
+
+// recordMovieEvents adds an event to the outbox for each movie. It's called from
+// recordMovieRevision(s), so every change that is audited is also published.
+func recordMovieEvents(ctx context.Context, tx *sql.Tx, movieIDs []int64, action string) error {
+	query := `
+INSERT INTO movie_events (event, movie_id, payload)
+SELECT $2, id, jsonb_build_object('id', id, 'version', version) || ` + movieSnapshot + `
+FROM movies
+WHERE id = ANY($1)
+ORDER BY id`
+
+	_, err := tx.ExecContext(ctx, query, pq.Array(movieIDs), revisionEvents[action])
+	return err
+}
+
+type MovieEventDAO struct {
+	DB *sql.DB
+}
+
+func (m MovieEventDAO) Get(id int64) (*MovieEvent, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+SELECT id, created_at, event, movie_id, payload
+FROM movie_events
+WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var (
+		event   MovieEvent
+		payload []byte
+	)
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&event.ID, &event.CreatedAt, &event.Event, &event.MovieID, &payload)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	event.Movie = payload
+
+	return &event, nil
+}
+
+// GetSince returns up to limit events that came after afterID, oldest first. complete
+// is false when that isn't every event since afterID, either because there were more
+// than limit of them or because some of them have already been purged from the log.
+func (m MovieEventDAO) GetSince(afterID int64, limit int) ([]MovieEvent, bool, error) {
+	query := `
+SELECT id, created_at, event, movie_id, payload
+FROM movie_events
+WHERE id > $1
+ORDER BY id
+LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, afterID, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var events []MovieEvent
+
+	for rows.Next() {
+		var (
+			event   MovieEvent
+			payload []byte
+		)
+
+		err := rows.Scan(&event.ID, &event.CreatedAt, &event.Event, &event.MovieID, &payload)
+		if err != nil {
+			return nil, false, err
+		}
+
+		event.Movie = payload
+
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	// The horizon is read after the events, so a purge in between can only make the
+	// answer more cautious. Comparing it with afterID, rather than looking for a gap
+	// before the first event, means IDs the sequence skipped aren't taken for purged
+	// events.
+	var purgedThrough int64
+
+	err = m.DB.QueryRowContext(ctx, `SELECT purged_through FROM movie_events_horizon`).Scan(&purgedThrough)
+	if err != nil {
+		return nil, false, err
+	}
+
+	complete := len(events) <= limit && purgedThrough <= afterID
+
+	return events[:min(len(events), limit)], complete, nil
+}
@@ -0,0 +1,239 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/captainmango/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateSlug = errors.New("duplicate slug")
+	ErrGenreInUse    = errors.New("genre in use")
+
+	SlugRX = regexp.MustCompile("^[a-z0-9]+(?:-[a-z0-9]+)*$")
+)
+
+type Genre struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	Version   int32     `json:"version"`
+}
+
+type GenreDAO struct {
+	DB *sql.DB
+}
+
+func ValidateGenre(v *validator.Validator, genre *Genre) {
+	v.Check(genre.Slug != "", "slug", "must be provided")
+	v.Check(len(genre.Slug) <= 100, "slug", "must not be more than 100 bytes long")
+	v.Check(validator.Matches(genre.Slug, SlugRX), "slug", "must only contain lowercase letters, numbers and single hyphens")
+
+	v.Check(genre.Name != "", "name", "must be provided")
+	v.Check(len(genre.Name) <= 100, "name", "must not be more than 100 bytes long")
+}
+
+// ValidateMovieGenres checks every genre on a movie is part of the managed
+// vocabulary. The error lists the allowed slugs so clients can correct themselves.
+func ValidateMovieGenres(v *validator.Validator, genres []string, permitted []string) {
+	for _, genre := range genres {
+		if !validator.PermittedValue(genre, permitted...) {
+			v.AddError("genres", fmt.Sprintf("must only contain permitted values: %s", strings.Join(permitted, ", ")))
+			return
+		}
+	}
+}
+
+func (m GenreDAO) Insert(genre *Genre) error {
+	query := `
+INSERT INTO genres (slug, name)
+VALUES ($1, $2)
+RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, genre.Slug, genre.Name).Scan(&genre.ID, &genre.CreatedAt, &genre.Version)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "genres_slug_key"):
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m GenreDAO) Get(id int64) (*Genre, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+SELECT id, created_at, slug, name, version
+FROM genres
+WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var genre Genre
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&genre.ID,
+		&genre.CreatedAt,
+		&genre.Slug,
+		&genre.Name,
+		&genre.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &genre, nil
+}
+
+func (m GenreDAO) GetAll() ([]Genre, error) {
+	query := `
+SELECT id, created_at, slug, name, version
+FROM genres
+ORDER BY slug`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []Genre{}
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(
+			&genre.ID,
+			&genre.CreatedAt,
+			&genre.Slug,
+			&genre.Name,
+			&genre.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+// Slugs returns the permitted genre values in alphabetical order.
+func (m GenreDAO) Slugs() ([]string, error) {
+	query := `
+SELECT array_agg(slug ORDER BY slug)
+FROM genres`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var slugs []string
+
+	err := m.DB.QueryRowContext(ctx, query).Scan(pq.Array(&slugs))
+	if err != nil {
+		return nil, err
+	}
+
+	return slugs, nil
+}
+
+func (m GenreDAO) Update(genre *Genre) error {
+	query := `
+UPDATE genres
+SET slug = $1, name = $2, version = version + 1
+WHERE id = $3 AND version = $4
+RETURNING version`
+
+	args := []any{genre.Slug, genre.Name, genre.ID, genre.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&genre.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case isUniqueViolation(err, "genres_slug_key"):
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isUniqueViolation reports whether err is Postgres rejecting a write because it
+// breaks the named unique constraint.
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" && pqErr.Constraint == constraint
+}
+
+// Delete removes a genre from the vocabulary. Genres that are still attached to a
+// movie are protected by the foreign key, which we surface as ErrGenreInUse.
+func (m GenreDAO) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+DELETE FROM genres
+WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation" {
+			return ErrGenreInUse
+		}
+
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// An IdempotencyRecord is what was stored for an Idempotency-Key. Status is zero while
+// the original request is still in flight.
+type IdempotencyRecord struct {
+	Fingerprint []byte
+	Status      int
+	Header      map[string][]string
+	Body        []byte
+}
+
+type IdempotencyKeyDAO struct {
+	DB *sql.DB
+}
+
+// Reserve claims a key for a new request. It returns a nil record when the caller now
+// owns the key and should handle the request, or the stored record when the key has
+// been seen before. Expired keys that haven't been purged yet are claimed again.
+func (m IdempotencyKeyDAO) Reserve(userID int64, key string, fingerprint []byte, ttl time.Duration) (*IdempotencyRecord, error) {
+	query := `
+INSERT INTO idempotency_keys (user_id, key, fingerprint, expiry)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (user_id, key) DO UPDATE
+SET fingerprint = EXCLUDED.fingerprint, status = NULL, headers = NULL, body = NULL,
+	created_at = NOW(), expiry = EXCLUDED.expiry
+WHERE idempotency_keys.expiry < NOW()
+RETURNING user_id`
+
+	args := []any{userID, key, fingerprint, time.Now().Add(ttl)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var owner int64
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&owner)
+	if err == nil {
+		return nil, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	query = `
+SELECT fingerprint, status, headers, body
+FROM idempotency_keys
+WHERE user_id = $1 AND key = $2`
+
+	var (
+		record  IdempotencyRecord
+		status  sql.NullInt32
+		headers []byte
+	)
+
+	err = m.DB.QueryRowContext(ctx, query, userID, key).Scan(&record.Fingerprint, &status, &headers, &record.Body)
+	if err != nil {
+		switch {
+		// The key was released between the two queries, so another request is
+		// working with it.
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+
+	record.Status = int(status.Int32)
+
+	if headers != nil {
+		if err = json.Unmarshal(headers, &record.Header); err != nil {
+			return nil, err
+		}
+	}
+
+	return &record, nil
+}
+
+// Complete stores the response for a key reserved with Reserve, so later requests with
+// the same key get it replayed. The fingerprint replaces the one given to Reserve, for
+// requests whose bodies were only read in full while being handled.
+func (m IdempotencyKeyDAO) Complete(userID int64, key string, fingerprint []byte, status int, header map[string][]string, body []byte) error {
+	headers, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	query := `
+UPDATE idempotency_keys
+SET fingerprint = $3, status = $4, headers = $5, body = $6
+WHERE user_id = $1 AND key = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, userID, key, fingerprint, status, headers, body)
+	return err
+}
+
+// Release gives up a reserved key without storing a response, so the client can retry
+// a request that failed on our side.
+func (m IdempotencyKeyDAO) Release(userID int64, key string) error {
+	query := `
+DELETE FROM idempotency_keys
+WHERE user_id = $1 AND key = $2 AND status IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, key)
+	return err
+}
+
+// DeleteExpired removes every key past its expiry and returns how many went.
+func (m IdempotencyKeyDAO) DeleteExpired() (int64, error) {
+	query := `
+DELETE FROM idempotency_keys
+WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,454 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/captainmango/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateListName = errors.New("duplicate list name")
+	ErrDuplicateListItem = errors.New("duplicate list item")
+)
+
+type List struct {
+	ID        int64      `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UserID    int64      `json:"user_id"`
+	Name      string     `json:"name"`
+	Public    bool       `json:"public"`
+	ShareSlug string     `json:"share_slug,omitempty"`
+	Version   int32      `json:"version"`
+	Items     []ListItem `json:"items,omitempty"`
+}
+
+type ListItem struct {
+	MovieID  int64     `json:"movie_id"`
+	Position int32     `json:"position"`
+	Title    string    `json:"title"`
+	Year     int32     `json:"year,omitzero"`
+	AddedAt  time.Time `json:"added_at"`
+}
+
+type ListDAO struct {
+	DB *sql.DB
+}
+
+func ValidateList(v *validator.Validator, list *List) {
+	v.Check(list.Name != "", "name", "must be provided")
+	v.Check(len(list.Name) <= 200, "name", "must not be more than 200 bytes long")
+}
+
+// ValidateListOrder checks a reorder request names every movie on the list exactly once.
+func ValidateListOrder(v *validator.Validator, movieIDs []int64, items []ListItem) {
+	v.Check(validator.Unique(movieIDs), "movie_ids", "must not contain duplicate values")
+	v.Check(len(movieIDs) == len(items), "movie_ids", "must contain every movie on the list")
+
+	for _, item := range items {
+		if !slices.Contains(movieIDs, item.MovieID) {
+			v.AddError("movie_ids", "must contain every movie on the list")
+			return
+		}
+	}
+}
+
+// generateShareSlug returns an unguessable slug for sharing public lists. It's 130
+// bits of randomness, so nobody can find a list by walking through values.
+func generateShareSlug() string {
+	return strings.ToLower(rand.Text())
+}
+
+func (m ListDAO) Insert(list *List) error {
+	query := `
+INSERT INTO lists (user_id, name, public, share_slug)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at, share_slug, version`
+
+	args := []any{list.UserID, list.Name, list.Public, generateShareSlug()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&list.ID, &list.CreatedAt, &list.ShareSlug, &list.Version)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "lists_user_id_name_key"):
+			return ErrDuplicateListName
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m ListDAO) Get(id int64) (*List, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+SELECT id, created_at, user_id, name, public, share_slug, version
+FROM lists
+WHERE id = $1`
+
+	return m.getOne(query, id)
+}
+
+// GetPublicBySlug only finds lists that their owner has made public.
+func (m ListDAO) GetPublicBySlug(slug string) (*List, error) {
+	query := `
+SELECT id, created_at, user_id, name, public, share_slug, version
+FROM lists
+WHERE share_slug = $1 AND public = true`
+
+	return m.getOne(query, slug)
+}
+
+func (m ListDAO) getOne(query string, arg any) (*List, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var list List
+
+	err := m.DB.QueryRowContext(ctx, query, arg).Scan(
+		&list.ID,
+		&list.CreatedAt,
+		&list.UserID,
+		&list.Name,
+		&list.Public,
+		&list.ShareSlug,
+		&list.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &list, nil
+}
+
+func (m ListDAO) GetAllForUser(userID int64) ([]List, error) {
+	query := `
+SELECT id, created_at, user_id, name, public, share_slug, version
+FROM lists
+WHERE user_id = $1
+ORDER BY name, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := []List{}
+	for rows.Next() {
+		var list List
+
+		err := rows.Scan(
+			&list.ID,
+			&list.CreatedAt,
+			&list.UserID,
+			&list.Name,
+			&list.Public,
+			&list.ShareSlug,
+			&list.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		lists = append(lists, list)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lists, nil
+}
+
+func (m ListDAO) Update(list *List) error {
+	query := `
+UPDATE lists
+SET name = $1, public = $2, version = version + 1
+WHERE id = $3 AND version = $4
+RETURNING version`
+
+	args := []any{list.Name, list.Public, list.ID, list.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&list.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case isUniqueViolation(err, "lists_user_id_name_key"):
+			return ErrDuplicateListName
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m ListDAO) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+DELETE FROM lists
+WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetItems returns the movies on a list in order. Positions are renumbered from 1 on
+// the way out, so gaps left behind by deleted movies never reach clients.
+func (m ListDAO) GetItems(listID int64) ([]ListItem, error) {
+	items, err := m.GetItemsForLists([]int64{listID})
+	if err != nil {
+		return nil, err
+	}
+
+	if items[listID] == nil {
+		return []ListItem{}, nil
+	}
+
+	return items[listID], nil
+}
+
+// GetItemsForLists loads the items on a set of lists in a single query, keyed by list
+// ID, numbered the same way as GetItems.
+func (m ListDAO) GetItemsForLists(listIDs []int64) (map[int64][]ListItem, error) {
+	query := `
+SELECT list_items.list_id, list_items.movie_id,
+	row_number() OVER (PARTITION BY list_items.list_id ORDER BY list_items.position, list_items.added_at),
+	movies.title, movies.year, list_items.added_at
+FROM list_items
+INNER JOIN movies ON movies.id = list_items.movie_id
+WHERE list_items.list_id = ANY($1) AND movies.deleted_at IS NULL
+ORDER BY list_items.list_id, list_items.position, list_items.added_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(listIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make(map[int64][]ListItem, len(listIDs))
+	for rows.Next() {
+		var (
+			listID int64
+			item   ListItem
+		)
+
+		err := rows.Scan(
+			&listID,
+			&item.MovieID,
+			&item.Position,
+			&item.Title,
+			&item.Year,
+			&item.AddedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		items[listID] = append(items[listID], item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// lockListItems takes a lock on the list row so concurrent item changes to the same
+// list queue up behind each other, then compacts positions back to 1..n.
+func lockListItems(ctx context.Context, tx *sql.Tx, listID int64) error {
+	_, err := tx.ExecContext(ctx, `SELECT id FROM lists WHERE id = $1 FOR UPDATE`, listID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+UPDATE list_items
+SET position = ordered.position
+FROM (
+	SELECT movie_id, row_number() OVER (ORDER BY position, added_at) AS position
+	FROM list_items
+	WHERE list_id = $1
+) AS ordered
+WHERE list_items.list_id = $1 AND list_items.movie_id = ordered.movie_id`
+
+	_, err = tx.ExecContext(ctx, query, listID)
+	return err
+}
+
+// AddItem puts a movie on the list at the given position, shifting later items down.
+// A position of zero, or one past the end, appends the movie.
+func (m ListDAO) AddItem(listID, movieID int64, position int32) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = lockListItems(ctx, tx, listID); err != nil {
+		return err
+	}
+
+	var count int32
+
+	err = tx.QueryRowContext(ctx, `SELECT count(*) FROM list_items WHERE list_id = $1`, listID).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if position < 1 || position > count {
+		position = count + 1
+	}
+
+	_, err = tx.ExecContext(ctx, `
+UPDATE list_items
+SET position = position + 1
+WHERE list_id = $1 AND position >= $2`, listID, position)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+INSERT INTO list_items (list_id, movie_id, position)
+SELECT $1, id, $3
+FROM movies
+WHERE id = $2 AND deleted_at IS NULL`, listID, movieID, position)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) {
+			switch pqErr.Code.Name() {
+			case "foreign_key_violation":
+				return ErrRecordNotFound
+			case "unique_violation":
+				return ErrDuplicateListItem
+			}
+		}
+
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+func (m ListDAO) RemoveItem(listID, movieID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = lockListItems(ctx, tx, listID); err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM list_items WHERE list_id = $1 AND movie_id = $2`, listID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+// Reorder sets the list order to match movieIDs. Callers should check with
+// ValidateListOrder that it names every item on the list.
+func (m ListDAO) Reorder(listID int64, movieIDs []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = lockListItems(ctx, tx, listID); err != nil {
+		return err
+	}
+
+	query := `
+UPDATE list_items
+SET position = ordered.position
+FROM unnest($2::bigint[]) WITH ORDINALITY AS ordered(movie_id, position)
+WHERE list_items.list_id = $1 AND list_items.movie_id = ordered.movie_id`
+
+	_, err = tx.ExecContext(ctx, query, listID, pq.Array(movieIDs))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
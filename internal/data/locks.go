@@ -0,0 +1,212 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrMovieLocked = errors.New("movie locked")
+
+// A MovieLock is an advisory lease on editing a movie. It doesn't stop anything at the
+// database level; the handlers for writes check it.
+type MovieLock struct {
+	MovieID    int64     `json:"movie_id"`
+	UserID     int64     `json:"user_id"`
+	UserName   string    `json:"user_name"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Action is set on lock notifications: acquired, renewed, released or expired.
+	Action string `json:"action,omitempty"`
+}
+
+type MovieLockDAO struct {
+	DB *sql.DB
+}
+
+// Acquire takes the lock on a movie for the user, or extends it if they already hold
+// it. renewed is true in the second case. If someone else holds a lock that hasn't
+// expired, it returns ErrMovieLocked along with their lock.
+func (m MovieLockDAO) Acquire(movieID, userID int64, ttl time.Duration) (lock *MovieLock, renewed bool, err error) {
+	if movieID < 1 {
+		return nil, false, ErrRecordNotFound
+	}
+
+	query := `
+WITH lock AS (
+	INSERT INTO movie_locks (movie_id, user_id, expires_at)
+	SELECT id, $2, NOW() + $3 * interval '1 millisecond'
+	FROM movies
+	WHERE id = $1 AND deleted_at IS NULL
+	ON CONFLICT (movie_id) DO UPDATE
+	SET user_id = EXCLUDED.user_id,
+		acquired_at = CASE WHEN movie_locks.user_id = EXCLUDED.user_id AND movie_locks.expires_at > NOW() THEN movie_locks.acquired_at ELSE NOW() END,
+		expires_at = EXCLUDED.expires_at
+	WHERE movie_locks.user_id = EXCLUDED.user_id OR movie_locks.expires_at <= NOW()
+	RETURNING *
+)
+SELECT lock.movie_id, lock.user_id, users.name, lock.acquired_at, lock.expires_at, lock.acquired_at < NOW()
+FROM lock
+INNER JOIN users ON users.id = lock.user_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	lock = &MovieLock{}
+
+	err = m.DB.QueryRowContext(ctx, query, movieID, userID, ttl.Milliseconds()).Scan(
+		&lock.MovieID,
+		&lock.UserID,
+		&lock.UserName,
+		&lock.AcquiredAt,
+		&lock.ExpiresAt,
+		&renewed,
+	)
+	if err == nil {
+		return lock, renewed, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, false, err
+	}
+
+	// Nothing came back, so either the movie doesn't exist or someone else has it.
+	lock, err = m.Get(movieID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return lock, false, ErrMovieLocked
+}
+
+// Get returns the current lock on a movie. Expired locks count as no lock.
+func (m MovieLockDAO) Get(movieID int64) (*MovieLock, error) {
+	if movieID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+SELECT movie_locks.movie_id, movie_locks.user_id, users.name, movie_locks.acquired_at, movie_locks.expires_at
+FROM movie_locks
+INNER JOIN users ON users.id = movie_locks.user_id
+WHERE movie_locks.movie_id = $1 AND movie_locks.expires_at > NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var lock MovieLock
+
+	err := m.DB.QueryRowContext(ctx, query, movieID).Scan(
+		&lock.MovieID,
+		&lock.UserID,
+		&lock.UserName,
+		&lock.AcquiredAt,
+		&lock.ExpiresAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &lock, nil
+}
+
+// GetAll returns the current locks on any of the movies, keyed by movie ID.
+func (m MovieLockDAO) GetAll(movieIDs []int64) (map[int64]*MovieLock, error) {
+	query := `
+SELECT movie_locks.movie_id, movie_locks.user_id, users.name, movie_locks.acquired_at, movie_locks.expires_at
+FROM movie_locks
+INNER JOIN users ON users.id = movie_locks.user_id
+WHERE movie_locks.movie_id = ANY($1) AND movie_locks.expires_at > NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	locks := make(map[int64]*MovieLock)
+
+	for rows.Next() {
+		var lock MovieLock
+
+		err := rows.Scan(
+			&lock.MovieID,
+			&lock.UserID,
+			&lock.UserName,
+			&lock.AcquiredAt,
+			&lock.ExpiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		locks[lock.MovieID] = &lock
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return locks, nil
+}
+
+// Release drops the lock on a movie. Unless force is set, only the user holding it can
+// release it; anyone else gets ErrMovieLocked along with the holder's lock.
+func (m MovieLockDAO) Release(movieID, userID int64, force bool) (*MovieLock, error) {
+	if movieID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+DELETE FROM movie_locks
+WHERE movie_id = $1 AND expires_at > NOW() AND (user_id = $2 OR $3)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, movieID, userID, force)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected > 0 {
+		return nil, nil
+	}
+
+	lock, err := m.Get(movieID)
+	if err != nil {
+		return nil, err
+	}
+
+	return lock, ErrMovieLocked
+}
+
+// DeleteExpired removes locks whose lease has run out, so watchers are told they've
+// gone, and returns how many there were.
+func (m MovieLockDAO) DeleteExpired() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `DELETE FROM movie_locks WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
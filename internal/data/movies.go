@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/captainmango/greenlight/internal/validator"
@@ -25,6 +26,11 @@ type Movie struct {
 	Runtime   Runtime   `json:"runtime,omitzero"`
 	Genres    []string  `json:"genres,omitempty"`
 	Version   int32     `json:"version"`
+	// The rating fields are maintained by ReviewDAO, so Insert and Update ignore them.
+	AverageRating float64    `json:"average_rating"`
+	RatingCount   int32      `json:"rating_count"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty"`
+	Credits       []Credit   `json:"credits,omitempty"`
 }
 
 type MovieJSON struct {
@@ -38,6 +44,19 @@ type MovieDAO struct {
 	DB *sql.DB
 }
 
+// Genres are stored in the movies_genres join table, so every query that returns a
+// movie folds them back into an ordered array of slugs with this subquery.
+const (
+	movieGenresArray = `ARRAY(
+	SELECT genres.slug
+	FROM movies_genres
+	INNER JOIN genres ON genres.id = movies_genres.genre_id
+	WHERE movies_genres.movie_id = movies.id
+	ORDER BY movies_genres.position
+)`
+	movieGenresColumn = movieGenresArray + ` AS genres`
+)
+
 func ValidateMovieJSON(v *validator.Validator, movie *Movie) {
 	// TITLE VALIDATIONS
 	v.Check(movie.Title != "", "title", "title cannot be empty")
@@ -59,23 +78,134 @@ func ValidateMovieJSON(v *validator.Validator, movie *Movie) {
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
 }
 
-func (m MovieDAO) Insert(movie *Movie) error {
+// Insert adds a movie and records its first revision against userID (zero for an
+// anonymous change).
+func (m MovieDAO) Insert(movie *Movie, userID int64) error {
 	// Define the SQL query for inserting a new record in the movies table and returning
 	// the system-generated data.
 	query := `
-INSERT INTO movies (title, year, runtime, genres)
-VALUES ($1, $2, $3, $4)
+INSERT INTO movies (title, year, runtime)
+VALUES ($1, $2, $3)
 RETURNING id, created_at, version`
 	// Create an args slice containing the values for the placeholder parameters from
 	// the movie struct. Declaring this slice immediately next to our SQL query helps to
 	// make it nice and clear *what values are being used where* in the query.
-	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
-	// Use the QueryRow() method to execute the SQL query on our connection pool,
+	args := []any{movie.Title, movie.Year, movie.Runtime}
+	// The movie and its genres live in different tables now, so both writes happen in
+	// one transaction to avoid leaving a movie behind without its genres.
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Use the QueryRow() method to execute the SQL query inside the transaction,
 	// passing in the args slice as a variadic parameter and scanning the system-
 	// generated id, created_at and version values into the movie struct.
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		return err
+	}
+
+	if err = setMovieGenres(ctx, tx, movie.ID, movie.Genres); err != nil {
+		return err
+	}
+
+	if err = recordMovieRevision(ctx, tx, movie.ID, RevisionInsert, userID, 0); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InsertWithID adds a movie under an ID picked by the client, so PUT can create
+// movies that a sync job has already given an ID. If the ID is taken, including by a
+// movie in the trash, it returns ErrEditConflict. So does an ID that belonged to a movie
+// deleted for good: its revisions are kept, and a new movie would inherit them. The ID
+// sequence is moved past the new row so later inserts without an ID don't collide with
+// it.
+func (m MovieDAO) InsertWithID(movie *Movie, userID int64) error {
+	query := `
+INSERT INTO movies (id, title, year, runtime)
+VALUES ($1, $2, $3, $4)
+RETURNING created_at, version`
+
+	args := []any{movie.ID, movie.Title, movie.Year, movie.Runtime}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var used bool
+
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM movie_revisions WHERE movie_id = $1)`, movie.ID).Scan(&used)
+	if err != nil {
+		return err
+	}
+	if used {
+		return ErrEditConflict
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.CreatedAt, &movie.Version)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" {
+			return ErrEditConflict
+		}
+
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+SELECT setval(pg_get_serial_sequence('movies', 'id'), max(id))
+FROM movies`)
+	if err != nil {
+		return err
+	}
+
+	if err = setMovieGenres(ctx, tx, movie.ID, movie.Genres); err != nil {
+		return err
+	}
+
+	// The check above should make this unreachable, but the index is what guarantees
+	// it, so a clash is still a conflict and not a server error.
+	err = recordMovieRevision(ctx, tx, movie.ID, RevisionInsert, userID, 0)
+	if err != nil {
+		if isUniqueViolation(err, "movie_revisions_movie_id_version_idx") {
+			return ErrEditConflict
+		}
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// setMovieGenres replaces the genres attached to a movie, keeping the order they
+// were given in. Slugs that aren't in the genres table are silently dropped by the
+// join, so callers must validate against the vocabulary first.
+func setMovieGenres(ctx context.Context, tx *sql.Tx, movieID int64, genres []string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM movies_genres WHERE movie_id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+INSERT INTO movies_genres (movie_id, genre_id, position)
+SELECT $1, genres.id, g.position
+FROM unnest($2::text[]) WITH ORDINALITY AS g(slug, position)
+INNER JOIN genres ON genres.slug = g.slug`
+
+	_, err = tx.ExecContext(ctx, query, movieID, pq.Array(genres))
+	return err
 }
 
 func (m MovieDAO) Get(id int64) (*Movie, error) {
@@ -85,9 +215,9 @@ func (m MovieDAO) Get(id int64) (*Movie, error) {
 
 	// specify the columns so if we change the schema the query doesn't break
 	query := `
-SELECT id, created_at, title, year, runtime, genres, version 
-FROM movies 
-WHERE id = $1;
+SELECT id, created_at, title, year, runtime, ` + movieGenresColumn + `, version, average_rating, rating_count
+FROM movies
+WHERE id = $1 AND deleted_at IS NULL;
 `
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -102,6 +232,8 @@ WHERE id = $1;
 		&resultMovie.Runtime,
 		pq.Array(&resultMovie.Genres),
 		&resultMovie.Version,
+		&resultMovie.AverageRating,
+		&resultMovie.RatingCount,
 	)
 
 	if err != nil {
@@ -116,20 +248,30 @@ WHERE id = $1;
 	return &resultMovie, nil
 }
 
-func (m MovieDAO) Update(movie *Movie) error {
+func (m MovieDAO) Update(movie *Movie, userID int64) error {
+	return m.update(movie, userID, RevisionUpdate, 0)
+}
+
+// Revert saves a movie whose fields have been copied from an earlier revision. It is
+// stored as a new version, like any other update, and audited as a revert of
+// fromVersion.
+func (m MovieDAO) Revert(movie *Movie, fromVersion int32, userID int64) error {
+	return m.update(movie, userID, RevisionRevert, fromVersion)
+}
+
+func (m MovieDAO) update(movie *Movie, userID int64, action string, revertedFrom int32) error {
 	// Declare the SQL query for updating the record and returning the new version
 	// number.
 	query := `
 UPDATE movies
-SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-WHERE id = $5 AND version = $6
+SET title = $1, year = $2, runtime = $3, version = version + 1
+WHERE id = $4 AND version = $5 AND deleted_at IS NULL
 RETURNING version`
 	// Create an args slice containing the values for the placeholder parameters.
 	args := []any{
 		movie.Title,
 		movie.Year,
 		movie.Runtime,
-		pq.Array(movie.Genres),
 		movie.ID,
 		movie.Version,
 	}
@@ -137,7 +279,13 @@ RETURNING version`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -147,62 +295,256 @@ RETURNING version`
 		}
 	}
 
-	return nil
-}
+	if err = setMovieGenres(ctx, tx, movie.ID, movie.Genres); err != nil {
+		return err
+	}
 
-func (m MovieDAO) Delete(id int64) error {
-	if id < 1 {
-		return ErrRecordNotFound
+	if err = recordMovieRevision(ctx, tx, movie.ID, action, userID, revertedFrom); err != nil {
+		return err
 	}
 
+	return tx.Commit()
+}
+
+// Delete moves a movie to the trash by setting deleted_at. The row stays put until the
+// purge job (or HardDelete) removes it, so a mistaken delete can be undone with Restore.
+func (m MovieDAO) Delete(id int64, userID int64) error {
+	query := `
+UPDATE movies
+SET deleted_at = NOW()
+WHERE id = $1 AND deleted_at IS NULL;
+`
+	return m.execWithRevision(query, id, RevisionDelete, userID, false)
+}
+
+// HardDelete removes a movie row straight away, whether or not it is in the trash.
+// Everything hanging off the movie (genres, credits, reviews, list items) cascades.
+func (m MovieDAO) HardDelete(id int64, userID int64) error {
 	query := `
 DELETE FROM movies
 WHERE id = $1;
 `
-	args := []any{id}
+	return m.execWithRevision(query, id, RevisionDelete, userID, true)
+}
+
+// Restore takes a movie back out of the trash.
+func (m MovieDAO) Restore(id int64, userID int64) error {
+	query := `
+UPDATE movies
+SET deleted_at = NULL
+WHERE id = $1 AND deleted_at IS NOT NULL;
+`
+	return m.execWithRevision(query, id, RevisionRestore, userID, false)
+}
+
+// execWithRevision runs a single-row change to a movie and records a revision in the
+// same transaction. The snapshot has to be taken before a hard delete, as there is no
+// row left to read afterwards.
+func (m MovieDAO) execWithRevision(query string, id int64, action string, userID int64, snapshotFirst bool) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	res, err := m.DB.ExecContext(ctx, query, args...)
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	rowsAffecred, err := res.RowsAffected()
+	if snapshotFirst {
+		if err = recordMovieRevision(ctx, tx, id, action, userID, 0); err != nil {
+			return err
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
 
-	if rowsAffecred == 0 {
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
 		return ErrRecordNotFound
 	}
 
-	return nil
+	if !snapshotFirst {
+		if err = recordMovieRevision(ctx, tx, id, action, userID, 0); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
-func (m MovieDAO) GetAll() ([]Movie, error) {
-	query := `
-SELECT id, created_at, title, year, runtime, genres, version 
-FROM movies;
-`
+// PurgeDeleted hard-deletes every movie that went into the trash before the cutoff and
+// returns how many were removed. Like HardDelete, each one gets a delete revision
+// first, which also publishes a movie.deleted event, so subscribers hear about movies
+// leaving for good.
+func (m MovieDAO) PurgeDeleted(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+SELECT id FROM movies
+WHERE deleted_at < $1
+FOR UPDATE`, before)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return 0, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	if err = recordMovieRevisions(ctx, tx, ids, RevisionDelete, 0); err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM movies WHERE id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+
+	purged, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, tx.Commit()
+}
+
+// movieFilterMatch matches the title and genres filters shared by the list, trash and
+// export endpoints. An empty title or genre list matches every movie.
+const movieFilterMatch = `
+WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+AND (` + movieGenresArray + ` @> $2 OR $2 = '{}')`
+
+// movieFilterWhere is movieFilterMatch for movies that aren't in the trash.
+const movieFilterWhere = movieFilterMatch + `
+AND deleted_at IS NULL`
+
+// NonNilStrings swaps a nil slice for an empty one. pq.Array sends nil as NULL, which
+// would never equal '{}' in movieFilterWhere, and JSON encodes it as null rather than
+// an empty list.
+func NonNilStrings(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+
+	return s
+}
+
+// GetAll returns one page of movies matching the title (full text) and genres filters,
+// or all of them if filters.PageSize is zero. Every genre given has to be on a movie
+// for it to match.
+func (m MovieDAO) GetAll(title string, genres []string, filters Filters) ([]Movie, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), id, created_at, title, year, runtime, `+movieGenresColumn+`, version, average_rating, rating_count
+FROM movies`+movieFilterWhere+`
+ORDER BY %s %s, id ASC
+LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	args := []any{title, pq.Array(NonNilStrings(genres)), filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
+	defer rows.Close()
 
-	err = rows.Err()
+	totalRecords := 0
+	movies := []Movie{}
+
+	for rows.Next() {
+		resultMovie := Movie{}
+		err := rows.Scan(
+			&totalRecords,
+			&resultMovie.ID,
+			&resultMovie.CreatedAt,
+			&resultMovie.Title,
+			&resultMovie.Year,
+			&resultMovie.Runtime,
+			pq.Array(&resultMovie.Genres),
+			&resultMovie.Version,
+			&resultMovie.AverageRating,
+			&resultMovie.RatingCount,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, resultMovie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// GetAllDeleted returns one page of the trash, filtered and sorted like GetAll. The
+// filters' safelist may also allow sorting by deleted_at.
+func (m MovieDAO) GetAllDeleted(title string, genres []string, filters Filters) ([]Movie, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), id, created_at, title, year, runtime, `+movieGenresColumn+`, version, average_rating, rating_count, deleted_at
+FROM movies`+movieFilterMatch+`
+AND deleted_at IS NOT NULL
+ORDER BY %s %s, id ASC
+LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{title, pq.Array(NonNilStrings(genres)), filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
+	defer rows.Close()
 
+	totalRecords := 0
 	movies := []Movie{}
+
 	for rows.Next() {
 		resultMovie := Movie{}
-		rows.Scan(
+		err := rows.Scan(
+			&totalRecords,
 			&resultMovie.ID,
 			&resultMovie.CreatedAt,
 			&resultMovie.Title,
@@ -210,9 +552,67 @@ FROM movies;
 			&resultMovie.Runtime,
 			pq.Array(&resultMovie.Genres),
 			&resultMovie.Version,
+			&resultMovie.AverageRating,
+			&resultMovie.RatingCount,
+			&resultMovie.DeletedAt,
 		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
 		movies = append(movies, resultMovie)
 	}
 
-	return movies, nil
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// Export streams every movie matching the same filters as GetAll to fn, one row at a
+// time, in the filters' sort order. Paging is ignored. Rows are read from the result
+// cursor as fn asks for them, so memory use doesn't grow with the catalogue.
+//
+// Unlike the other DAO methods this takes a context and sets no timeout: exports can
+// run for minutes, and cancelling ctx (for example when the client goes away) stops
+// the query on the server.
+func (m MovieDAO) Export(ctx context.Context, title string, genres []string, filters Filters, fn func(*Movie) error) error {
+	query := fmt.Sprintf(`
+SELECT id, created_at, title, year, runtime, `+movieGenresColumn+`, version, average_rating, rating_count
+FROM movies`+movieFilterWhere+`
+ORDER BY %s %s, id ASC`, filters.sortColumn(), filters.sortDirection())
+
+	rows, err := m.DB.QueryContext(ctx, query, title, pq.Array(NonNilStrings(genres)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+		)
+		if err != nil {
+			return err
+		}
+
+		if err = fn(&movie); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
 }
@@ -0,0 +1,412 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+// MaxMovieBatchSize caps how many operations one batch can carry, to keep the
+// transaction (and the row locks it holds) short.
+const MaxMovieBatchSize = 1000
+
+// A MovieBatchOp is one operation in a batch. Creates use the movie fields, updates
+// also need the ID and the version being replaced, and deletes only need the ID.
+type MovieBatchOp struct {
+	Op    string
+	Movie Movie
+}
+
+// Batch applies creates, updates and deletes with one multi-row statement per kind,
+// rather than a round trip per movie. It returns an error for each operation that
+// couldn't be applied (ErrEditConflict for a stale update, ErrRecordNotFound for an
+// update or delete of a missing or trashed movie) and fills in the ID, version and other
+// generated fields of the ones that were. When atomic is true, one failed operation
+// rolls back the whole batch.
+//
+// Operations are grouped by kind, so a batch must not touch the same movie twice.
+func (m MovieDAO) Batch(ops []MovieBatchOp, userID int64, atomic bool) ([]error, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	results := make([]error, len(ops))
+
+	var creates, updates, deletes []int
+	for i, op := range ops {
+		switch op.Op {
+		case BatchCreate:
+			creates = append(creates, i)
+		case BatchUpdate:
+			updates = append(updates, i)
+		case BatchDelete:
+			deletes = append(deletes, i)
+		}
+	}
+
+	if err = batchInsertMovies(ctx, tx, ops, creates, userID); err != nil {
+		return nil, err
+	}
+
+	if err = batchUpdateMovies(ctx, tx, ops, updates, results, userID); err != nil {
+		return nil, err
+	}
+
+	if err = batchDeleteMovies(ctx, tx, ops, deletes, results, userID); err != nil {
+		return nil, err
+	}
+
+	if atomic {
+		for _, result := range results {
+			if result != nil {
+				return results, nil
+			}
+		}
+	}
+
+	return results, tx.Commit()
+}
+
+// GetMany looks up a set of movies by ID in one query. Missing and trashed movies are
+// left out of the map.
+func (m MovieDAO) GetMany(ids []int64) (map[int64]*Movie, error) {
+	movies := make(map[int64]*Movie, len(ids))
+
+	if len(ids) == 0 {
+		return movies, nil
+	}
+
+	query := `
+SELECT id, created_at, title, year, runtime, ` + movieGenresColumn + `, version, average_rating, rating_count
+FROM movies
+WHERE id = ANY($1) AND deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		movies[movie.ID] = &movie
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// batchInsertMovies takes IDs from the sequence up front so every new row can be
+// matched back to its operation, which RETURNING on its own doesn't guarantee.
+func batchInsertMovies(ctx context.Context, tx *sql.Tx, ops []MovieBatchOp, indexes []int, userID int64) error {
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+SELECT nextval(pg_get_serial_sequence('movies', 'id'))
+FROM generate_series(1, $1)`, len(indexes))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0, len(indexes))
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return err
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	titles := make([]string, len(indexes))
+	years := make([]int64, len(indexes))
+	runtimes := make([]int64, len(indexes))
+	byID := make(map[int64]*Movie, len(indexes))
+
+	for n, i := range indexes {
+		movie := &ops[i].Movie
+		movie.ID = ids[n]
+
+		titles[n] = movie.Title
+		years[n] = int64(movie.Year)
+		runtimes[n] = int64(movie.Runtime)
+		byID[movie.ID] = movie
+	}
+
+	query := `
+INSERT INTO movies (id, title, year, runtime)
+SELECT * FROM unnest($1::bigint[], $2::text[], $3::integer[], $4::integer[])
+RETURNING id, created_at, version, average_rating, rating_count`
+
+	err = scanBatchRows(ctx, tx, query, byID, nil, pq.Array(ids), pq.Array(titles), pq.Array(years), pq.Array(runtimes))
+	if err != nil {
+		return err
+	}
+
+	if err = setManyMovieGenres(ctx, tx, byID); err != nil {
+		return err
+	}
+
+	return recordMovieRevisions(ctx, tx, ids, RevisionInsert, userID)
+}
+
+func batchUpdateMovies(ctx context.Context, tx *sql.Tx, ops []MovieBatchOp, indexes []int, results []error, userID int64) error {
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(indexes))
+	versions := make([]int64, len(indexes))
+	titles := make([]string, len(indexes))
+	years := make([]int64, len(indexes))
+	runtimes := make([]int64, len(indexes))
+	byID := make(map[int64]*Movie, len(indexes))
+
+	for n, i := range indexes {
+		movie := &ops[i].Movie
+
+		ids[n] = movie.ID
+		versions[n] = int64(movie.Version)
+		titles[n] = movie.Title
+		years[n] = int64(movie.Year)
+		runtimes[n] = int64(movie.Runtime)
+		byID[movie.ID] = movie
+	}
+
+	query := `
+UPDATE movies
+SET title = u.title, year = u.year, runtime = u.runtime, version = movies.version + 1
+FROM unnest($1::bigint[], $2::integer[], $3::text[], $4::integer[], $5::integer[])
+	AS u(id, version, title, year, runtime)
+WHERE movies.id = u.id AND movies.version = u.version AND movies.deleted_at IS NULL
+RETURNING movies.id, movies.created_at, movies.version, movies.average_rating, movies.rating_count`
+
+	updated := make(map[int64]bool, len(indexes))
+
+	err := scanBatchRows(ctx, tx, query, byID, updated,
+		pq.Array(ids), pq.Array(versions), pq.Array(titles), pq.Array(years), pq.Array(runtimes))
+	if err != nil {
+		return err
+	}
+
+	var updatedIDs, failedIDs []int64
+	for _, i := range indexes {
+		if !updated[ops[i].Movie.ID] {
+			failedIDs = append(failedIDs, ops[i].Movie.ID)
+			delete(byID, ops[i].Movie.ID)
+			continue
+		}
+
+		updatedIDs = append(updatedIDs, ops[i].Movie.ID)
+	}
+
+	// An update that didn't match had either a stale version or no movie to update.
+	if len(failedIDs) > 0 {
+		existing, err := existingMovieIDs(ctx, tx, failedIDs)
+		if err != nil {
+			return err
+		}
+
+		for _, i := range indexes {
+			if id := ops[i].Movie.ID; !updated[id] {
+				results[i] = ErrRecordNotFound
+				if existing[id] {
+					results[i] = ErrEditConflict
+				}
+			}
+		}
+	}
+
+	if len(updatedIDs) == 0 {
+		return nil
+	}
+
+	if err = setManyMovieGenres(ctx, tx, byID); err != nil {
+		return err
+	}
+
+	return recordMovieRevisions(ctx, tx, updatedIDs, RevisionUpdate, userID)
+}
+
+// existingMovieIDs returns which of ids are movies that aren't in the trash.
+func existingMovieIDs(ctx context.Context, tx *sql.Tx, ids []int64) (map[int64]bool, error) {
+	rows, err := tx.QueryContext(ctx, `
+SELECT id
+FROM movies
+WHERE id = ANY($1) AND deleted_at IS NULL`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := make(map[int64]bool, len(ids))
+
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		existing[id] = true
+	}
+
+	return existing, rows.Err()
+}
+
+func batchDeleteMovies(ctx context.Context, tx *sql.Tx, ops []MovieBatchOp, indexes []int, results []error, userID int64) error {
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(indexes))
+	for n, i := range indexes {
+		ids[n] = ops[i].Movie.ID
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+UPDATE movies
+SET deleted_at = NOW()
+WHERE id = ANY($1) AND deleted_at IS NULL
+RETURNING id`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	deleted := make(map[int64]bool, len(indexes))
+	var deletedIDs []int64
+
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return err
+		}
+
+		deleted[id] = true
+		deletedIDs = append(deletedIDs, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, i := range indexes {
+		if !deleted[ops[i].Movie.ID] {
+			results[i] = ErrRecordNotFound
+		}
+	}
+
+	if len(deletedIDs) == 0 {
+		return nil
+	}
+
+	return recordMovieRevisions(ctx, tx, deletedIDs, RevisionDelete, userID)
+}
+
+// scanBatchRows runs a query returning id, created_at, version and the rating columns,
+// and copies them onto the matching movie in byID. If seen isn't nil, the IDs that came
+// back are marked in it.
+func scanBatchRows(ctx context.Context, tx *sql.Tx, query string, byID map[int64]*Movie, seen map[int64]bool, args ...any) error {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id    int64
+			movie Movie
+		)
+
+		err = rows.Scan(&id, &movie.CreatedAt, &movie.Version, &movie.AverageRating, &movie.RatingCount)
+		if err != nil {
+			return err
+		}
+
+		if target, ok := byID[id]; ok {
+			target.CreatedAt = movie.CreatedAt
+			target.Version = movie.Version
+			target.AverageRating = movie.AverageRating
+			target.RatingCount = movie.RatingCount
+		}
+
+		if seen != nil {
+			seen[id] = true
+		}
+	}
+
+	return rows.Err()
+}
+
+// setManyMovieGenres is setMovieGenres for a set of movies at once.
+func setManyMovieGenres(ctx context.Context, tx *sql.Tx, movies map[int64]*Movie) error {
+	var ids, movieIDs, positions []int64
+	var slugs []string
+
+	for id, movie := range movies {
+		ids = append(ids, id)
+
+		for position, slug := range movie.Genres {
+			movieIDs = append(movieIDs, id)
+			slugs = append(slugs, slug)
+			positions = append(positions, int64(position+1))
+		}
+	}
+
+	_, err := tx.ExecContext(ctx, `DELETE FROM movies_genres WHERE movie_id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+
+	query := `
+INSERT INTO movies_genres (movie_id, genre_id, position)
+SELECT g.movie_id, genres.id, g.position
+FROM unnest($1::bigint[], $2::text[], $3::integer[]) AS g(movie_id, slug, position)
+INNER JOIN genres ON genres.slug = g.slug`
+
+	_, err = tx.ExecContext(ctx, query, pq.Array(movieIDs), pq.Array(slugs), pq.Array(positions))
+	return err
+}
@@ -0,0 +1,224 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/captainmango/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+type Person struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	BirthYear int32     `json:"birth_year,omitzero"`
+	Version   int32     `json:"version"`
+}
+
+type PersonDAO struct {
+	DB *sql.DB
+}
+
+func ValidatePerson(v *validator.Validator, person *Person) {
+	v.Check(person.Name != "", "name", "must be provided")
+	v.Check(len(person.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	// Birth year is optional, but if we have one it should be believable.
+	if person.BirthYear != 0 {
+		v.Check(person.BirthYear >= 1800, "birth_year", "must be greater than 1800")
+		v.Check(person.BirthYear <= int32(time.Now().Year()), "birth_year", "must not be in the future")
+	}
+}
+
+func (m PersonDAO) Insert(person *Person) error {
+	query := `
+INSERT INTO people (name, birth_year)
+VALUES ($1, $2)
+RETURNING id, created_at, version`
+
+	args := []any{person.Name, nullInt32(person.BirthYear)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&person.ID, &person.CreatedAt, &person.Version)
+}
+
+func (m PersonDAO) Get(id int64) (*Person, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+SELECT id, created_at, name, COALESCE(birth_year, 0), version
+FROM people
+WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var person Person
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&person.ID,
+		&person.CreatedAt,
+		&person.Name,
+		&person.BirthYear,
+		&person.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &person, nil
+}
+
+// GetMany loads a set of people in a single query, keyed by ID.
+func (m PersonDAO) GetMany(ids []int64) (map[int64]*Person, error) {
+	query := `
+SELECT id, created_at, name, COALESCE(birth_year, 0), version
+FROM people
+WHERE id = ANY($1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	people := make(map[int64]*Person, len(ids))
+
+	for rows.Next() {
+		var person Person
+
+		err := rows.Scan(
+			&person.ID,
+			&person.CreatedAt,
+			&person.Name,
+			&person.BirthYear,
+			&person.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		people[person.ID] = &person
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return people, nil
+}
+
+func (m PersonDAO) GetAll() ([]Person, error) {
+	query := `
+SELECT id, created_at, name, COALESCE(birth_year, 0), version
+FROM people
+ORDER BY name, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	people := []Person{}
+	for rows.Next() {
+		var person Person
+
+		err := rows.Scan(
+			&person.ID,
+			&person.CreatedAt,
+			&person.Name,
+			&person.BirthYear,
+			&person.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		people = append(people, person)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return people, nil
+}
+
+func (m PersonDAO) Update(person *Person) error {
+	query := `
+UPDATE people
+SET name = $1, birth_year = $2, version = version + 1
+WHERE id = $3 AND version = $4
+RETURNING version`
+
+	args := []any{person.Name, nullInt32(person.BirthYear), person.ID, person.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&person.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m PersonDAO) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+DELETE FROM people
+WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// nullInt32 stores the zero value as NULL for optional integer columns.
+func nullInt32(i int32) sql.NullInt32 {
+	return sql.NullInt32{Int32: i, Valid: i != 0}
+}
@@ -0,0 +1,381 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/captainmango/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+var ErrDuplicateReview = errors.New("duplicate review")
+
+type Review struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	MovieID      int64     `json:"movie_id"`
+	UserID       int64     `json:"user_id"`
+	UserName     string    `json:"user_name,omitempty"`
+	Rating       int32     `json:"rating"`
+	Body         string    `json:"body"`
+	HelpfulCount int32     `json:"helpful_count"`
+	Version      int32     `json:"version"`
+}
+
+type ReviewDAO struct {
+	DB *sql.DB
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Rating >= 1, "rating", "must be between 1 and 10")
+	v.Check(review.Rating <= 10, "rating", "must be between 1 and 10")
+
+	v.Check(len(review.Body) <= 10_000, "body", "must not be more than 10000 bytes long")
+}
+
+// adjustMovieRating keeps the denormalised rating columns on movies in step with the
+// reviews table. It has to run in the same transaction as the review change, and the
+// UPDATE takes a row lock so concurrent reviews of one movie can't lose an increment.
+func adjustMovieRating(ctx context.Context, tx *sql.Tx, movieID int64, countDelta, sumDelta int32) error {
+	query := `
+UPDATE movies
+SET rating_count = rating_count + $2,
+	rating_sum = rating_sum + $3,
+	average_rating = COALESCE(round((rating_sum + $3)::numeric / NULLIF(rating_count + $2, 0), 2), 0)
+WHERE id = $1`
+
+	_, err := tx.ExecContext(ctx, query, movieID, countDelta, sumDelta)
+	return err
+}
+
+// Insert adds a review and folds its rating into the movie aggregates. Each user can
+// only review a movie once, which is enforced by a unique constraint.
+func (m ReviewDAO) Insert(review *Review) error {
+	query := `
+INSERT INTO reviews (movie_id, user_id, rating, body)
+SELECT id, $2, $3, $4
+FROM movies
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING id, created_at, updated_at, helpful_count, version`
+
+	args := []any{review.MovieID, review.UserID, review.Rating, review.Body}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+		&review.HelpfulCount,
+		&review.Version,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) {
+			switch pqErr.Code.Name() {
+			case "foreign_key_violation":
+				return ErrRecordNotFound
+			case "unique_violation":
+				return ErrDuplicateReview
+			}
+		}
+
+		return err
+	}
+
+	if err = adjustMovieRating(ctx, tx, review.MovieID, 1, review.Rating); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m ReviewDAO) Get(movieID, reviewID int64) (*Review, error) {
+	if movieID < 1 || reviewID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+SELECT reviews.id, reviews.created_at, reviews.updated_at, reviews.movie_id, reviews.user_id,
+	users.name, reviews.rating, reviews.body, reviews.helpful_count, reviews.version
+FROM reviews
+INNER JOIN users ON users.id = reviews.user_id
+WHERE reviews.id = $1 AND reviews.movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var review Review
+
+	err := m.DB.QueryRowContext(ctx, query, reviewID, movieID).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+		&review.MovieID,
+		&review.UserID,
+		&review.UserName,
+		&review.Rating,
+		&review.Body,
+		&review.HelpfulCount,
+		&review.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+// GetAllForMovie returns one page of reviews for a movie. The sort column comes from
+// the filters safelist, with the id as a tie-breaker so paging is stable.
+func (m ReviewDAO) GetAllForMovie(movieID int64, filters Filters) ([]Review, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), reviews.id, reviews.created_at, reviews.updated_at, reviews.movie_id,
+	reviews.user_id, users.name, reviews.rating, reviews.body, reviews.helpful_count, reviews.version
+FROM reviews
+INNER JOIN users ON users.id = reviews.user_id
+WHERE reviews.movie_id = $1
+ORDER BY reviews.%s %s, reviews.id ASC
+LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []Review{}
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+			&review.MovieID,
+			&review.UserID,
+			&review.UserName,
+			&review.Rating,
+			&review.Body,
+			&review.HelpfulCount,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return reviews, metadata, nil
+}
+
+// GetLatestForMovies loads up to limit of the newest reviews for each of a set of
+// movies in a single query, keyed by movie ID.
+func (m ReviewDAO) GetLatestForMovies(movieIDs []int64, limit int) (map[int64][]Review, error) {
+	query := `
+SELECT id, created_at, updated_at, movie_id, user_id, name, rating, body, helpful_count, version
+FROM (
+	SELECT reviews.id, reviews.created_at, reviews.updated_at, reviews.movie_id, reviews.user_id,
+		users.name, reviews.rating, reviews.body, reviews.helpful_count, reviews.version,
+		row_number() OVER (PARTITION BY reviews.movie_id ORDER BY reviews.created_at DESC, reviews.id DESC) AS n
+	FROM reviews
+	INNER JOIN users ON users.id = reviews.user_id
+	WHERE reviews.movie_id = ANY($1)
+) AS latest
+WHERE n <= $2
+ORDER BY movie_id, n`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := make(map[int64][]Review, len(movieIDs))
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(
+			&review.ID,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+			&review.MovieID,
+			&review.UserID,
+			&review.UserName,
+			&review.Rating,
+			&review.Body,
+			&review.HelpfulCount,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		reviews[review.MovieID] = append(reviews[review.MovieID], review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reviews, nil
+}
+
+// Update saves a new rating and body using the version for optimistic locking. The old
+// rating is read under a row lock so the movie aggregate can be corrected by the
+// difference.
+func (m ReviewDAO) Update(review *Review) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldRating int32
+
+	err = tx.QueryRowContext(ctx, `
+SELECT rating FROM reviews
+WHERE id = $1 AND version = $2
+FOR UPDATE`, review.ID, review.Version).Scan(&oldRating)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	query := `
+UPDATE reviews
+SET rating = $1, body = $2, updated_at = NOW(), version = version + 1
+WHERE id = $3
+RETURNING updated_at, version`
+
+	err = tx.QueryRowContext(ctx, query, review.Rating, review.Body, review.ID).Scan(&review.UpdatedAt, &review.Version)
+	if err != nil {
+		return err
+	}
+
+	if err = adjustMovieRating(ctx, tx, review.MovieID, 0, review.Rating-oldRating); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a review as long as it is still at the version the caller last saw,
+// and takes its rating back out of the movie aggregate.
+func (m ReviewDAO) Delete(review *Review) error {
+	query := `
+DELETE FROM reviews
+WHERE id = $1 AND version = $2
+RETURNING rating`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var rating int32
+
+	err = tx.QueryRowContext(ctx, query, review.ID, review.Version).Scan(&rating)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if err = adjustMovieRating(ctx, tx, review.MovieID, -1, -rating); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AddHelpfulVote records that a user found a review helpful. Voting twice is a no-op,
+// so the count only moves when a new vote row is written.
+func (m ReviewDAO) AddHelpfulVote(review *Review, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+INSERT INTO review_votes (review_id, user_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING`, review.ID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected > 0 {
+		err = tx.QueryRowContext(ctx, `
+UPDATE reviews
+SET helpful_count = helpful_count + 1
+WHERE id = $1
+RETURNING helpful_count`, review.ID).Scan(&review.HelpfulCount)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,236 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	RevisionInsert  = "insert"
+	RevisionUpdate  = "update"
+	RevisionDelete  = "delete"
+	RevisionRestore = "restore"
+	RevisionRevert  = "revert"
+)
+
+// A MovieRevision is a snapshot of a movie's editable fields, taken in the same
+// transaction as the change that produced it.
+type MovieRevision struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	MovieID   int64     `json:"movie_id"`
+	Version   int32     `json:"version"`
+	Action    string    `json:"action"`
+	UserID    *int64    `json:"user_id"`
+	// RevertedFrom is the version that was restored, for revert revisions only.
+	RevertedFrom *int32    `json:"reverted_from,omitempty"`
+	Movie        MovieJSON `json:"movie"`
+}
+
+// FieldChange describes how one field differs between two revisions. Added and Removed
+// are only filled in for list fields like genres.
+type FieldChange struct {
+	From    any      `json:"from"`
+	To      any      `json:"to"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+type MovieRevisionDAO struct {
+	DB *sql.DB
+}
+
+// movieSnapshot builds the JSON snapshot of a movies row stored with each revision.
+const movieSnapshot = `jsonb_build_object(
+	'title', title,
+	'year', year,
+	'runtime', format('%s mins', runtime),
+	'genres', ` + movieGenresArray + `
+)`
+
+// recordMovieRevision snapshots the movie row as it is inside tx. The snapshot is built
+// in SQL so deletes and restores, which don't load the movie, are handled the same way
+// as inserts and updates. The runtime is stored in the same "<n> mins" form as the API.
+// revertedFrom is only set for reverts; pass zero otherwise. The matching movie event
+// is written to the webhook outbox at the same time.
+func recordMovieRevision(ctx context.Context, tx *sql.Tx, movieID int64, action string, userID int64, revertedFrom int32) error {
+	query := `
+INSERT INTO movie_revisions (movie_id, version, action, user_id, reverted_from, snapshot)
+SELECT id, version, $2, $3, $4, ` + movieSnapshot + `
+FROM movies
+WHERE id = $1`
+
+	args := []any{
+		movieID,
+		action,
+		sql.NullInt64{Int64: userID, Valid: userID > 0},
+		nullInt32(revertedFrom),
+	}
+
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+
+	return recordMovieEvents(ctx, tx, []int64{movieID}, action)
+}
+
+// recordMovieRevisions is recordMovieRevision for a set of movies changed by a batch.
+func recordMovieRevisions(ctx context.Context, tx *sql.Tx, movieIDs []int64, action string, userID int64) error {
+	query := `
+INSERT INTO movie_revisions (movie_id, version, action, user_id, snapshot)
+SELECT id, version, $2, $3, ` + movieSnapshot + `
+FROM movies
+WHERE id = ANY($1)`
+
+	_, err := tx.ExecContext(ctx, query, pq.Array(movieIDs), action, sql.NullInt64{Int64: userID, Valid: userID > 0})
+	if err != nil {
+		return err
+	}
+
+	return recordMovieEvents(ctx, tx, movieIDs, action)
+}
+
+// GetAllForMovie returns every revision of a movie, newest first. Revisions are kept
+// after a movie is deleted, so this doesn't check the movie still exists.
+func (m MovieRevisionDAO) GetAllForMovie(movieID int64) ([]MovieRevision, error) {
+	query := `
+SELECT id, created_at, movie_id, version, action, user_id, reverted_from, snapshot
+FROM movie_revisions
+WHERE movie_id = $1
+ORDER BY id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []MovieRevision{}
+	for rows.Next() {
+		revision, err := scanMovieRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		revisions = append(revisions, *revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// Get returns the revision that produced a particular version of a movie.
+func (m MovieRevisionDAO) Get(movieID int64, version int32) (*MovieRevision, error) {
+	if movieID < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+SELECT id, created_at, movie_id, version, action, user_id, reverted_from, snapshot
+FROM movie_revisions
+WHERE movie_id = $1 AND version = $2 AND action IN ('insert', 'update', 'revert')`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	revision, err := scanMovieRevision(m.DB.QueryRowContext(ctx, query, movieID, version))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return revision, nil
+}
+
+// scanMovieRevision works for both *sql.Row and *sql.Rows.
+func scanMovieRevision(row interface{ Scan(...any) error }) (*MovieRevision, error) {
+	var (
+		revision     MovieRevision
+		userID       sql.NullInt64
+		revertedFrom sql.NullInt32
+		snapshot     []byte
+	)
+
+	err := row.Scan(
+		&revision.ID,
+		&revision.CreatedAt,
+		&revision.MovieID,
+		&revision.Version,
+		&revision.Action,
+		&userID,
+		&revertedFrom,
+		&snapshot,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if userID.Valid {
+		revision.UserID = &userID.Int64
+	}
+
+	if revertedFrom.Valid {
+		revision.RevertedFrom = &revertedFrom.Int32
+	}
+
+	err = json.Unmarshal(snapshot, &revision.Movie)
+	if err != nil {
+		return nil, err
+	}
+
+	return &revision, nil
+}
+
+// DiffMovieRevisions lists the fields that changed between two snapshots, keyed by
+// their JSON names. Fields that are the same in both are left out.
+func DiffMovieRevisions(from, to MovieJSON) map[string]FieldChange {
+	changes := make(map[string]FieldChange)
+
+	if from.Title != to.Title {
+		changes["title"] = FieldChange{From: from.Title, To: to.Title}
+	}
+
+	if from.Year != to.Year {
+		changes["year"] = FieldChange{From: from.Year, To: to.Year}
+	}
+
+	if from.Runtime != to.Runtime {
+		changes["runtime"] = FieldChange{From: from.Runtime, To: to.Runtime}
+	}
+
+	if !slices.Equal(from.Genres, to.Genres) {
+		change := FieldChange{From: from.Genres, To: to.Genres}
+
+		for _, genre := range to.Genres {
+			if !slices.Contains(from.Genres, genre) {
+				change.Added = append(change.Added, genre)
+			}
+		}
+
+		for _, genre := range from.Genres {
+			if !slices.Contains(to.Genres, genre) {
+				change.Removed = append(change.Removed, genre)
+			}
+		}
+
+		changes["genres"] = change
+	}
+
+	return changes
+}
@@ -0,0 +1,569 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/captainmango/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryRetrying  = "retrying"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// A Webhook subscribes a URL to some of the movie events. The secret signs every
+// delivery, so it's only ever shown when it is set.
+type Webhook struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    int64     `json:"-"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	Version   int32     `json:"version"`
+}
+
+// A WebhookDelivery is one event sent (or being sent) to a webhook. The response
+// fields describe the latest attempt.
+type WebhookDelivery struct {
+	ID             int64      `json:"id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	WebhookID      int64      `json:"webhook_id"`
+	RedeliveryOf   *int64     `json:"redelivery_of,omitempty"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	ResponseStatus *int       `json:"response_status"`
+	ResponseBody   string     `json:"response_body,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	DurationMs     int        `json:"duration_ms"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	Event          MovieEvent `json:"event"`
+}
+
+type WebhookDAO struct {
+	DB *sql.DB
+}
+
+// GenerateWebhookSecret returns a random signing secret for webhooks created without
+// one.
+func GenerateWebhookSecret() string {
+	return "whsec_" + rand.Text()
+}
+
+// nonPublicPrefixes are the special-purpose ranges that netip doesn't have a method
+// for: "this network", carrier-grade NAT, IETF protocol assignments and benchmarking.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+}
+
+// IsPublicAddress reports whether webhooks may be delivered to ip. Loopback, private,
+// link-local, multicast and unspecified addresses are all refused, as they would let a
+// webhook reach services on the API's own network.
+func IsPublicAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	v.Check(webhook.URL != "", "url", "must be provided")
+	v.Check(len(webhook.URL) <= 2000, "url", "must not be more than 2000 bytes long")
+
+	if u, err := url.Parse(webhook.URL); err != nil || !validator.PermittedValue(u.Scheme, "http", "https") || u.Host == "" {
+		v.AddError("url", "must be an absolute http or https URL")
+	} else {
+		// Names are checked again when a delivery connects, since they can resolve
+		// anywhere. This just turns away the URLs that are obviously local.
+		host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+		ip, err := netip.ParseAddr(host)
+
+		local := host == "localhost" || strings.HasSuffix(host, ".localhost")
+		v.Check(!local && (err != nil || IsPublicAddress(ip)), "url", "must not point to a private or local address")
+	}
+
+	v.Check(len(webhook.Secret) >= 16, "secret", "must be at least 16 bytes long")
+	v.Check(len(webhook.Secret) <= 200, "secret", "must not be more than 200 bytes long")
+
+	v.Check(len(webhook.Events) > 0, "events", "must contain at least 1 event")
+	v.Check(validator.Unique(webhook.Events), "events", "must not contain duplicate values")
+
+	for _, event := range webhook.Events {
+		if !validator.PermittedValue(event, MovieEvents...) {
+			v.AddError("events", fmt.Sprintf("must only contain permitted values: %s", strings.Join(MovieEvents, ", ")))
+			break
+		}
+	}
+}
+
+func (m WebhookDAO) Insert(webhook *Webhook) error {
+	query := `
+INSERT INTO webhooks (user_id, url, secret, events, active)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, created_at, version`
+
+	args := []any{webhook.UserID, webhook.URL, webhook.Secret, pq.Array(webhook.Events), webhook.Active}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.ID, &webhook.CreatedAt, &webhook.Version)
+}
+
+func (m WebhookDAO) Get(id int64) (*Webhook, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+SELECT id, created_at, user_id, url, secret, events, active, version
+FROM webhooks
+WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var webhook Webhook
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&webhook.ID,
+		&webhook.CreatedAt,
+		&webhook.UserID,
+		&webhook.URL,
+		&webhook.Secret,
+		pq.Array(&webhook.Events),
+		&webhook.Active,
+		&webhook.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &webhook, nil
+}
+
+func (m WebhookDAO) GetAllForUser(userID int64) ([]Webhook, error) {
+	query := `
+SELECT id, created_at, user_id, url, events, active, version
+FROM webhooks
+WHERE user_id = $1
+ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+
+	for rows.Next() {
+		var webhook Webhook
+
+		err := rows.Scan(
+			&webhook.ID,
+			&webhook.CreatedAt,
+			&webhook.UserID,
+			&webhook.URL,
+			pq.Array(&webhook.Events),
+			&webhook.Active,
+			&webhook.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		webhooks = append(webhooks, webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (m WebhookDAO) Update(webhook *Webhook) error {
+	query := `
+UPDATE webhooks
+SET url = $1, secret = $2, events = $3, active = $4, version = version + 1
+WHERE id = $5 AND version = $6
+RETURNING version`
+
+	args := []any{webhook.URL, webhook.Secret, pq.Array(webhook.Events), webhook.Active, webhook.ID, webhook.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes a webhook along with its delivery log. Deliveries still waiting on a
+// retry find the webhook gone and give up.
+func (m WebhookDAO) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+const deliveryColumns = `webhook_deliveries.id, webhook_deliveries.created_at,
+	webhook_deliveries.updated_at, webhook_deliveries.webhook_id, webhook_deliveries.redelivery_of,
+	webhook_deliveries.status, webhook_deliveries.attempts, webhook_deliveries.response_status,
+	webhook_deliveries.response_body, webhook_deliveries.error, webhook_deliveries.duration_ms,
+	webhook_deliveries.delivered_at, movie_events.id, movie_events.created_at, movie_events.event,
+	movie_events.movie_id, movie_events.payload`
+
+// scanDelivery reads the deliveryColumns. Any extra destinations are scanned first.
+func scanDelivery(row interface{ Scan(...any) error }, extra ...any) (*WebhookDelivery, error) {
+	var (
+		delivery       WebhookDelivery
+		redeliveryOf   sql.NullInt64
+		responseStatus sql.NullInt32
+		deliveredAt    sql.NullTime
+		payload        []byte
+	)
+
+	dest := append(extra,
+		&delivery.ID,
+		&delivery.CreatedAt,
+		&delivery.UpdatedAt,
+		&delivery.WebhookID,
+		&redeliveryOf,
+		&delivery.Status,
+		&delivery.Attempts,
+		&responseStatus,
+		&delivery.ResponseBody,
+		&delivery.Error,
+		&delivery.DurationMs,
+		&deliveredAt,
+		&delivery.Event.ID,
+		&delivery.Event.CreatedAt,
+		&delivery.Event.Event,
+		&delivery.Event.MovieID,
+		&payload,
+	)
+
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	delivery.Event.Movie = payload
+
+	if redeliveryOf.Valid {
+		delivery.RedeliveryOf = &redeliveryOf.Int64
+	}
+
+	if responseStatus.Valid {
+		status := int(responseStatus.Int32)
+		delivery.ResponseStatus = &status
+	}
+
+	if deliveredAt.Valid {
+		delivery.DeliveredAt = &deliveredAt.Time
+	}
+
+	return &delivery, nil
+}
+
+func (m WebhookDAO) GetDelivery(id int64) (*WebhookDelivery, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+SELECT ` + deliveryColumns + `
+FROM webhook_deliveries
+INNER JOIN movie_events ON movie_events.id = webhook_deliveries.event_id
+WHERE webhook_deliveries.id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	delivery, err := scanDelivery(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return delivery, nil
+}
+
+// GetDeliveries is the delivery log for a webhook, newest first. An empty status
+// returns deliveries in any state.
+func (m WebhookDAO) GetDeliveries(webhookID int64, status string, filters Filters) ([]WebhookDelivery, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), `+deliveryColumns+`
+FROM webhook_deliveries
+INNER JOIN movie_events ON movie_events.id = webhook_deliveries.event_id
+WHERE webhook_deliveries.webhook_id = $1 AND (webhook_deliveries.status = $2 OR $2 = '')
+ORDER BY webhook_deliveries.%s %s, webhook_deliveries.id DESC
+LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, webhookID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	deliveries := []WebhookDelivery{}
+
+	for rows.Next() {
+		delivery, err := scanDelivery(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		deliveries = append(deliveries, *delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return deliveries, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// RecordAttempt saves the outcome of an attempt at a delivery, counting it towards the
+// delivery's attempts.
+func (m WebhookDAO) RecordAttempt(delivery *WebhookDelivery) error {
+	query := `
+UPDATE webhook_deliveries
+SET status = $2, attempts = attempts + 1, response_status = $3, response_body = $4, error = $5,
+	duration_ms = $6, delivered_at = CASE WHEN $2 = 'succeeded' THEN NOW() END, updated_at = NOW()
+WHERE id = $1
+RETURNING attempts, updated_at, delivered_at`
+
+	var responseStatus sql.NullInt32
+	if delivery.ResponseStatus != nil {
+		responseStatus = sql.NullInt32{Int32: int32(*delivery.ResponseStatus), Valid: true}
+	}
+
+	args := []any{
+		delivery.ID,
+		delivery.Status,
+		responseStatus,
+		delivery.ResponseBody,
+		delivery.Error,
+		delivery.DurationMs,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var deliveredAt sql.NullTime
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&delivery.Attempts, &delivery.UpdatedAt, &deliveredAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if deliveredAt.Valid {
+		delivery.DeliveredAt = &deliveredAt.Time
+	}
+
+	return nil
+}
+
+// Dispatch takes up to limit events off the outbox and creates a delivery for each
+// active webhook subscribed to them. enqueue is called with every new delivery inside
+// the same transaction, so a delivery can't be created without whatever will send it.
+// It returns the number of deliveries created.
+func (m WebhookDAO) Dispatch(limit int, enqueue func(ctx context.Context, tx *sql.Tx, deliveryID int64) error) (int, error) {
+	query := `
+WITH events AS (
+	SELECT id, event
+	FROM movie_events
+	WHERE dispatched_at IS NULL
+	ORDER BY id
+	LIMIT $1
+	FOR UPDATE SKIP LOCKED
+), dispatched AS (
+	UPDATE movie_events
+	SET dispatched_at = NOW()
+	FROM events
+	WHERE movie_events.id = events.id
+)
+INSERT INTO webhook_deliveries (webhook_id, event_id)
+SELECT webhooks.id, events.id
+FROM events
+INNER JOIN webhooks ON webhooks.active AND events.event = ANY(webhooks.events)
+ORDER BY events.id, webhooks.id
+RETURNING id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return 0, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		if err = enqueue(ctx, tx, id); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(ids), tx.Commit()
+}
+
+// Redeliver sends the event from an earlier delivery to its webhook again, as a new
+// delivery. enqueue is called inside the transaction, like Dispatch.
+func (m WebhookDAO) Redeliver(webhookID, deliveryID int64, enqueue func(ctx context.Context, tx *sql.Tx, deliveryID int64) error) (*WebhookDelivery, error) {
+	query := `
+WITH redelivery AS (
+	INSERT INTO webhook_deliveries (webhook_id, event_id, redelivery_of)
+	SELECT webhook_id, event_id, id
+	FROM webhook_deliveries
+	WHERE id = $1 AND webhook_id = $2
+	RETURNING *
+)
+SELECT ` + strings.ReplaceAll(deliveryColumns, "webhook_deliveries.", "redelivery.") + `
+FROM redelivery
+INNER JOIN movie_events ON movie_events.id = redelivery.event_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	delivery, err := scanDelivery(tx.QueryRowContext(ctx, query, deliveryID, webhookID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if err = enqueue(ctx, tx, delivery.ID); err != nil {
+		return nil, err
+	}
+
+	return delivery, tx.Commit()
+}
+
+// PurgeEvents deletes dispatched events older than the cutoff, and the deliveries of
+// them along with it. The log's horizon is moved up past them in the same statement,
+// so event streams know history before it is gone.
+func (m WebhookDAO) PurgeEvents(before time.Time) (int64, error) {
+	query := `
+WITH purged AS (
+	DELETE FROM movie_events
+	WHERE created_at < $1 AND dispatched_at IS NOT NULL
+	RETURNING id
+), horizon AS (
+	UPDATE movie_events_horizon
+	SET purged_through = GREATEST(purged_through, (SELECT max(id) FROM purged))
+)
+SELECT count(*) FROM purged`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var purged int64
+
+	err := m.DB.QueryRowContext(ctx, query, before).Scan(&purged)
+	return purged, err
+}
@@ -0,0 +1,73 @@
+// Package dataloader batches lookups made while resolving a GraphQL query. Resolvers
+// ask for one key at a time and get back a thunk; calling a thunk whose key hasn't been
+// fetched yet fetches every key asked for so far in one go. Loaders are meant to live
+// for one request, so results are cached for its lifetime and never go stale.
+package dataloader
+
+import "sync"
+
+// A BatchFunc fetches the values for a set of keys. Keys missing from the map get the
+// zero value.
+type BatchFunc[K comparable, V any] func(keys []K) (map[K]V, error)
+
+type Loader[K comparable, V any] struct {
+	fetch BatchFunc[K, V]
+
+	mu      sync.Mutex
+	pending []K
+	queued  map[K]bool
+	results map[K]V
+	errors  map[K]error
+}
+
+func New[K comparable, V any](fetch BatchFunc[K, V]) *Loader[K, V] {
+	return &Loader[K, V]{
+		fetch:   fetch,
+		queued:  make(map[K]bool),
+		results: make(map[K]V),
+		errors:  make(map[K]error),
+	}
+}
+
+// Load queues key for the next batch and returns a thunk that returns its value.
+func (l *Loader[K, V]) Load(key K) func() (V, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.queued[key] {
+		l.queued[key] = true
+		l.pending = append(l.pending, key)
+	}
+
+	return func() (V, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		// Keys fetched by an earlier batch are answered straight away, leaving the
+		// keys queued since then to build up into the next batch.
+		_, fetched := l.results[key]
+		if _, failed := l.errors[key]; !fetched && !failed {
+			l.dispatch()
+		}
+
+		return l.results[key], l.errors[key]
+	}
+}
+
+// dispatch fetches every pending key. An error is recorded against each key in the
+// batch, so only the fields that needed it fail.
+func (l *Loader[K, V]) dispatch() {
+	keys := l.pending
+	l.pending = nil
+
+	results, err := l.fetch(keys)
+
+	for _, key := range keys {
+		if err != nil {
+			l.errors[key] = err
+			continue
+		}
+
+		l.results[key] = results[key]
+	}
+}
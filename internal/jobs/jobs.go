@@ -0,0 +1,528 @@
+// Package jobs is a small job queue stored in Postgres. Jobs are enqueued with a kind
+// and a JSON payload, and workers claim them with SELECT ... FOR UPDATE SKIP LOCKED so
+// any number of processes can drain the same table without stepping on each other.
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusDead      = "dead"
+)
+
+const (
+	// defaultMaxAttempts is used when Enqueue isn't given a limit.
+	defaultMaxAttempts = 5
+
+	// jobTimeout is the longest a single attempt can run for.
+	jobTimeout = 30 * time.Minute
+
+	// lease is how long a running job can go without a heartbeat before another
+	// worker assumes its worker died and runs it again.
+	lease = 5 * time.Minute
+
+	// shutdownGrace is how long running jobs get to finish once Run is told to stop,
+	// after which their context is cancelled and they are put back on the queue.
+	shutdownGrace = 20 * time.Second
+)
+
+var ErrNotFound = errors.New("job not found")
+
+// ErrLeaseLost is returned when a worker updates a job it no longer holds, because its
+// lease ran out and another worker claimed the job (or the job was reaped).
+var ErrLeaseLost = errors.New("job lease lost")
+
+type Job struct {
+	ID          int64           `json:"id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	Kind        string          `json:"kind"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	Progress    int             `json:"progress"`
+	LastError   string          `json:"last_error,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	UserID      *int64          `json:"-"`
+	Payload     json.RawMessage `json:"-"`
+	// Input is only loaded for a job that has been claimed by a worker.
+	Input []byte `json:"-"`
+
+	queue *Queue
+
+	// mu guards lease, the locked_at this worker's claim last set. Every update a
+	// worker makes is conditional on it, so a worker whose lease ran out can't
+	// overwrite the job once another worker has claimed it.
+	mu    sync.Mutex
+	lease time.Time
+}
+
+// SetProgress records how far through a running job is, as a percentage. It also
+// counts as a heartbeat, so long jobs should call it regularly.
+func (j *Job) SetProgress(percent int) error {
+	percent = max(0, min(percent, 100))
+
+	err := j.renewLease(`progress = $3,`, percent)
+	if err != nil {
+		return err
+	}
+
+	j.Progress = percent
+	return nil
+}
+
+// renewLease moves the job's lease on, setting any other columns given as well. The
+// extra arguments start at $3.
+func (j *Job) renewLease(set string, args ...any) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+UPDATE jobs
+SET ` + set + ` locked_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND status = 'running' AND locked_at = $2
+RETURNING locked_at`
+
+	err := j.queue.db.QueryRowContext(ctx, query, append([]any{j.ID, j.lease}, args...)...).Scan(&j.lease)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrLeaseLost
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// finish makes the update that ends this worker's attempt at the job. The extra
+// arguments start at $3.
+func (j *Job) finish(set string, args ...any) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+UPDATE jobs
+SET ` + set + `, locked_at = NULL, updated_at = NOW()
+WHERE id = $1 AND status = 'running' AND locked_at = $2`
+
+	result, err := j.queue.db.ExecContext(ctx, query, append([]any{j.ID, j.lease}, args...)...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrLeaseLost
+	}
+
+	return nil
+}
+
+// EnqueueOptions are the optional settings for a new job. The zero value runs the job
+// as soon as possible with the default number of attempts.
+type EnqueueOptions struct {
+	RunAt       time.Time
+	MaxAttempts int
+	UserID      int64
+	Input       []byte
+}
+
+// HandlerFunc runs one attempt at a job. Whatever it returns on success is stored as
+// the job's result. Returning an error retries the job with backoff, unless it's
+// wrapped with Permanent.
+type HandlerFunc func(ctx context.Context, job *Job) (any, error)
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks an error that retrying won't fix, like a malformed payload, so the
+// job goes straight to the dead state.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+type Queue struct {
+	db       *sql.DB
+	logger   *slog.Logger
+	handlers map[string]HandlerFunc
+}
+
+func New(db *sql.DB, logger *slog.Logger) *Queue {
+	return &Queue{
+		db:       db,
+		logger:   logger,
+		handlers: make(map[string]HandlerFunc),
+	}
+}
+
+// Handle registers the handler for a kind of job, decoding the payload into T first.
+// A payload that doesn't decode fails the job permanently. Handlers must be registered
+// before Run is called.
+func Handle[T any](q *Queue, kind string, fn func(ctx context.Context, job *Job, payload T) (any, error)) {
+	q.handlers[kind] = func(ctx context.Context, job *Job) (any, error) {
+		var payload T
+
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return nil, Permanent(fmt.Errorf("decoding payload: %w", err))
+		}
+
+		return fn(ctx, job, payload)
+	}
+}
+
+// Enqueue adds a job. The payload is stored as JSON and handed back to the handler
+// registered for the kind.
+func (q *Queue) Enqueue(kind string, payload any, opts EnqueueOptions) (*Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return q.enqueue(ctx, q.db, kind, payload, opts)
+}
+
+// EnqueueTx adds a job as part of tx, so it only becomes visible to workers if the
+// rest of the transaction commits.
+func (q *Queue) EnqueueTx(ctx context.Context, tx *sql.Tx, kind string, payload any, opts EnqueueOptions) (*Job, error) {
+	return q.enqueue(ctx, tx, kind, payload, opts)
+}
+
+func (q *Queue) enqueue(ctx context.Context, db interface {
+	QueryRowContext(context.Context, string, ...any) *sql.Row
+}, kind string, payload any, opts EnqueueOptions) (*Job, error) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+
+	if opts.RunAt.IsZero() {
+		opts.RunAt = time.Now()
+	}
+
+	query := `
+INSERT INTO jobs (kind, payload, input, max_attempts, run_at, user_id)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING ` + jobColumns
+
+	args := []any{
+		kind,
+		payloadJSON,
+		opts.Input,
+		opts.MaxAttempts,
+		opts.RunAt,
+		sql.NullInt64{Int64: opts.UserID, Valid: opts.UserID > 0},
+	}
+
+	return q.scan(db.QueryRowContext(ctx, query, args...))
+}
+
+func (q *Queue) Get(id int64) (*Job, error) {
+	if id < 1 {
+		return nil, ErrNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	job, err := q.scan(q.db.QueryRowContext(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return job, nil
+}
+
+// Run starts workers that claim and run jobs until ctx is cancelled. Only kinds with a
+// registered handler are claimed, so different processes can handle different kinds.
+// It returns once every job that was running has finished or been put back.
+func (q *Queue) Run(ctx context.Context, workers int, pollInterval time.Duration) {
+	var wg sync.WaitGroup
+
+	for range workers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			q.work(ctx, pollInterval)
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context, pollInterval time.Duration) {
+	for ctx.Err() == nil {
+		job, err := q.claim()
+		if err != nil {
+			q.logger.Error(err.Error(), "component", "jobs")
+		}
+
+		if job == nil {
+			if err = q.reap(); err != nil {
+				q.logger.Error(err.Error(), "component", "jobs")
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+
+		q.execute(ctx, job)
+	}
+}
+
+const jobColumns = `id, created_at, updated_at, kind, status, attempts, max_attempts, run_at,
+	progress, last_error, result, user_id, payload`
+
+// scan reads the jobColumns into a Job. Any extra destinations are scanned after them.
+func (q *Queue) scan(row interface{ Scan(...any) error }, extra ...any) (*Job, error) {
+	job := Job{queue: q}
+
+	var (
+		result  []byte
+		payload []byte
+		userID  sql.NullInt64
+	)
+
+	dest := []any{
+		&job.ID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.Kind,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.Progress,
+		&job.LastError,
+		&result,
+		&userID,
+		&payload,
+	}
+
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Result = result
+	job.Payload = payload
+
+	if userID.Valid {
+		job.UserID = &userID.Int64
+	}
+
+	return &job, nil
+}
+
+// claim takes the next job that is due. Running jobs whose lease has run out are
+// picked up again too, as their worker must have died.
+func (q *Queue) claim() (*Job, error) {
+	kinds := make([]string, 0, len(q.handlers))
+	for kind := range q.handlers {
+		kinds = append(kinds, kind)
+	}
+
+	query := `
+UPDATE jobs
+SET status = 'running', attempts = attempts + 1, locked_at = NOW(), updated_at = NOW()
+WHERE id = (
+	SELECT id FROM jobs
+	WHERE kind = ANY($1)
+	AND (
+		(status = 'queued' AND run_at <= NOW())
+		OR (status = 'running' AND locked_at < $2 AND attempts < max_attempts)
+	)
+	ORDER BY run_at, id
+	LIMIT 1
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING ` + jobColumns + `, input, locked_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var input []byte
+	var lockedAt time.Time
+
+	job, err := q.scan(q.db.QueryRowContext(ctx, query, pq.Array(kinds), time.Now().Add(-lease)), &input, &lockedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	job.Input = input
+	job.lease = lockedAt
+	return job, nil
+}
+
+// execute runs one attempt at a claimed job and records the outcome. The job's context
+// outlives ctx by shutdownGrace, so a shutdown lets short jobs finish.
+func (q *Queue) execute(ctx context.Context, job *Job) {
+	jobCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jobTimeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		heartbeat := time.NewTicker(lease / 3)
+		defer heartbeat.Stop()
+
+		shutdown := ctx.Done()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-shutdown:
+				shutdown = nil
+				time.AfterFunc(shutdownGrace, cancel)
+			case <-heartbeat.C:
+				err := job.renewLease("")
+				if err != nil {
+					q.logger.Error(err.Error(), "component", "jobs", "job_id", job.ID)
+				}
+
+				// Another worker has the job now, so there's no point carrying on.
+				if errors.Is(err, ErrLeaseLost) {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	result, err := q.run(jobCtx, job)
+
+	switch {
+	case err == nil:
+		err = q.succeed(job, result)
+	case ctx.Err() != nil && jobCtx.Err() != nil && !errors.As(err, new(permanentError)):
+		// Cut short by a shutdown, which isn't the job's fault. Handlers that can't
+		// safely start again from the top return a Permanent error instead.
+		q.logger.Info("returning job to the queue", "component", "jobs", "job_id", job.ID, "kind", job.Kind)
+		err = q.release(job)
+	default:
+		q.logger.Error(err.Error(), "component", "jobs", "job_id", job.ID, "kind", job.Kind, "attempt", job.Attempts)
+		err = q.fail(job, err)
+	}
+
+	if err != nil {
+		q.logger.Error(err.Error(), "component", "jobs", "job_id", job.ID)
+	}
+}
+
+// run calls the handler, turning a panic into an error so one bad job can't take the
+// worker down with it.
+func (q *Queue) run(ctx context.Context, job *Job) (result any, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+
+	handler, ok := q.handlers[job.Kind]
+	if !ok {
+		return nil, Permanent(fmt.Errorf("no handler for job kind %q", job.Kind))
+	}
+
+	return handler(ctx, job)
+}
+
+// reap marks jobs dead when their worker stopped sending heartbeats and they have no
+// attempts left, as claim won't pick those up again.
+func (q *Queue) reap() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := q.db.ExecContext(ctx, `
+UPDATE jobs
+SET status = 'dead', last_error = 'worker stopped responding', locked_at = NULL, updated_at = NOW()
+WHERE status = 'running' AND locked_at < $1 AND attempts >= max_attempts`, time.Now().Add(-lease))
+	return err
+}
+
+func (q *Queue) succeed(job *Job, result any) error {
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		return q.fail(job, Permanent(fmt.Errorf("encoding result: %w", err)))
+	}
+
+	return job.finish(`status = 'succeeded', progress = 100, result = $3, input = NULL, last_error = ''`, resultJSON)
+}
+
+// fail schedules a retry with exponential backoff, or marks the job dead once it has
+// used all its attempts or the error is permanent.
+func (q *Queue) fail(job *Job, jobErr error) error {
+	status := StatusQueued
+	if job.Attempts >= job.MaxAttempts || errors.As(jobErr, new(permanentError)) {
+		status = StatusDead
+	}
+
+	return job.finish(`status = $3, run_at = $4, last_error = $5`, status, time.Now().Add(backoff(job.Attempts)), jobErr.Error())
+}
+
+// release puts a job back on the queue without using up an attempt.
+func (q *Queue) release(job *Job) error {
+	return job.finish(`status = 'queued', attempts = attempts - 1, run_at = NOW()`)
+}
+
+// backoff doubles from 10 seconds with each attempt, up to an hour, with jitter so
+// jobs that failed together don't all retry together.
+func backoff(attempt int) time.Duration {
+	delay := 10 * time.Second << max(0, min(attempt-1, 10))
+	delay = min(delay, time.Hour)
+
+	return delay/2 + rand.N(delay/2)
+}
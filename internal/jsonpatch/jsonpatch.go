@@ -0,0 +1,327 @@
+// Package jsonpatch applies JSON Merge Patch (RFC 7396) and JSON Patch (RFC 6902)
+// documents to JSON values. Only the add, remove, replace and test operations from
+// RFC 6902 are supported.
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrTestFailed is returned when a "test" operation doesn't match. No part of the
+	// patch is applied when this happens.
+	ErrTestFailed = errors.New("test operation failed")
+
+	ErrPathNotFound = errors.New("path does not exist")
+	ErrInvalidPath  = errors.New("invalid path")
+)
+
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// OperationError wraps the reason an operation couldn't be applied with its position
+// in the patch, so clients can tell which one to fix.
+type OperationError struct {
+	Index int
+	Op    string
+	Path  string
+	Err   error
+}
+
+func (e *OperationError) Error() string {
+	return fmt.Sprintf("patch operation %d (%s %s): %s", e.Index, e.Op, e.Path, e.Err)
+}
+
+func (e *OperationError) Unwrap() error {
+	return e.Err
+}
+
+// MergePatch applies an RFC 7396 merge patch to doc. Keys set to null in the patch are
+// removed from the result, objects are merged recursively and anything else replaces
+// the original value outright.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	var target, p any
+
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(mergePatch(target, p))
+}
+
+func mergePatch(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = make(map[string]any)
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+
+		targetObject[key] = mergePatch(targetObject[key], value)
+	}
+
+	return targetObject
+}
+
+// Apply runs the operations against doc in order. The patch is all or nothing: if any
+// operation fails the error is returned and doc is left as it was.
+func Apply(doc []byte, ops []Operation) ([]byte, error) {
+	var target any
+
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+
+	for i, op := range ops {
+		var err error
+
+		target, err = applyOperation(target, op)
+		if err != nil {
+			return nil, &OperationError{Index: i, Op: op.Op, Path: op.Path, Err: err}
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+func applyOperation(doc any, op Operation) (any, error) {
+	tokens, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value any
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, errors.New("value must be provided")
+		}
+
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, err
+		}
+	case "remove":
+	default:
+		return nil, fmt.Errorf("unsupported op %q", op.Op)
+	}
+
+	switch op.Op {
+	case "add":
+		if len(tokens) == 0 {
+			return value, nil
+		}
+
+		return update(doc, tokens, func(container any, key string) (any, error) {
+			switch c := container.(type) {
+			case map[string]any:
+				c[key] = value
+				return c, nil
+			case []any:
+				if key == "-" {
+					return append(c, value), nil
+				}
+
+				i, err := arrayIndex(key, len(c)+1)
+				if err != nil {
+					return nil, err
+				}
+
+				c = append(c, nil)
+				copy(c[i+1:], c[i:])
+				c[i] = value
+
+				return c, nil
+			default:
+				return nil, ErrPathNotFound
+			}
+		})
+
+	case "remove":
+		if len(tokens) == 0 {
+			return nil, ErrInvalidPath
+		}
+
+		return update(doc, tokens, func(container any, key string) (any, error) {
+			switch c := container.(type) {
+			case map[string]any:
+				if _, ok := c[key]; !ok {
+					return nil, ErrPathNotFound
+				}
+
+				delete(c, key)
+				return c, nil
+			case []any:
+				i, err := arrayIndex(key, len(c))
+				if err != nil {
+					return nil, err
+				}
+
+				return append(c[:i], c[i+1:]...), nil
+			default:
+				return nil, ErrPathNotFound
+			}
+		})
+
+	case "replace":
+		if len(tokens) == 0 {
+			return value, nil
+		}
+
+		return update(doc, tokens, func(container any, key string) (any, error) {
+			switch c := container.(type) {
+			case map[string]any:
+				if _, ok := c[key]; !ok {
+					return nil, ErrPathNotFound
+				}
+
+				c[key] = value
+				return c, nil
+			case []any:
+				i, err := arrayIndex(key, len(c))
+				if err != nil {
+					return nil, err
+				}
+
+				c[i] = value
+				return c, nil
+			default:
+				return nil, ErrPathNotFound
+			}
+		})
+
+	default: // test
+		current, err := get(doc, tokens)
+		if err != nil {
+			return nil, err
+		}
+
+		if !reflect.DeepEqual(current, value) {
+			return nil, ErrTestFailed
+		}
+
+		return doc, nil
+	}
+}
+
+// update walks down to the container holding the last token and lets fn change it.
+// Slices may be reallocated by fn, so each level writes its child back on the way out.
+func update(node any, tokens []string, fn func(container any, key string) (any, error)) (any, error) {
+	if len(tokens) == 1 {
+		return fn(node, tokens[0])
+	}
+
+	switch n := node.(type) {
+	case map[string]any:
+		child, ok := n[tokens[0]]
+		if !ok {
+			return nil, ErrPathNotFound
+		}
+
+		child, err := update(child, tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+
+		n[tokens[0]] = child
+		return n, nil
+	case []any:
+		i, err := arrayIndex(tokens[0], len(n))
+		if err != nil {
+			return nil, err
+		}
+
+		child, err := update(n[i], tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+
+		n[i] = child
+		return n, nil
+	default:
+		return nil, ErrPathNotFound
+	}
+}
+
+func get(node any, tokens []string) (any, error) {
+	for _, token := range tokens {
+		switch n := node.(type) {
+		case map[string]any:
+			child, ok := n[token]
+			if !ok {
+				return nil, ErrPathNotFound
+			}
+
+			node = child
+		case []any:
+			i, err := arrayIndex(token, len(n))
+			if err != nil {
+				return nil, err
+			}
+
+			node = n[i]
+		default:
+			return nil, ErrPathNotFound
+		}
+	}
+
+	return node, nil
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its unescaped reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, ErrInvalidPath
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+// arrayIndex parses an array index token, which must be less than limit. Leading zeros
+// aren't allowed by RFC 6901.
+func arrayIndex(token string, limit int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, ErrInvalidPath
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 {
+		return 0, ErrInvalidPath
+	}
+
+	if i >= limit {
+		return 0, ErrPathNotFound
+	}
+
+	return i, nil
+}
@@ -0,0 +1,306 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// assertJSON fails unless got and want hold the same JSON value.
+func assertJSON(t *testing.T, got []byte, want string) {
+	t.Helper()
+
+	var g, w any
+
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("result isn't JSON: %v: %s", err, got)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatalf("bad test case: %v", err)
+	}
+
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func parseOps(t *testing.T, patch string) []Operation {
+	t.Helper()
+
+	var ops []Operation
+	if err := json.Unmarshal([]byte(patch), &ops); err != nil {
+		t.Fatalf("bad test case: %v", err)
+	}
+
+	return ops
+}
+
+// The cases from RFC 7396, Appendix A.
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.doc+" "+tt.patch, func(t *testing.T) {
+			got, err := MergePatch([]byte(tt.doc), []byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assertJSON(t, got, tt.want)
+		})
+	}
+}
+
+// The cases from RFC 6902, Appendix A, apart from move and copy, which aren't
+// supported, and A.13, which is about parsing duplicate members.
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name, doc, patch, want string
+		err                    error
+	}{
+		{
+			name:  "A.1 adding an object member",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz","value":"qux"}]`,
+			want:  `{"baz":"qux","foo":"bar"}`,
+		},
+		{
+			name:  "A.2 adding an array element",
+			doc:   `{"foo":["bar","baz"]}`,
+			patch: `[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			want:  `{"foo":["bar","qux","baz"]}`,
+		},
+		{
+			name:  "A.3 removing an object member",
+			doc:   `{"baz":"qux","foo":"bar"}`,
+			patch: `[{"op":"remove","path":"/baz"}]`,
+			want:  `{"foo":"bar"}`,
+		},
+		{
+			name:  "A.4 removing an array element",
+			doc:   `{"foo":["bar","qux","baz"]}`,
+			patch: `[{"op":"remove","path":"/foo/1"}]`,
+			want:  `{"foo":["bar","baz"]}`,
+		},
+		{
+			name:  "A.5 replacing a value",
+			doc:   `{"baz":"qux","foo":"bar"}`,
+			patch: `[{"op":"replace","path":"/baz","value":"boo"}]`,
+			want:  `{"baz":"boo","foo":"bar"}`,
+		},
+		{
+			name:  "A.8 testing a value: success",
+			doc:   `{"baz":"qux","foo":["a",2,"c"]}`,
+			patch: `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			want:  `{"baz":"qux","foo":["a",2,"c"]}`,
+		},
+		{
+			name:  "A.9 testing a value: error",
+			doc:   `{"baz":"qux"}`,
+			patch: `[{"op":"test","path":"/baz","value":"bar"}]`,
+			err:   ErrTestFailed,
+		},
+		{
+			name:  "A.10 adding a nested member object",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`,
+			want:  `{"foo":"bar","child":{"grandchild":{}}}`,
+		},
+		{
+			name:  "A.11 ignoring unrecognized elements",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`,
+			want:  `{"foo":"bar","baz":"qux"}`,
+		},
+		{
+			name:  "A.12 adding to a nonexistent target",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz/bat","value":"qux"}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "A.14 ~ escape ordering",
+			doc:   `{"/":9,"~1":10}`,
+			patch: `[{"op":"test","path":"/~01","value":10}]`,
+			want:  `{"/":9,"~1":10}`,
+		},
+		{
+			name:  "A.15 comparing strings and numbers",
+			doc:   `{"/":9,"~1":10}`,
+			patch: `[{"op":"test","path":"/~01","value":"10"}]`,
+			err:   ErrTestFailed,
+		},
+		{
+			name:  "A.16 adding an array value",
+			doc:   `{"foo":["bar"]}`,
+			patch: `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+			want:  `{"foo":["bar",["abc","def"]]}`,
+		},
+		{
+			name:  "~1 escapes a slash",
+			doc:   `{"a/b":1}`,
+			patch: `[{"op":"replace","path":"/a~1b","value":2}]`,
+			want:  `{"a/b":2}`,
+		},
+		{
+			name:  "~0 escapes a tilde",
+			doc:   `{"m~n":1}`,
+			patch: `[{"op":"remove","path":"/m~0n"}]`,
+			want:  `{}`,
+		},
+		{
+			name:  "adding at the end of an array by index",
+			doc:   `{"foo":["bar"]}`,
+			patch: `[{"op":"add","path":"/foo/1","value":"baz"}]`,
+			want:  `{"foo":["bar","baz"]}`,
+		},
+		{
+			name:  "adding past the end of an array",
+			doc:   `{"foo":["bar"]}`,
+			patch: `[{"op":"add","path":"/foo/2","value":"baz"}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "replacing the element after the last",
+			doc:   `{"foo":["bar"]}`,
+			patch: `[{"op":"replace","path":"/foo/-","value":"baz"}]`,
+			err:   ErrInvalidPath,
+		},
+		{
+			name:  "removing the element after the last",
+			doc:   `{"foo":["bar"]}`,
+			patch: `[{"op":"remove","path":"/foo/-"}]`,
+			err:   ErrInvalidPath,
+		},
+		{
+			name:  "array indexes can't have leading zeros",
+			doc:   `{"foo":["bar","baz"]}`,
+			patch: `[{"op":"remove","path":"/foo/01"}]`,
+			err:   ErrInvalidPath,
+		},
+		{
+			name:  "testing a missing path",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"test","path":"/baz","value":"bar"}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "testing an array",
+			doc:   `{"foo":["a",{"b":1}]}`,
+			patch: `[{"op":"test","path":"/foo","value":["a",{"b":1}]}]`,
+			want:  `{"foo":["a",{"b":1}]}`,
+		},
+		{
+			name:  "replacing the whole document",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"replace","path":"","value":[1]}]`,
+			want:  `[1]`,
+		},
+		{
+			name:  "paths must start with a slash",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"remove","path":"foo"}]`,
+			err:   ErrInvalidPath,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply([]byte(tt.doc), parseOps(t, tt.patch))
+
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got error %v, want %v", err, tt.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assertJSON(t, got, tt.want)
+		})
+	}
+}
+
+// A failing operation must leave no trace of the ones before it, including in
+// containers they changed in place.
+func TestApplyIsAtomic(t *testing.T) {
+	doc := []byte(`{"foo":["bar","baz"],"obj":{"a":1}}`)
+	original := string(doc)
+
+	ops := parseOps(t, `[
+		{"op":"add","path":"/foo/0","value":"first"},
+		{"op":"remove","path":"/obj/a"},
+		{"op":"replace","path":"/foo/2","value":"changed"},
+		{"op":"test","path":"/foo/0","value":"bar"}
+	]`)
+
+	got, err := Apply(doc, ops)
+
+	var opErr *OperationError
+	if !errors.As(err, &opErr) {
+		t.Fatalf("got error %v, want an *OperationError", err)
+	}
+	if opErr.Index != 3 || opErr.Op != "test" || !errors.Is(err, ErrTestFailed) {
+		t.Errorf("got %+v, want the test at index 3 to fail", opErr)
+	}
+
+	if got != nil {
+		t.Errorf("got a partial result %s", got)
+	}
+	if string(doc) != original {
+		t.Errorf("doc changed to %s", doc)
+	}
+
+	// The same document can still be patched afterwards.
+	got, err = Apply(doc, ops[:3])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertJSON(t, got, `{"foo":["first","bar","changed"],"obj":{}}`)
+}
+
+func TestApplyRejectsBadOperations(t *testing.T) {
+	tests := []struct {
+		name, patch string
+	}{
+		{"move isn't supported", `[{"op":"move","from":"/foo","path":"/bar"}]`},
+		{"copy isn't supported", `[{"op":"copy","from":"/foo","path":"/bar"}]`},
+		{"add needs a value", `[{"op":"add","path":"/bar"}]`},
+		{"test needs a value", `[{"op":"test","path":"/foo"}]`},
+		{"the root can't be removed", `[{"op":"remove","path":""}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Apply([]byte(`{"foo":"bar"}`), parseOps(t, tt.patch))
+
+			var opErr *OperationError
+			if !errors.As(err, &opErr) || opErr.Index != 0 {
+				t.Errorf("got error %v, want an *OperationError for operation 0", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,375 @@
+// Package websocket is a small server-side implementation of the WebSocket protocol
+// (RFC 6455). It covers what the API needs: the opening handshake, text and binary
+// messages split over any number of frames, ping/pong and the closing handshake.
+// Extensions and subprotocols aren't supported.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Message types, which are also the frame opcodes.
+const (
+	continuationFrame = 0
+	TextMessage       = 1
+	BinaryMessage     = 2
+	CloseMessage      = 8
+	PingMessage       = 9
+	PongMessage       = 10
+)
+
+// Close codes from section 7.4.1 of the RFC.
+const (
+	CloseNormalClosure    = 1000
+	CloseGoingAway        = 1001
+	CloseProtocolError    = 1002
+	CloseUnsupportedData  = 1003
+	CloseNoStatusReceived = 1005
+	CloseInvalidPayload   = 1007
+	ClosePolicyViolation  = 1008
+	CloseMessageTooBig    = 1009
+	CloseInternalError    = 1011
+	CloseTryAgainLater    = 1013
+)
+
+// acceptGUID is appended to the client's key to prove the server speaks WebSocket.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	ErrBadHandshake = errors.New("websocket: not a valid websocket handshake")
+	ErrClosed       = errors.New("websocket: connection closed")
+)
+
+// A CloseError is returned by ReadMessage once the peer has closed the connection.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with code %d %s", e.Code, e.Text)
+}
+
+// Conn is a server-side WebSocket connection. One goroutine can read while others
+// write; writes are serialised.
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	// MaxMessageSize is the largest message ReadMessage accepts, in bytes. Anything
+	// bigger closes the connection with CloseMessageTooBig.
+	MaxMessageSize int64
+
+	// ReadTimeout, if set, is how long ReadMessage waits for each frame. Sending
+	// pings more often than this keeps a healthy but quiet client connected.
+	ReadTimeout time.Duration
+
+	// WriteTimeout, if set, limits how long a write can block on a client that
+	// isn't reading.
+	WriteTimeout time.Duration
+
+	wmu        sync.Mutex
+	closeSent  bool
+	closeOnce  sync.Once
+	closeError error
+}
+
+// Upgrade runs the opening handshake and takes over the connection. If the request
+// isn't a valid handshake it returns ErrBadHandshake without writing anything, so the
+// caller can send its own error response.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet ||
+		!headerContainsToken(r.Header, "Connection", "upgrade") ||
+		!headerContainsToken(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, ErrBadHandshake
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, ErrBadHandshake
+	}
+
+	netConn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	// The server's read and write deadlines carry over to the hijacked connection.
+	if err := netConn.SetDeadline(time.Time{}); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	hash := sha1.Sum([]byte(key + acceptGUID))
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(hash[:]) + "\r\n\r\n"
+
+	if _, err := netConn.Write([]byte(response)); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	return &Conn{
+		conn:           netConn,
+		br:             brw.Reader,
+		MaxMessageSize: 64 * 1024,
+	}, nil
+}
+
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ReadMessage returns the next text or binary message. Pings are answered and pongs
+// skipped along the way. Once the client closes the connection the close is echoed
+// back and a *CloseError returned. Protocol errors close the connection too.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	var (
+		messageType int
+		message     []byte
+	)
+
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch opcode {
+		case PingMessage:
+			if err := c.WriteControl(PongMessage, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			continue
+		case CloseMessage:
+			return 0, nil, c.readClose(payload)
+		case continuationFrame:
+			if messageType == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "continuation frame without a message")
+			}
+
+			if int64(len(message)+len(payload)) > c.MaxMessageSize {
+				return 0, nil, c.fail(CloseMessageTooBig, "message too big")
+			}
+
+			message = append(message, payload...)
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "new message before the last one finished")
+			}
+
+			messageType, message = opcode, payload
+		default:
+			return 0, nil, c.fail(CloseProtocolError, "unknown opcode")
+		}
+
+		if fin {
+			if messageType == TextMessage && !utf8.Valid(message) {
+				return 0, nil, c.fail(CloseInvalidPayload, "text message is not valid UTF-8")
+			}
+
+			return messageType, message, nil
+		}
+	}
+}
+
+// readFrame reads one frame and unmasks its payload.
+func (c *Conn) readFrame() (bool, int, []byte, error) {
+	if c.ReadTimeout > 0 {
+		if err := c.conn.SetReadDeadline(time.Now().Add(c.ReadTimeout)); err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := header[0]&0x80 != 0
+	opcode := int(header[0] & 0x0f)
+
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "reserved bits set")
+	}
+
+	// Every frame from a client has to be masked.
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "frame not masked")
+	}
+
+	length := int64(header[1] & 0x7f)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+
+	if opcode >= CloseMessage && (!fin || length > 125) {
+		return false, 0, nil, c.fail(CloseProtocolError, "invalid control frame")
+	}
+
+	if length < 0 || length > c.MaxMessageSize {
+		return false, 0, nil, c.fail(CloseMessageTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// readClose answers a close frame from the client and returns it as a CloseError.
+func (c *Conn) readClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatusReceived}
+
+	switch {
+	case len(payload) == 1:
+		return c.fail(CloseProtocolError, "invalid close frame")
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Text = string(payload[2:])
+
+		if !utf8.Valid(payload[2:]) {
+			return c.fail(CloseInvalidPayload, "close reason is not valid UTF-8")
+		}
+	}
+
+	code := closeErr.Code
+	if code == CloseNoStatusReceived {
+		code = CloseNormalClosure
+	}
+
+	c.WriteClose(code, "")
+
+	return closeErr
+}
+
+// fail closes the connection because the client broke the protocol.
+func (c *Conn) fail(code int, reason string) error {
+	c.WriteClose(code, reason)
+	return fmt.Errorf("websocket: %s", reason)
+}
+
+// WriteMessage sends a text or binary message in a single frame.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", messageType)
+	}
+
+	return c.writeFrame(messageType, data)
+}
+
+// WriteControl sends a ping, pong or close frame. Control frames carry at most 125
+// bytes.
+func (c *Conn) WriteControl(messageType int, data []byte) error {
+	if messageType < CloseMessage || len(data) > 125 {
+		return fmt.Errorf("websocket: invalid control frame")
+	}
+
+	return c.writeFrame(messageType, data)
+}
+
+// WriteClose starts (or finishes) the closing handshake. Nothing can be written after
+// it, and it's safe to call more than once.
+func (c *Conn) WriteClose(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason[:min(len(reason), 123)]...)
+
+	return c.writeFrame(CloseMessage, payload)
+}
+
+func (c *Conn) writeFrame(opcode int, data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.closeSent {
+		return ErrClosed
+	}
+
+	if opcode == CloseMessage {
+		c.closeSent = true
+	}
+
+	// Server frames are never masked.
+	frame := []byte{0x80 | byte(opcode)}
+
+	switch {
+	case len(data) <= 125:
+		frame = append(frame, byte(len(data)))
+	case len(data) <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(data)))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(data)))
+	}
+
+	frame = append(frame, data...)
+
+	if c.WriteTimeout > 0 {
+		if err := c.conn.SetWriteDeadline(time.Now().Add(c.WriteTimeout)); err != nil {
+			return err
+		}
+	}
+
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Close closes the underlying connection without a closing handshake. Call
+// WriteClose first for a clean close.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.closeError = c.conn.Close()
+	})
+
+	return c.closeError
+}
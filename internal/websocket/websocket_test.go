@@ -0,0 +1,519 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// recordingConn stands in for the network connection. Frames from the client are read
+// from a buffer by the test, and whatever the server writes is kept in out.
+type recordingConn struct {
+	net.Conn
+	out bytes.Buffer
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	return c.out.Write(p)
+}
+
+func (c *recordingConn) Close() error {
+	return nil
+}
+
+// clientFrame builds a frame the way a client sends it, masked unless unmasked is set.
+func clientFrame(fin bool, opcode int, payload []byte, unmasked bool) []byte {
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+
+	frame := []byte{first}
+
+	var maskBit byte
+	if !unmasked {
+		maskBit = 0x80
+	}
+
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+
+	if unmasked {
+		return append(frame, payload...)
+	}
+
+	mask := []byte{0x37, 0xfa, 0x21, 0x3d}
+	frame = append(frame, mask...)
+
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	return frame
+}
+
+func text(fin bool, payload string) []byte {
+	return clientFrame(fin, TextMessage, []byte(payload), false)
+}
+
+func continuation(fin bool, payload string) []byte {
+	return clientFrame(fin, continuationFrame, []byte(payload), false)
+}
+
+func closeFrame(code int, reason string) []byte {
+	return clientFrame(true, CloseMessage, append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...), false)
+}
+
+type serverFrame struct {
+	opcode  int
+	payload string
+}
+
+// readServerFrames parses what the server wrote, checking each frame is final and
+// unmasked as section 5.1 of the RFC requires.
+func readServerFrames(t *testing.T, out []byte) []serverFrame {
+	t.Helper()
+
+	var frames []serverFrame
+
+	for len(out) > 0 {
+		if out[0]&0x80 == 0 || out[1]&0x80 != 0 {
+			t.Fatalf("server frame %x is fragmented or masked", out[:2])
+		}
+
+		opcode := int(out[0] & 0x0f)
+		length := int(out[1] & 0x7f)
+		out = out[2:]
+
+		switch length {
+		case 126:
+			length = int(binary.BigEndian.Uint16(out))
+			out = out[2:]
+		case 127:
+			length = int(binary.BigEndian.Uint64(out))
+			out = out[8:]
+		}
+
+		frames = append(frames, serverFrame{opcode, string(out[:length])})
+		out = out[length:]
+	}
+
+	return frames
+}
+
+// closeCode is the code of a close frame's payload.
+func closeCode(payload string) int {
+	if len(payload) < 2 {
+		return 0
+	}
+
+	return int(binary.BigEndian.Uint16([]byte(payload)))
+}
+
+type message struct {
+	messageType int
+	data        string
+}
+
+func TestReadMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxSize int64
+		frames  [][]byte
+
+		// messages are what ReadMessage returns before it fails.
+		messages []message
+		// pongs are the payloads of the pongs sent back.
+		pongs []string
+		// closeCode is the code of the close frame the server sent, if any.
+		closeCode int
+		// peerClose is the error ReadMessage ends with after a close from the client.
+		peerClose *CloseError
+	}{
+		{
+			name:     "masked text message",
+			frames:   [][]byte{text(true, "hello")},
+			messages: []message{{TextMessage, "hello"}},
+		},
+		{
+			name:     "binary message",
+			frames:   [][]byte{clientFrame(true, BinaryMessage, []byte{0, 1, 2}, false)},
+			messages: []message{{BinaryMessage, "\x00\x01\x02"}},
+		},
+		{
+			name:      "unmasked frame",
+			frames:    [][]byte{clientFrame(true, TextMessage, []byte("hello"), true)},
+			closeCode: CloseProtocolError,
+		},
+		{
+			name:      "reserved bits set",
+			frames:    [][]byte{append([]byte{0xc1}, text(true, "hi")[1:]...)},
+			closeCode: CloseProtocolError,
+		},
+		{
+			name:     "16-bit length",
+			frames:   [][]byte{text(true, strings.Repeat("a", 300))},
+			messages: []message{{TextMessage, strings.Repeat("a", 300)}},
+		},
+		{
+			name:     "fragmented message",
+			frames:   [][]byte{text(false, "Hel"), continuation(false, "lo, "), continuation(true, "world")},
+			messages: []message{{TextMessage, "Hello, world"}},
+		},
+		{
+			name:     "fragments split a UTF-8 character",
+			frames:   [][]byte{text(false, "caf\xc3"), continuation(true, "\xa9")},
+			messages: []message{{TextMessage, "café"}},
+		},
+		{
+			name: "messages one after another",
+			frames: [][]byte{
+				text(false, "one, "), continuation(true, "two"),
+				text(true, "three"),
+			},
+			messages: []message{{TextMessage, "one, two"}, {TextMessage, "three"}},
+		},
+		{
+			name: "ping in the middle of a message",
+			frames: [][]byte{
+				text(false, "Hel"),
+				clientFrame(true, PingMessage, []byte("are you there"), false),
+				continuation(true, "lo"),
+			},
+			messages: []message{{TextMessage, "Hello"}},
+			pongs:    []string{"are you there"},
+		},
+		{
+			name: "pong in the middle of a message",
+			frames: [][]byte{
+				text(false, "Hel"),
+				clientFrame(true, PongMessage, []byte("unsolicited"), false),
+				continuation(true, "lo"),
+			},
+			messages: []message{{TextMessage, "Hello"}},
+		},
+		{
+			name:      "close in the middle of a message",
+			frames:    [][]byte{text(false, "Hel"), closeFrame(CloseGoingAway, "leaving")},
+			closeCode: CloseGoingAway,
+			peerClose: &CloseError{Code: CloseGoingAway, Text: "leaving"},
+		},
+		{
+			name:      "fragmented ping",
+			frames:    [][]byte{clientFrame(false, PingMessage, []byte("ping"), false)},
+			closeCode: CloseProtocolError,
+		},
+		{
+			name:      "control frame over 125 bytes",
+			frames:    [][]byte{clientFrame(true, PingMessage, bytes.Repeat([]byte("a"), 126), false)},
+			closeCode: CloseProtocolError,
+		},
+		{
+			name:      "continuation without a message",
+			frames:    [][]byte{continuation(true, "lo")},
+			closeCode: CloseProtocolError,
+		},
+		{
+			name:      "new message before the last one finished",
+			frames:    [][]byte{text(false, "Hel"), text(true, "lo")},
+			closeCode: CloseProtocolError,
+		},
+		{
+			name:      "unknown opcode",
+			frames:    [][]byte{clientFrame(true, 3, []byte("?"), false)},
+			closeCode: CloseProtocolError,
+		},
+		{
+			name:      "frame over the size limit",
+			maxSize:   16,
+			frames:    [][]byte{text(true, strings.Repeat("a", 17))},
+			closeCode: CloseMessageTooBig,
+		},
+		{
+			name:    "64-bit length over the size limit",
+			maxSize: 16,
+			// Only the header is sent: the length alone is enough to refuse it.
+			frames:    [][]byte{{0x81, 0x80 | 127, 0, 0, 0, 1, 0, 0, 0, 0}},
+			closeCode: CloseMessageTooBig,
+		},
+		{
+			name:      "fragments over the size limit",
+			maxSize:   16,
+			frames:    [][]byte{text(false, strings.Repeat("a", 10)), continuation(true, strings.Repeat("a", 10))},
+			closeCode: CloseMessageTooBig,
+		},
+		{
+			name:     "message at the size limit",
+			maxSize:  16,
+			frames:   [][]byte{text(false, strings.Repeat("a", 8)), continuation(true, strings.Repeat("a", 8))},
+			messages: []message{{TextMessage, strings.Repeat("a", 16)}},
+		},
+		{
+			name:      "invalid UTF-8",
+			frames:    [][]byte{text(true, "\xff\xfe")},
+			closeCode: CloseInvalidPayload,
+		},
+		{
+			name:      "close handshake",
+			frames:    [][]byte{text(true, "bye"), closeFrame(CloseNormalClosure, "done")},
+			messages:  []message{{TextMessage, "bye"}},
+			closeCode: CloseNormalClosure,
+			peerClose: &CloseError{Code: CloseNormalClosure, Text: "done"},
+		},
+		{
+			name:      "close without a code",
+			frames:    [][]byte{clientFrame(true, CloseMessage, nil, false)},
+			closeCode: CloseNormalClosure,
+			peerClose: &CloseError{Code: CloseNoStatusReceived},
+		},
+		{
+			name:      "close with a 1-byte payload",
+			frames:    [][]byte{clientFrame(true, CloseMessage, []byte{3}, false)},
+			closeCode: CloseProtocolError,
+		},
+		{
+			name:      "close reason that isn't UTF-8",
+			frames:    [][]byte{closeFrame(CloseNormalClosure, "\xff")},
+			closeCode: CloseInvalidPayload,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			netConn := &recordingConn{}
+
+			c := &Conn{
+				conn:           netConn,
+				br:             bufio.NewReader(bytes.NewReader(bytes.Join(tt.frames, nil))),
+				MaxMessageSize: 64 * 1024,
+			}
+			if tt.maxSize > 0 {
+				c.MaxMessageSize = tt.maxSize
+			}
+
+			var (
+				messages []message
+				err      error
+			)
+
+			for {
+				var messageType int
+				var data []byte
+
+				messageType, data, err = c.ReadMessage()
+				if err != nil {
+					break
+				}
+
+				messages = append(messages, message{messageType, string(data)})
+			}
+
+			if len(messages) != len(tt.messages) {
+				t.Fatalf("got messages %q, want %q", messages, tt.messages)
+			}
+			for i := range messages {
+				if messages[i] != tt.messages[i] {
+					t.Errorf("got message %q, want %q", messages[i], tt.messages[i])
+				}
+			}
+
+			var closeErr *CloseError
+
+			switch {
+			case tt.peerClose != nil:
+				if !errors.As(err, &closeErr) || *closeErr != *tt.peerClose {
+					t.Errorf("got error %v, want %v", err, tt.peerClose)
+				}
+			case tt.closeCode != 0:
+				if err == nil || errors.As(err, &closeErr) {
+					t.Errorf("got error %v, want a protocol error", err)
+				}
+			default:
+				// Every frame was read without a close.
+				if !errors.Is(err, io.EOF) {
+					t.Errorf("got error %v, want io.EOF", err)
+				}
+			}
+
+			var pongs []string
+			gotClose := 0
+
+			for _, frame := range readServerFrames(t, netConn.out.Bytes()) {
+				switch frame.opcode {
+				case PongMessage:
+					pongs = append(pongs, frame.payload)
+				case CloseMessage:
+					gotClose = closeCode(frame.payload)
+				default:
+					t.Errorf("server sent an unexpected frame %+v", frame)
+				}
+			}
+
+			if strings.Join(pongs, ",") != strings.Join(tt.pongs, ",") {
+				t.Errorf("got pongs %q, want %q", pongs, tt.pongs)
+			}
+
+			if gotClose != tt.closeCode {
+				t.Errorf("got close code %d, want %d", gotClose, tt.closeCode)
+			}
+		})
+	}
+}
+
+func TestWriteFrames(t *testing.T) {
+	netConn := &recordingConn{}
+	c := &Conn{conn: netConn}
+
+	long := strings.Repeat("a", 70_000)
+
+	if err := c.WriteMessage(TextMessage, []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteMessage(BinaryMessage, []byte(long)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteControl(PingMessage, bytes.Repeat([]byte("a"), 126)); err == nil {
+		t.Error("a control frame over 125 bytes was sent")
+	}
+	if err := c.WriteMessage(PingMessage, nil); err == nil {
+		t.Error("WriteMessage sent a control frame")
+	}
+	if err := c.WriteClose(CloseNormalClosure, strings.Repeat("r", 200)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing goes out after the close frame.
+	if err := c.WriteMessage(TextMessage, []byte("late")); !errors.Is(err, ErrClosed) {
+		t.Errorf("got %v writing after close, want ErrClosed", err)
+	}
+	if err := c.WriteClose(CloseNormalClosure, ""); !errors.Is(err, ErrClosed) {
+		t.Errorf("got %v closing twice, want ErrClosed", err)
+	}
+
+	frames := readServerFrames(t, netConn.out.Bytes())
+
+	want := []serverFrame{
+		{TextMessage, "hi"},
+		{BinaryMessage, long},
+		{CloseMessage, "\x03\xe8" + strings.Repeat("r", 123)},
+	}
+
+	if len(frames) != len(want) {
+		t.Fatalf("got %d frames, want %d", len(frames), len(want))
+	}
+	for i := range frames {
+		if frames[i] != want[i] {
+			t.Errorf("frame %d: got opcode %d with %d bytes, want opcode %d with %d bytes", i, frames[i].opcode, len(frames[i].payload), want[i].opcode, len(want[i].payload))
+		}
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer conn.Close()
+
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		conn.WriteMessage(messageType, data)
+		conn.WriteClose(CloseNormalClosure, "")
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		headers string
+		status  string
+	}{
+		{
+			// The example from section 1.3 of the RFC.
+			name:    "valid handshake",
+			headers: "Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n",
+			status:  "HTTP/1.1 101 Switching Protocols",
+		},
+		{
+			name:    "wrong version",
+			headers: "Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 8\r\n",
+			status:  "HTTP/1.1 400 Bad Request",
+		},
+		{
+			name:    "key that isn't 16 bytes",
+			headers: "Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: c2hvcnQ=\r\nSec-WebSocket-Version: 13\r\n",
+			status:  "HTTP/1.1 400 Bad Request",
+		},
+		{
+			name:    "not an upgrade",
+			headers: "Connection: keep-alive\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n",
+			status:  "HTTP/1.1 400 Bad Request",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			netConn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer netConn.Close()
+
+			_, err = io.WriteString(netConn, "GET / HTTP/1.1\r\nHost: example.com\r\n"+tt.headers+"\r\n")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			br := bufio.NewReader(netConn)
+
+			res, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := res.Proto + " " + res.Status; got != tt.status {
+				t.Fatalf("got %q, want %q", got, tt.status)
+			}
+
+			if res.StatusCode != http.StatusSwitchingProtocols {
+				return
+			}
+
+			if got := res.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+				t.Errorf("got Sec-WebSocket-Accept %q", got)
+			}
+
+			// The connection now speaks WebSocket: the message comes back, then the
+			// server closes.
+			if _, err := netConn.Write(text(true, "echo")); err != nil {
+				t.Fatal(err)
+			}
+
+			out, _ := io.ReadAll(br)
+			frames := readServerFrames(t, out)
+
+			if len(frames) != 2 || frames[0] != (serverFrame{TextMessage, "echo"}) || closeCode(frames[1].payload) != CloseNormalClosure {
+				t.Errorf("got frames %q", frames)
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS genres text[] NOT NULL DEFAULT '{}';
+
+UPDATE movies
+SET genres = ARRAY(
+    SELECT genres.slug
+    FROM movies_genres
+    JOIN genres ON genres.id = movies_genres.genre_id
+    WHERE movies_genres.movie_id = movies.id
+    ORDER BY movies_genres.position
+);
+
+ALTER TABLE movies ALTER COLUMN genres DROP DEFAULT;
+
+DROP TABLE IF EXISTS movies_genres;
+DROP TABLE IF EXISTS genres;
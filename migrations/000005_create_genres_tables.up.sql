@@ -0,0 +1,100 @@
+CREATE TABLE IF NOT EXISTS genres (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    slug text UNIQUE NOT NULL,
+    name text NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS movies_genres (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    genre_id bigint NOT NULL REFERENCES genres ON DELETE RESTRICT,
+    position integer NOT NULL,
+    PRIMARY KEY (movie_id, genre_id)
+);
+
+CREATE INDEX IF NOT EXISTS movies_genres_genre_id_idx ON movies_genres (genre_id);
+
+-- Seed a starting vocabulary so fresh databases can accept movies straight away.
+INSERT INTO genres (slug, name)
+VALUES
+    ('action', 'Action'),
+    ('adventure', 'Adventure'),
+    ('animation', 'Animation'),
+    ('comedy', 'Comedy'),
+    ('crime', 'Crime'),
+    ('documentary', 'Documentary'),
+    ('drama', 'Drama'),
+    ('family', 'Family'),
+    ('fantasy', 'Fantasy'),
+    ('history', 'History'),
+    ('horror', 'Horror'),
+    ('music', 'Music'),
+    ('mystery', 'Mystery'),
+    ('romance', 'Romance'),
+    ('sci-fi', 'Science Fiction'),
+    ('thriller', 'Thriller'),
+    ('war', 'War'),
+    ('western', 'Western')
+ON CONFLICT (slug) DO NOTHING;
+
+-- Free-text values are slugified, so "Sci-Fi" and "sci fi" both become sci-fi. Other
+-- spellings of a seeded genre are mapped with genre_aliases, which has every seeded
+-- name ("Science Fiction" -> science-fiction -> sci-fi) plus common abbreviations.
+CREATE TEMPORARY TABLE genre_aliases (
+    alias text PRIMARY KEY,
+    slug text NOT NULL
+);
+
+INSERT INTO genre_aliases (alias, slug)
+SELECT trim(BOTH '-' FROM regexp_replace(lower(name), '[^a-z0-9]+', '-', 'g')), slug
+FROM genres
+ON CONFLICT (alias) DO NOTHING;
+
+INSERT INTO genre_aliases (alias, slug)
+VALUES
+    ('scifi', 'sci-fi'),
+    ('sf', 'sci-fi'),
+    ('science-fiction-film', 'sci-fi'),
+    ('animated', 'animation'),
+    ('doc', 'documentary'),
+    ('docs', 'documentary'),
+    ('documentaries', 'documentary'),
+    ('historical', 'history'),
+    ('musical', 'music'),
+    ('romantic', 'romance'),
+    ('thrillers', 'thriller'),
+    ('westerns', 'western')
+ON CONFLICT (alias) DO NOTHING;
+
+CREATE TEMPORARY TABLE movie_genre_slugs AS
+SELECT movie_id, COALESCE(genre_aliases.slug, s.slug) AS slug, s.name, s.position
+FROM (
+    SELECT
+        movies.id AS movie_id,
+        trim(BOTH '-' FROM regexp_replace(lower(g.name), '[^a-z0-9]+', '-', 'g')) AS slug,
+        g.name,
+        g.position
+    FROM movies, unnest(movies.genres) WITH ORDINALITY AS g(name, position)
+) AS s
+LEFT JOIN genre_aliases ON genre_aliases.alias = s.slug
+WHERE s.slug <> '';
+
+-- Anything that still doesn't match a genre becomes a new one, named with the first
+-- spelling we find.
+INSERT INTO genres (slug, name)
+SELECT DISTINCT ON (slug) slug, name
+FROM movie_genre_slugs
+ORDER BY slug, name
+ON CONFLICT (slug) DO NOTHING;
+
+INSERT INTO movies_genres (movie_id, genre_id, position)
+SELECT DISTINCT ON (s.movie_id, genres.id) s.movie_id, genres.id, s.position
+FROM movie_genre_slugs AS s
+JOIN genres ON genres.slug = s.slug
+ORDER BY s.movie_id, genres.id, s.position;
+
+DROP TABLE movie_genre_slugs;
+DROP TABLE genre_aliases;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS genres;
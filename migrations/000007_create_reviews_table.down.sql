@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS review_votes;
+DROP TABLE IF EXISTS reviews;
+
+ALTER TABLE movies
+    DROP COLUMN IF EXISTS average_rating,
+    DROP COLUMN IF EXISTS rating_sum,
+    DROP COLUMN IF EXISTS rating_count;
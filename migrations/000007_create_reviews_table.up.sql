@@ -0,0 +1,26 @@
+ALTER TABLE movies
+    ADD COLUMN IF NOT EXISTS rating_count integer NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS rating_sum bigint NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS average_rating numeric(4, 2) NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS reviews (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    rating integer NOT NULL CHECK (rating BETWEEN 1 AND 10),
+    body text NOT NULL DEFAULT '',
+    helpful_count integer NOT NULL DEFAULT 0,
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (movie_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS reviews_movie_id_created_at_idx ON reviews (movie_id, created_at);
+CREATE INDEX IF NOT EXISTS reviews_movie_id_helpful_count_idx ON reviews (movie_id, helpful_count);
+
+CREATE TABLE IF NOT EXISTS review_votes (
+    review_id bigint NOT NULL REFERENCES reviews ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    PRIMARY KEY (review_id, user_id)
+);
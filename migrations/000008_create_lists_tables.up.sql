@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS lists (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    name text NOT NULL,
+    public boolean NOT NULL DEFAULT false,
+    share_slug text UNIQUE NOT NULL,
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (user_id, name)
+);
+
+-- Deleting a movie removes it from every list. Positions may be left with gaps, which
+-- is fine as they are only ever used for ordering and are compacted on the next write.
+CREATE TABLE IF NOT EXISTS list_items (
+    list_id bigint NOT NULL REFERENCES lists ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    position integer NOT NULL,
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (list_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS list_items_movie_id_idx ON list_items (movie_id);
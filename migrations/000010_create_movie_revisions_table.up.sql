@@ -0,0 +1,35 @@
+-- There's deliberately no foreign key to movies: the audit trail has to outlive the
+-- movie when it is purged or hard-deleted.
+CREATE TABLE IF NOT EXISTS movie_revisions (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    movie_id bigint NOT NULL,
+    version integer NOT NULL,
+    action text NOT NULL,
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    snapshot jsonb NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS movie_revisions_movie_id_idx ON movie_revisions (movie_id, id);
+
+-- Only inserts and updates produce a new version; deletes and restores are recorded
+-- against the version the movie was already at.
+CREATE UNIQUE INDEX IF NOT EXISTS movie_revisions_movie_id_version_idx
+    ON movie_revisions (movie_id, version)
+    WHERE action IN ('insert', 'update');
+
+-- Seed a starting revision for movies that existed before history was kept.
+INSERT INTO movie_revisions (created_at, movie_id, version, action, snapshot)
+SELECT created_at, id, version, 'insert', jsonb_build_object(
+    'title', title,
+    'year', year,
+    'runtime', format('%s mins', runtime),
+    'genres', ARRAY(
+        SELECT genres.slug
+        FROM movies_genres
+        INNER JOIN genres ON genres.id = movies_genres.genre_id
+        WHERE movies_genres.movie_id = movies.id
+        ORDER BY movies_genres.position
+    )
+)
+FROM movies;
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS movie_revisions_movie_id_version_idx;
+DELETE FROM movie_revisions WHERE action = 'revert';
+CREATE UNIQUE INDEX IF NOT EXISTS movie_revisions_movie_id_version_idx
+    ON movie_revisions (movie_id, version)
+    WHERE action IN ('insert', 'update');
+
+ALTER TABLE movie_revisions DROP COLUMN IF EXISTS reverted_from;
@@ -0,0 +1,7 @@
+ALTER TABLE movie_revisions ADD COLUMN IF NOT EXISTS reverted_from integer;
+
+-- Reverts produce a new version as well, so they need to be covered by the index.
+DROP INDEX IF EXISTS movie_revisions_movie_id_version_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS movie_revisions_movie_id_version_idx
+    ON movie_revisions (movie_id, version)
+    WHERE action IN ('insert', 'update', 'revert');
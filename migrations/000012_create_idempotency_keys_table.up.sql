@@ -0,0 +1,16 @@
+-- Responses to POST requests sent with an Idempotency-Key header. Keys are scoped to
+-- the user that sent them, with 0 for anonymous requests, so there's no foreign key.
+-- status stays NULL while the first request is still being handled.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id bigint NOT NULL,
+    key text NOT NULL,
+    fingerprint bytea NOT NULL,
+    status integer,
+    headers jsonb,
+    body bytea,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expiry timestamp(0) with time zone NOT NULL,
+    PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expiry_idx ON idempotency_keys (expiry);
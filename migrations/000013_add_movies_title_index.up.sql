@@ -0,0 +1,2 @@
+-- Full text search on titles, used by the title filter on the list and export endpoints.
+CREATE INDEX IF NOT EXISTS movies_title_idx ON movies USING GIN (to_tsvector('simple', title));
@@ -0,0 +1,24 @@
+-- Background jobs, drained by workers with SELECT ... FOR UPDATE SKIP LOCKED. Failed
+-- jobs go back to queued with a later run_at until max_attempts, then become dead.
+-- input holds bulk data for a job (like an uploaded import file) and is cleared once
+-- the job succeeds.
+CREATE TABLE IF NOT EXISTS jobs (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    kind text NOT NULL,
+    payload jsonb NOT NULL DEFAULT '{}',
+    input bytea,
+    status text NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'running', 'succeeded', 'dead')),
+    attempts integer NOT NULL DEFAULT 0,
+    max_attempts integer NOT NULL DEFAULT 5,
+    run_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    locked_at timestamp with time zone,
+    progress integer NOT NULL DEFAULT 0 CHECK (progress BETWEEN 0 AND 100),
+    last_error text NOT NULL DEFAULT '',
+    result jsonb,
+    user_id bigint REFERENCES users ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS jobs_queued_idx ON jobs (run_at) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS jobs_running_idx ON jobs (locked_at) WHERE status = 'running';
@@ -0,0 +1,49 @@
+-- movie_events is the outbox for webhooks. Rows are written in the same transaction as
+-- the change to the movie, and dispatched_at is set once the event has been fanned out
+-- into a delivery for each matching webhook. Like revisions, there's no foreign key to
+-- movies so deletes are still delivered.
+CREATE TABLE IF NOT EXISTS movie_events (
+    id bigserial PRIMARY KEY,
+    created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    event text NOT NULL,
+    movie_id bigint NOT NULL,
+    payload jsonb NOT NULL,
+    dispatched_at timestamp with time zone
+);
+
+CREATE INDEX IF NOT EXISTS movie_events_undispatched_idx ON movie_events (id) WHERE dispatched_at IS NULL;
+CREATE INDEX IF NOT EXISTS movie_events_created_at_idx ON movie_events (created_at);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    url text NOT NULL,
+    secret text NOT NULL,
+    events text[] NOT NULL,
+    active boolean NOT NULL DEFAULT true,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_user_id_idx ON webhooks (user_id);
+
+-- One row per event sent to a webhook, updated after every attempt. A redelivery is a
+-- new row pointing back at the delivery it repeats.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    webhook_id bigint NOT NULL REFERENCES webhooks ON DELETE CASCADE,
+    event_id bigint NOT NULL REFERENCES movie_events ON DELETE CASCADE,
+    redelivery_of bigint REFERENCES webhook_deliveries ON DELETE SET NULL,
+    status text NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'retrying', 'succeeded', 'failed')),
+    attempts integer NOT NULL DEFAULT 0,
+    response_status integer,
+    response_body text NOT NULL DEFAULT '',
+    error text NOT NULL DEFAULT '',
+    duration_ms integer NOT NULL DEFAULT 0,
+    delivered_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, id);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_event_id_idx ON webhook_deliveries (event_id);
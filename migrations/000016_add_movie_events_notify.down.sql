@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS movie_events_notify ON movie_events;
+DROP FUNCTION IF EXISTS notify_movie_event();
@@ -0,0 +1,17 @@
+-- Announce every new movie event on the movie_events channel, so API processes can
+-- push changes to SSE clients however many replicas there are. NOTIFY is only sent
+-- when the transaction commits. The trigger is on the outbox rather than movies
+-- itself because the outbox row is written once the change is complete, genres and
+-- all, and its ID doubles as the SSE event ID for resuming.
+CREATE OR REPLACE FUNCTION notify_movie_event() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('movie_events', NEW.id::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS movie_events_notify ON movie_events;
+
+CREATE TRIGGER movie_events_notify
+    AFTER INSERT ON movie_events
+    FOR EACH ROW EXECUTE FUNCTION notify_movie_event();
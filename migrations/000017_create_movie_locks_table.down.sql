@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS movie_locks;
+DROP FUNCTION IF EXISTS notify_movie_lock();
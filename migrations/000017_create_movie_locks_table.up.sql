@@ -0,0 +1,47 @@
+-- Advisory edit locks. A lock is a lease: it stops counting once expires_at has passed,
+-- even before the reaper gets round to deleting the row.
+CREATE TABLE IF NOT EXISTS movie_locks (
+    movie_id bigint PRIMARY KEY REFERENCES movies ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    acquired_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    expires_at timestamp with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS movie_locks_expires_at_idx ON movie_locks (expires_at);
+
+-- Announce lock changes on the movie_locks channel so WebSocket clients on any API
+-- process hear about them.
+CREATE OR REPLACE FUNCTION notify_movie_lock() RETURNS trigger AS $$
+DECLARE
+    lock movie_locks;
+    action text;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        lock := OLD;
+        action := CASE WHEN OLD.expires_at <= NOW() THEN 'expired' ELSE 'released' END;
+    ELSIF TG_OP = 'UPDATE' AND OLD.user_id = NEW.user_id AND OLD.acquired_at = NEW.acquired_at THEN
+        lock := NEW;
+        action := 'renewed';
+    ELSE
+        lock := NEW;
+        action := 'acquired';
+    END IF;
+
+    PERFORM pg_notify('movie_locks', json_build_object(
+        'action', action,
+        'movie_id', lock.movie_id,
+        'user_id', lock.user_id,
+        'user_name', (SELECT name FROM users WHERE id = lock.user_id),
+        'acquired_at', lock.acquired_at,
+        'expires_at', lock.expires_at
+    )::text);
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS movie_locks_notify ON movie_locks;
+
+CREATE TRIGGER movie_locks_notify
+    AFTER INSERT OR UPDATE OR DELETE ON movie_locks
+    FOR EACH ROW EXECUTE FUNCTION notify_movie_lock();
@@ -0,0 +1,17 @@
+-- movie_events_horizon holds the highest event ID purged from the log. A client
+-- resuming the event stream from before it may have missed events for good. Gaps in
+-- the IDs above it are sequence values that were never used (from rolled back
+-- transactions), not lost events. It has exactly one row.
+CREATE TABLE IF NOT EXISTS movie_events_horizon (
+    id boolean PRIMARY KEY DEFAULT true CHECK (id),
+    purged_through bigint NOT NULL
+);
+
+-- Events purged before this migration weren't recorded, so assume everything older
+-- than the oldest event left (or every event, if none are left) is gone.
+INSERT INTO movie_events_horizon (purged_through)
+SELECT COALESCE(
+    (SELECT min(id) - 1 FROM movie_events),
+    (SELECT CASE WHEN is_called THEN last_value ELSE 0 END FROM movie_events_id_seq)
+)
+ON CONFLICT DO NOTHING;
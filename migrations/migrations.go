@@ -0,0 +1,8 @@
+// Package migrations embeds the SQL migrations so that tools can run them without a
+// copy of the repository.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,324 @@
+// Package client is a Go client for the Greenlight API.
+//
+//	c, err := client.New("https://greenlight.example.com", client.WithToken(token))
+//	if err != nil {
+//		return err
+//	}
+//
+//	movie, err := c.Movies().Get(ctx, 1)
+//	if errors.Is(err, client.ErrRecordNotFound) {
+//		// ...
+//	}
+//
+// Requests that fail with 429 or a 5xx are retried with exponential backoff, waiting
+// for as long as the Retry-After header asks if it is longer. Creates are sent with an
+// Idempotency-Key, so retrying them can't make duplicates. Imports are streamed, so
+// they are never retried.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	mathrand "math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/captainmango/greenlight/internal/data"
+	"github.com/captainmango/greenlight/internal/validator"
+)
+
+// The API's JSON types, aliased so that callers outside this module can name them.
+type (
+	Movie            = data.Movie
+	Runtime          = data.Runtime
+	Metadata         = data.Metadata
+	MovieLock        = data.MovieLock
+	ValidationErrors = validator.ValidationErrors
+)
+
+// A TokenSource returns the authentication token to send with a request. An empty
+// token sends the request anonymously.
+type TokenSource func(ctx context.Context) (string, error)
+
+// Client calls the Greenlight API. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	token      TokenSource
+	userAgent  string
+
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// An Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the http.Client used to send requests. The default is a client
+// with a 30 second timeout.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithToken authenticates every request with the same token, as returned by
+// POST /v1/tokens/authentication.
+func WithToken(token string) Option {
+	return WithTokenSource(func(context.Context) (string, error) {
+		return token, nil
+	})
+}
+
+// WithTokenSource authenticates requests with a token fetched for each one, for
+// callers that need to refresh tokens.
+func WithTokenSource(source TokenSource) Option {
+	return func(c *Client) {
+		c.token = source
+	}
+}
+
+// WithRetries sets how many times a request is retried after a 429 or 5xx response,
+// and the bounds of the exponential backoff between attempts. The default is 3
+// retries, backing off from 200ms to 10s. Zero retries turns retrying off.
+func WithRetries(maxRetries int, minBackoff, maxBackoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.minBackoff = minBackoff
+		c.maxBackoff = maxBackoff
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every request.
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// New returns a client for the API at baseURL, like "http://localhost:4000".
+func New(baseURL string, options ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("client: invalid base URL: %w", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("client: base URL must be http or https, not %q", baseURL)
+	}
+
+	c := &Client{
+		baseURL:    u,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		userAgent:  "greenlight-go-client",
+		maxRetries: 3,
+		minBackoff: 200 * time.Millisecond,
+		maxBackoff: 10 * time.Second,
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c, nil
+}
+
+// Movies returns the client for the /v1/movies endpoints.
+func (c *Client) Movies() *MoviesService {
+	return &MoviesService{client: c}
+}
+
+// request is an API call. body is encoded as JSON, or raw is sent as it is with
+// contentType. Either way it's sent again on each retry. stream is sent as it is too,
+// but it can only be read once, so a streamed request is sent once and only carries
+// an Idempotency-Key if it's given one.
+type request struct {
+	method         string
+	path           string
+	query          url.Values
+	header         http.Header
+	body           any
+	raw            []byte
+	stream         io.Reader
+	contentType    string
+	idempotencyKey string
+}
+
+// do sends req and decodes a successful response's envelope into out. Error responses
+// are returned as an *Error.
+func (c *Client) do(ctx context.Context, req request, out any) error {
+	res, err := c.roundTrip(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	return c.decode(res, out)
+}
+
+// roundTrip sends req, retrying as configured, and returns the final response whatever
+// its status. The caller must close its body.
+func (c *Client) roundTrip(ctx context.Context, req request) (*http.Response, error) {
+	body, contentType := req.raw, req.contentType
+
+	if req.body != nil {
+		var err error
+
+		body, err = json.Marshal(req.body)
+		if err != nil {
+			return nil, fmt.Errorf("client: encoding request: %w", err)
+		}
+
+		contentType = "application/json"
+	}
+
+	if req.header == nil {
+		req.header = make(http.Header)
+	}
+
+	if contentType != "" {
+		req.header.Set("Content-Type", contentType)
+	}
+
+	// Creates get an Idempotency-Key, so if one is retried after the server handled
+	// it but the response went missing, the server replays it rather than creating
+	// the resource again.
+	if req.idempotencyKey == "" && req.method == http.MethodPost && req.stream == nil {
+		req.idempotencyKey = rand.Text()
+	}
+
+	if req.idempotencyKey != "" {
+		req.header.Set("Idempotency-Key", req.idempotencyKey)
+	}
+
+	for attempt := 0; ; attempt++ {
+		reader := req.stream
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+
+		res, err := c.send(ctx, req, reader)
+		if err != nil {
+			return nil, err
+		}
+
+		if !retryable(res.StatusCode) || attempt >= c.maxRetries || req.stream != nil {
+			return res, nil
+		}
+
+		wait := c.backoff(attempt, res.Header.Get("Retry-After"))
+
+		// The body is drained so the connection can be reused.
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, req request, body io.Reader) (*http.Response, error) {
+	u := c.baseURL.JoinPath(req.path)
+	u.RawQuery = req.query.Encode()
+
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("client: %w", err)
+	}
+
+	for key, values := range req.header {
+		httpReq.Header[key] = values
+	}
+
+	if httpReq.Header.Get("Accept") == "" {
+		httpReq.Header.Set("Accept", "application/json")
+	}
+
+	httpReq.Header.Set("User-Agent", c.userAgent)
+
+	if c.token != nil {
+		token, err := c.token(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("client: getting token: %w", err)
+		}
+
+		if token != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	res, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("client: %w", err)
+	}
+
+	return res, nil
+}
+
+// decode reads a response, filling out from a 2xx or returning an *Error otherwise.
+func (c *Client) decode(res *http.Response, out any) error {
+	defer res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		if out == nil {
+			io.Copy(io.Discard, res.Body)
+			return nil
+		}
+
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			return fmt.Errorf("client: decoding response: %w", err)
+		}
+
+		return nil
+	}
+
+	apiErr := &Error{StatusCode: res.StatusCode}
+
+	var envelope struct {
+		Error json.RawMessage `json:"error"`
+		Lock  *MovieLock      `json:"lock"`
+	}
+
+	// A body that isn't the usual error envelope (from a proxy, say) just leaves the
+	// message empty, and Error falls back to the status text.
+	if err := json.NewDecoder(res.Body).Decode(&envelope); err == nil {
+		if json.Unmarshal(envelope.Error, &apiErr.Message) != nil {
+			json.Unmarshal(envelope.Error, &apiErr.Fields)
+		}
+
+		apiErr.Lock = envelope.Lock
+	}
+
+	return apiErr
+}
+
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// backoff is how long to wait before retry number attempt+1: an exponential backoff
+// with jitter, or the server's Retry-After if that's longer.
+func (c *Client) backoff(attempt int, retryAfter string) time.Duration {
+	wait := min(c.minBackoff<<attempt, c.maxBackoff)
+	if wait > 0 {
+		wait = wait/2 + mathrand.N(wait/2+1)
+	}
+
+	if seconds, err := strconv.Atoi(strings.TrimSpace(retryAfter)); err == nil {
+		wait = max(wait, time.Duration(seconds)*time.Second)
+	} else if at, err := http.ParseTime(retryAfter); err == nil {
+		wait = max(wait, time.Until(at))
+	}
+
+	return wait
+}
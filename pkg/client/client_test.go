@@ -0,0 +1,414 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer answers each request with the next response in turn, repeating the last
+// one once they run out, and keeps every request it got.
+type testServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []string
+}
+
+type testResponse struct {
+	status int
+	header map[string]string
+	body   string
+}
+
+func newTestServer(t *testing.T, responses ...testResponse) *testServer {
+	t.Helper()
+
+	ts := &testServer{}
+
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		ts.mu.Lock()
+		res := responses[min(len(ts.requests), len(responses)-1)]
+		ts.requests = append(ts.requests, r)
+		ts.bodies = append(ts.bodies, string(body))
+		ts.mu.Unlock()
+
+		for name, value := range res.header {
+			w.Header().Set(name, value)
+		}
+
+		w.WriteHeader(res.status)
+		io.WriteString(w, res.body)
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func (ts *testServer) count() int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	return len(ts.requests)
+}
+
+func newTestClient(t *testing.T, ts *testServer, options ...Option) *Client {
+	t.Helper()
+
+	// Short backoffs keep the tests quick; Retry-After tests override them.
+	options = append([]Option{WithRetries(3, time.Millisecond, 5*time.Millisecond), WithToken("test-token")}, options...)
+
+	c, err := New(ts.URL, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+const movieJSONBody = `{"movie": {"id": 1, "title": "Alien", "year": 1979, "runtime": "117 mins", "genres": ["horror"], "version": 1}}`
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []testResponse
+		requests  int
+		status    int
+	}{
+		{
+			name:      "server errors are retried until one succeeds",
+			responses: []testResponse{{status: 503}, {status: 502}, {status: 200, body: movieJSONBody}},
+			requests:  3,
+			status:    200,
+		},
+		{
+			name:      "429 is retried",
+			responses: []testResponse{{status: 429}, {status: 200, body: movieJSONBody}},
+			requests:  2,
+			status:    200,
+		},
+		{
+			name:      "retries run out",
+			responses: []testResponse{{status: 500, body: `{"error": "the server encountered a problem"}`}},
+			requests:  4,
+			status:    500,
+		},
+		{
+			name:      "client errors aren't retried",
+			responses: []testResponse{{status: 404, body: `{"error": "the requested resource could not be found"}`}},
+			requests:  1,
+			status:    404,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, tt.responses...)
+			c := newTestClient(t, ts)
+
+			movie, err := c.Movies().Get(context.Background(), 1)
+
+			if ts.count() != tt.requests {
+				t.Errorf("got %d requests, want %d", ts.count(), tt.requests)
+			}
+
+			if tt.status == 200 {
+				if err != nil || movie.Title != "Alien" {
+					t.Fatalf("got %+v, %v", movie, err)
+				}
+				return
+			}
+
+			var apiErr *Error
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status {
+				t.Errorf("got error %v, want a %d", err, tt.status)
+			}
+		})
+	}
+}
+
+func TestRetriesKeepIdempotencyKey(t *testing.T) {
+	ts := newTestServer(t, testResponse{status: 503}, testResponse{status: 201, body: movieJSONBody})
+	c := newTestClient(t, ts)
+
+	_, err := c.Movies().Create(context.Background(), &Movie{Title: "Alien", Year: 1979, Runtime: 117, Genres: []string{"horror"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := ts.requests[0].Header.Get("Idempotency-Key")
+	if first == "" || ts.requests[1].Header.Get("Idempotency-Key") != first {
+		t.Errorf("got keys %q and %q, want the same key twice", first, ts.requests[1].Header.Get("Idempotency-Key"))
+	}
+
+	if ts.bodies[0] == "" || ts.bodies[1] != ts.bodies[0] {
+		t.Errorf("the retry sent %q, not %q", ts.bodies[1], ts.bodies[0])
+	}
+
+	if ts.requests[0].Header.Get("Authorization") != "Bearer test-token" {
+		t.Errorf("got Authorization %q", ts.requests[0].Header.Get("Authorization"))
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	ts := newTestServer(t,
+		testResponse{status: 503, header: map[string]string{"Retry-After": "1"}},
+		testResponse{status: 200, body: movieJSONBody},
+	)
+	c := newTestClient(t, ts)
+
+	start := time.Now()
+
+	if _, err := c.Movies().Get(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, before the second Retry-After asked for", elapsed)
+	}
+}
+
+func TestRetryAfterCancelled(t *testing.T) {
+	ts := newTestServer(t, testResponse{status: 429, header: map[string]string{"Retry-After": "60"}})
+	c := newTestClient(t, ts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := c.Movies().Get(ctx, 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the context's error", err)
+	}
+
+	if ts.count() != 1 {
+		t.Errorf("got %d requests, want 1", ts.count())
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := &Client{minBackoff: 100 * time.Millisecond, maxBackoff: time.Second}
+
+	tests := []struct {
+		name       string
+		attempt    int
+		retryAfter string
+		min, max   time.Duration
+	}{
+		{"first retry", 0, "", 50 * time.Millisecond, 100 * time.Millisecond},
+		{"third retry", 2, "", 200 * time.Millisecond, 400 * time.Millisecond},
+		{"capped", 10, "", 500 * time.Millisecond, time.Second},
+		{"Retry-After seconds", 0, "3", 3 * time.Second, 3 * time.Second},
+		{"shorter Retry-After", 2, "0", 200 * time.Millisecond, 400 * time.Millisecond},
+		{"Retry-After date", 0, time.Now().Add(5 * time.Second).UTC().Format(http.TimeFormat), 3 * time.Second, 5 * time.Second},
+		{"unparseable Retry-After", 0, "soon", 50 * time.Millisecond, 100 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		got := c.backoff(tt.attempt, tt.retryAfter)
+		if got < tt.min || got > tt.max {
+			t.Errorf("%s: got %v, want between %v and %v", tt.name, got, tt.min, tt.max)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		response testResponse
+		is       error
+		message  string
+		check    func(t *testing.T, err *Error)
+	}{
+		{
+			name:     "not found",
+			response: testResponse{status: 404, body: `{"error": "the requested resource could not be found"}`},
+			is:       ErrRecordNotFound,
+			message:  "greenlight: 404: the requested resource could not be found",
+		},
+		{
+			name:     "edit conflict",
+			response: testResponse{status: 409, body: `{"error": "unable to update the record due to an edit conflict, please try again"}`},
+			is:       ErrEditConflict,
+		},
+		{
+			name:     "stale If-Match",
+			response: testResponse{status: 412, body: `{"error": "the movie has changed since it was read"}`},
+			is:       ErrEditConflict,
+		},
+		{
+			name:     "failed validation",
+			response: testResponse{status: 422, body: `{"error": {"year": "must be provided", "title": "must be provided"}}`},
+			is:       ErrFailedValidation,
+			message:  "greenlight: 422: title must be provided; year must be provided",
+			check: func(t *testing.T, err *Error) {
+				if err.Fields["year"] != "must be provided" {
+					t.Errorf("got fields %v", err.Fields)
+				}
+			},
+		},
+		{
+			name:     "locked",
+			response: testResponse{status: 423, body: `{"error": "this movie is locked for editing by Ann", "lock": {"movie_id": 1, "user_name": "Ann", "expires_at": "2030-01-01T00:00:00Z"}}`},
+			is:       ErrMovieLocked,
+			check: func(t *testing.T, err *Error) {
+				if err.Lock == nil || err.Lock.UserName != "Ann" {
+					t.Errorf("got lock %+v", err.Lock)
+				}
+			},
+		},
+		{
+			name:     "unauthorized",
+			response: testResponse{status: 401, body: `{"error": "invalid or missing authentication token"}`},
+			is:       ErrUnauthorized,
+		},
+		{
+			name:     "forbidden",
+			response: testResponse{status: 403, body: `{"error": "your user account doesn't have the necessary permissions to access this resource"}`},
+			is:       ErrForbidden,
+		},
+		{
+			name:     "a body that isn't an error envelope",
+			response: testResponse{status: 400, header: map[string]string{"Content-Type": "text/html"}, body: `<h1>Bad Request</h1>`},
+			message:  "greenlight: 400: bad request",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, tt.response)
+			c := newTestClient(t, ts)
+
+			_, err := c.Movies().Get(context.Background(), 1)
+
+			var apiErr *Error
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.response.status {
+				t.Fatalf("got %v, want an *Error with status %d", err, tt.response.status)
+			}
+
+			if tt.is != nil && !errors.Is(err, tt.is) {
+				t.Errorf("%v doesn't match %v", err, tt.is)
+			}
+
+			if tt.message != "" && err.Error() != tt.message {
+				t.Errorf("got message %q, want %q", err.Error(), tt.message)
+			}
+
+			if tt.check != nil {
+				tt.check(t, apiErr)
+			}
+		})
+	}
+}
+
+func TestImportStreams(t *testing.T) {
+	const file = "title,year,runtime,genres\nAlien,1979,117 mins,horror\n"
+
+	var (
+		form      string
+		key       string
+		length    int64
+		callCount int
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callCount++
+		key = r.Header.Get("Idempotency-Key")
+		length = r.ContentLength
+
+		mr, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		part, err := mr.NextPart()
+		if err != nil || part.FormName() != "file" || part.FileName() != "movies.csv" || part.Header.Get("Content-Type") != "text/csv" {
+			http.Error(w, "bad file part", http.StatusBadRequest)
+			return
+		}
+
+		body, _ := io.ReadAll(part)
+		form = string(body)
+
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, WithRetries(3, time.Millisecond, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Movies().Import(context.Background(), strings.NewReader(file), ImportMoviesOptions{Format: FormatCSV})
+
+	if !errors.As(err, new(*Error)) || err.(*Error).StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got %v, want the 503", err)
+	}
+
+	if form != file {
+		t.Errorf("the server got %q", form)
+	}
+
+	// The file is streamed, so its length isn't known up front, and it can't be sent
+	// again.
+	if length != -1 {
+		t.Errorf("got Content-Length %d, want a chunked body", length)
+	}
+
+	if callCount != 1 {
+		t.Errorf("got %d requests, want 1", callCount)
+	}
+
+	if key != "" {
+		t.Errorf("got Idempotency-Key %q, want none", key)
+	}
+
+	_, err = c.Movies().Import(context.Background(), strings.NewReader(file), ImportMoviesOptions{Format: FormatCSV, IdempotencyKey: "import-1"})
+	if err == nil || key != "import-1" {
+		t.Errorf("got Idempotency-Key %q, want the caller's", key)
+	}
+}
+
+// A server that answers before reading the whole upload must not leave the goroutine
+// writing the form stuck.
+func TestImportAnsweredEarly(t *testing.T) {
+	ts := newTestServer(t, testResponse{status: 401, body: `{"error": "invalid or missing authentication token"}`})
+	c := newTestClient(t, ts)
+
+	r, w := io.Pipe()
+	defer w.Close()
+
+	go func() {
+		// Far more than the server or the connection buffers will take.
+		for range 1000 {
+			if _, err := w.Write(make([]byte, 64*1024)); err != nil {
+				return
+			}
+		}
+		w.Close()
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Movies().Import(context.Background(), r, ImportMoviesOptions{})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("the import succeeded")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Import didn't return")
+	}
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/captainmango/greenlight/internal/data"
+)
+
+// The sentinel errors are the same values the API's own data layer uses, so
+// errors.Is works the same way on both sides.
+var (
+	ErrRecordNotFound = data.ErrRecordNotFound
+	ErrEditConflict   = data.ErrEditConflict
+	ErrMovieLocked    = data.ErrMovieLocked
+
+	ErrFailedValidation = errors.New("failed validation")
+	ErrUnauthorized     = errors.New("unauthorized")
+	ErrForbidden        = errors.New("forbidden")
+)
+
+// Error is an error response from the API. It unwraps to the sentinel error for its
+// status, so callers can use errors.Is(err, client.ErrRecordNotFound) and so on, or
+// errors.As to get at the details.
+type Error struct {
+	StatusCode int
+	// Message is the error message, for every error but a validation failure.
+	Message string
+	// Fields maps each invalid field to what's wrong with it, for a validation failure.
+	Fields ValidationErrors
+	// Lock is the lock held by someone else, when the movie is locked.
+	Lock *MovieLock
+}
+
+func (e *Error) Error() string {
+	switch {
+	case len(e.Fields) > 0:
+		fields := make([]string, 0, len(e.Fields))
+		for field, message := range e.Fields {
+			fields = append(fields, fmt.Sprintf("%s %s", field, message))
+		}
+		slices.Sort(fields)
+
+		return fmt.Sprintf("greenlight: %d: %s", e.StatusCode, strings.Join(fields, "; "))
+	case e.Message != "":
+		return fmt.Sprintf("greenlight: %d: %s", e.StatusCode, e.Message)
+	default:
+		return fmt.Sprintf("greenlight: %d: %s", e.StatusCode, strings.ToLower(http.StatusText(e.StatusCode)))
+	}
+}
+
+func (e *Error) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return ErrRecordNotFound
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return ErrEditConflict
+	case http.StatusLocked:
+		return ErrMovieLocked
+	case http.StatusUnprocessableEntity:
+		if len(e.Fields) > 0 {
+			return ErrFailedValidation
+		}
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden:
+		return ErrForbidden
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/captainmango/greenlight/internal/data"
+)
+
+// MoviesService calls the /v1/movies endpoints.
+type MoviesService struct {
+	client *Client
+}
+
+// ListMoviesOptions filters and pages GET /v1/movies. Zero values use the API's
+// defaults. Without Page or PageSize the API doesn't page at all, and returns every
+// matching movie.
+type ListMoviesOptions struct {
+	Title  string
+	Genres []string
+	Page   int
+	// PageSize is at most 100.
+	PageSize int
+	// Sort is a field name, with "-" in front for descending, like "-year".
+	Sort           string
+	IncludeCredits bool
+}
+
+func (o ListMoviesOptions) query() url.Values {
+	qs := make(url.Values)
+
+	if o.Title != "" {
+		qs.Set("title", o.Title)
+	}
+	if len(o.Genres) > 0 {
+		qs.Set("genres", strings.Join(o.Genres, ","))
+	}
+	if o.Page > 0 {
+		qs.Set("page", strconv.Itoa(o.Page))
+	}
+	if o.PageSize > 0 {
+		qs.Set("page_size", strconv.Itoa(o.PageSize))
+	}
+	if o.Sort != "" {
+		qs.Set("sort", o.Sort)
+	}
+	if o.IncludeCredits {
+		qs.Set("include", "credits")
+	}
+
+	return qs
+}
+
+// List returns a page of movies, and the metadata needed to fetch the others. Unpaged
+// lists have empty metadata.
+func (s *MoviesService) List(ctx context.Context, options ListMoviesOptions) ([]Movie, Metadata, error) {
+	var out struct {
+		Movies   []Movie  `json:"movies"`
+		Metadata Metadata `json:"metadata"`
+	}
+
+	err := s.client.do(ctx, request{method: http.MethodGet, path: "/v1/movies", query: options.query()}, &out)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return out.Movies, out.Metadata, nil
+}
+
+// Get returns a movie. A movie that doesn't exist (or is in the trash) gives an error
+// matching ErrRecordNotFound.
+func (s *MoviesService) Get(ctx context.Context, id int64) (*Movie, error) {
+	var out struct {
+		Movie *Movie `json:"movie"`
+	}
+
+	err := s.client.do(ctx, request{method: http.MethodGet, path: moviePath(id)}, &out)
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Movie, nil
+}
+
+// Create adds a movie. Only the title, year, runtime and genres are sent; the created
+// movie is returned with its ID and version.
+func (s *MoviesService) Create(ctx context.Context, movie *Movie) (*Movie, error) {
+	var out struct {
+		Movie *Movie `json:"movie"`
+	}
+
+	err := s.client.do(ctx, request{method: http.MethodPost, path: "/v1/movies", body: movieJSON(movie)}, &out)
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Movie, nil
+}
+
+// Update replaces the title, year, runtime and genres of a movie. movie.Version must
+// be the version it was read at: it is sent as If-Match, and if the movie has changed
+// since, the error matches ErrEditConflict.
+func (s *MoviesService) Update(ctx context.Context, movie *Movie) (*Movie, error) {
+	if movie.ID < 1 || movie.Version < 1 {
+		return nil, errors.New("client: updating a movie needs its ID and version; use Create for new movies")
+	}
+
+	header := make(http.Header)
+	header.Set("If-Match", strconv.Quote(strconv.Itoa(int(movie.Version))))
+
+	var out struct {
+		Movie *Movie `json:"movie"`
+	}
+
+	err := s.client.do(ctx, request{method: http.MethodPut, path: moviePath(movie.ID), header: header, body: movieJSON(movie)}, &out)
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Movie, nil
+}
+
+// Delete moves a movie to the trash.
+func (s *MoviesService) Delete(ctx context.Context, id int64) error {
+	return s.client.do(ctx, request{method: http.MethodDelete, path: moviePath(id)}, nil)
+}
+
+func moviePath(id int64) string {
+	return fmt.Sprintf("/v1/movies/%d", id)
+}
+
+// movieJSON picks out the fields the API accepts, since it rejects unknown ones.
+func movieJSON(movie *Movie) data.MovieJSON {
+	return data.MovieJSON{
+		Title:   movie.Title,
+		Year:    movie.Year,
+		Runtime: movie.Runtime,
+		Genres:  movie.Genres,
+	}
+}
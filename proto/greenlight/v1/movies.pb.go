@@ -0,0 +1,517 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: greenlight/v1/movies.proto
+
+package greenlightv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	fieldmaskpb "google.golang.org/protobuf/types/known/fieldmaskpb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Movie struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Id             int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Title          string                 `protobuf:"bytes,2,opt,name=title,proto3" json:"title,omitempty"`
+	Year           int32                  `protobuf:"varint,3,opt,name=year,proto3" json:"year,omitempty"`
+	RuntimeMinutes int32                  `protobuf:"varint,4,opt,name=runtime_minutes,json=runtimeMinutes,proto3" json:"runtime_minutes,omitempty"`
+	Genres         []string               `protobuf:"bytes,5,rep,name=genres,proto3" json:"genres,omitempty"`
+	Version        int32                  `protobuf:"varint,6,opt,name=version,proto3" json:"version,omitempty"`
+	AverageRating  float64                `protobuf:"fixed64,7,opt,name=average_rating,json=averageRating,proto3" json:"average_rating,omitempty"`
+	RatingCount    int32                  `protobuf:"varint,8,opt,name=rating_count,json=ratingCount,proto3" json:"rating_count,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Movie) Reset() {
+	*x = Movie{}
+	mi := &file_greenlight_v1_movies_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Movie) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Movie) ProtoMessage() {}
+
+func (x *Movie) ProtoReflect() protoreflect.Message {
+	mi := &file_greenlight_v1_movies_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Movie.ProtoReflect.Descriptor instead.
+func (*Movie) Descriptor() ([]byte, []int) {
+	return file_greenlight_v1_movies_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Movie) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Movie) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *Movie) GetYear() int32 {
+	if x != nil {
+		return x.Year
+	}
+	return 0
+}
+
+func (x *Movie) GetRuntimeMinutes() int32 {
+	if x != nil {
+		return x.RuntimeMinutes
+	}
+	return 0
+}
+
+func (x *Movie) GetGenres() []string {
+	if x != nil {
+		return x.Genres
+	}
+	return nil
+}
+
+func (x *Movie) GetVersion() int32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *Movie) GetAverageRating() float64 {
+	if x != nil {
+		return x.AverageRating
+	}
+	return 0
+}
+
+func (x *Movie) GetRatingCount() int32 {
+	if x != nil {
+		return x.RatingCount
+	}
+	return 0
+}
+
+type GetMovieRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetMovieRequest) Reset() {
+	*x = GetMovieRequest{}
+	mi := &file_greenlight_v1_movies_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetMovieRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetMovieRequest) ProtoMessage() {}
+
+func (x *GetMovieRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_greenlight_v1_movies_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetMovieRequest.ProtoReflect.Descriptor instead.
+func (*GetMovieRequest) Descriptor() ([]byte, []int) {
+	return file_greenlight_v1_movies_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetMovieRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type ListMoviesRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// title and genres filter like GET /v1/movies: every word of the title and every
+	// genre must match.
+	Title  string   `protobuf:"bytes,1,opt,name=title,proto3" json:"title,omitempty"`
+	Genres []string `protobuf:"bytes,2,rep,name=genres,proto3" json:"genres,omitempty"`
+	// sort is a field name from GET /v1/movies, with "-" in front for descending. It
+	// defaults to "id".
+	Sort          string `protobuf:"bytes,3,opt,name=sort,proto3" json:"sort,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListMoviesRequest) Reset() {
+	*x = ListMoviesRequest{}
+	mi := &file_greenlight_v1_movies_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListMoviesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListMoviesRequest) ProtoMessage() {}
+
+func (x *ListMoviesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_greenlight_v1_movies_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListMoviesRequest.ProtoReflect.Descriptor instead.
+func (*ListMoviesRequest) Descriptor() ([]byte, []int) {
+	return file_greenlight_v1_movies_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListMoviesRequest) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *ListMoviesRequest) GetGenres() []string {
+	if x != nil {
+		return x.Genres
+	}
+	return nil
+}
+
+func (x *ListMoviesRequest) GetSort() string {
+	if x != nil {
+		return x.Sort
+	}
+	return ""
+}
+
+type CreateMovieRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// id, version and the rating fields are ignored.
+	Movie         *Movie `protobuf:"bytes,1,opt,name=movie,proto3" json:"movie,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateMovieRequest) Reset() {
+	*x = CreateMovieRequest{}
+	mi := &file_greenlight_v1_movies_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateMovieRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateMovieRequest) ProtoMessage() {}
+
+func (x *CreateMovieRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_greenlight_v1_movies_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateMovieRequest.ProtoReflect.Descriptor instead.
+func (*CreateMovieRequest) Descriptor() ([]byte, []int) {
+	return file_greenlight_v1_movies_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *CreateMovieRequest) GetMovie() *Movie {
+	if x != nil {
+		return x.Movie
+	}
+	return nil
+}
+
+type UpdateMovieRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// movie.id picks the movie. If movie.version is set it must match the current
+	// version, or the call fails with ABORTED.
+	Movie *Movie `protobuf:"bytes,1,opt,name=movie,proto3" json:"movie,omitempty"`
+	// update_mask lists the fields to change: title, year, runtime_minutes and genres.
+	// If it's empty, all of them are replaced.
+	UpdateMask    *fieldmaskpb.FieldMask `protobuf:"bytes,2,opt,name=update_mask,json=updateMask,proto3" json:"update_mask,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateMovieRequest) Reset() {
+	*x = UpdateMovieRequest{}
+	mi := &file_greenlight_v1_movies_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateMovieRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateMovieRequest) ProtoMessage() {}
+
+func (x *UpdateMovieRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_greenlight_v1_movies_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateMovieRequest.ProtoReflect.Descriptor instead.
+func (*UpdateMovieRequest) Descriptor() ([]byte, []int) {
+	return file_greenlight_v1_movies_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *UpdateMovieRequest) GetMovie() *Movie {
+	if x != nil {
+		return x.Movie
+	}
+	return nil
+}
+
+func (x *UpdateMovieRequest) GetUpdateMask() *fieldmaskpb.FieldMask {
+	if x != nil {
+		return x.UpdateMask
+	}
+	return nil
+}
+
+type DeleteMovieRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteMovieRequest) Reset() {
+	*x = DeleteMovieRequest{}
+	mi := &file_greenlight_v1_movies_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteMovieRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteMovieRequest) ProtoMessage() {}
+
+func (x *DeleteMovieRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_greenlight_v1_movies_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteMovieRequest.ProtoReflect.Descriptor instead.
+func (*DeleteMovieRequest) Descriptor() ([]byte, []int) {
+	return file_greenlight_v1_movies_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *DeleteMovieRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type DeleteMovieResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteMovieResponse) Reset() {
+	*x = DeleteMovieResponse{}
+	mi := &file_greenlight_v1_movies_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteMovieResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteMovieResponse) ProtoMessage() {}
+
+func (x *DeleteMovieResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_greenlight_v1_movies_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteMovieResponse.ProtoReflect.Descriptor instead.
+func (*DeleteMovieResponse) Descriptor() ([]byte, []int) {
+	return file_greenlight_v1_movies_proto_rawDescGZIP(), []int{6}
+}
+
+var File_greenlight_v1_movies_proto protoreflect.FileDescriptor
+
+const file_greenlight_v1_movies_proto_rawDesc = "" +
+	"\n" +
+	"\x1agreenlight/v1/movies.proto\x12\rgreenlight.v1\x1a google/protobuf/field_mask.proto\"\xe6\x01\n" +
+	"\x05Movie\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x14\n" +
+	"\x05title\x18\x02 \x01(\tR\x05title\x12\x12\n" +
+	"\x04year\x18\x03 \x01(\x05R\x04year\x12'\n" +
+	"\x0fruntime_minutes\x18\x04 \x01(\x05R\x0eruntimeMinutes\x12\x16\n" +
+	"\x06genres\x18\x05 \x03(\tR\x06genres\x12\x18\n" +
+	"\aversion\x18\x06 \x01(\x05R\aversion\x12%\n" +
+	"\x0eaverage_rating\x18\a \x01(\x01R\raverageRating\x12!\n" +
+	"\frating_count\x18\b \x01(\x05R\vratingCount\"!\n" +
+	"\x0fGetMovieRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\"U\n" +
+	"\x11ListMoviesRequest\x12\x14\n" +
+	"\x05title\x18\x01 \x01(\tR\x05title\x12\x16\n" +
+	"\x06genres\x18\x02 \x03(\tR\x06genres\x12\x12\n" +
+	"\x04sort\x18\x03 \x01(\tR\x04sort\"@\n" +
+	"\x12CreateMovieRequest\x12*\n" +
+	"\x05movie\x18\x01 \x01(\v2\x14.greenlight.v1.MovieR\x05movie\"}\n" +
+	"\x12UpdateMovieRequest\x12*\n" +
+	"\x05movie\x18\x01 \x01(\v2\x14.greenlight.v1.MovieR\x05movie\x12;\n" +
+	"\vupdate_mask\x18\x02 \x01(\v2\x1a.google.protobuf.FieldMaskR\n" +
+	"updateMask\"$\n" +
+	"\x12DeleteMovieRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\"\x15\n" +
+	"\x13DeleteMovieResponse2\xfe\x02\n" +
+	"\fMovieService\x12@\n" +
+	"\bGetMovie\x12\x1e.greenlight.v1.GetMovieRequest\x1a\x14.greenlight.v1.Movie\x12F\n" +
+	"\n" +
+	"ListMovies\x12 .greenlight.v1.ListMoviesRequest\x1a\x14.greenlight.v1.Movie0\x01\x12F\n" +
+	"\vCreateMovie\x12!.greenlight.v1.CreateMovieRequest\x1a\x14.greenlight.v1.Movie\x12F\n" +
+	"\vUpdateMovie\x12!.greenlight.v1.UpdateMovieRequest\x1a\x14.greenlight.v1.Movie\x12T\n" +
+	"\vDeleteMovie\x12!.greenlight.v1.DeleteMovieRequest\x1a\".greenlight.v1.DeleteMovieResponseBEZCgithub.com/captainmango/greenlight/proto/greenlight/v1;greenlightv1b\x06proto3"
+
+var (
+	file_greenlight_v1_movies_proto_rawDescOnce sync.Once
+	file_greenlight_v1_movies_proto_rawDescData []byte
+)
+
+func file_greenlight_v1_movies_proto_rawDescGZIP() []byte {
+	file_greenlight_v1_movies_proto_rawDescOnce.Do(func() {
+		file_greenlight_v1_movies_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_greenlight_v1_movies_proto_rawDesc), len(file_greenlight_v1_movies_proto_rawDesc)))
+	})
+	return file_greenlight_v1_movies_proto_rawDescData
+}
+
+var file_greenlight_v1_movies_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_greenlight_v1_movies_proto_goTypes = []any{
+	(*Movie)(nil),                 // 0: greenlight.v1.Movie
+	(*GetMovieRequest)(nil),       // 1: greenlight.v1.GetMovieRequest
+	(*ListMoviesRequest)(nil),     // 2: greenlight.v1.ListMoviesRequest
+	(*CreateMovieRequest)(nil),    // 3: greenlight.v1.CreateMovieRequest
+	(*UpdateMovieRequest)(nil),    // 4: greenlight.v1.UpdateMovieRequest
+	(*DeleteMovieRequest)(nil),    // 5: greenlight.v1.DeleteMovieRequest
+	(*DeleteMovieResponse)(nil),   // 6: greenlight.v1.DeleteMovieResponse
+	(*fieldmaskpb.FieldMask)(nil), // 7: google.protobuf.FieldMask
+}
+var file_greenlight_v1_movies_proto_depIdxs = []int32{
+	0, // 0: greenlight.v1.CreateMovieRequest.movie:type_name -> greenlight.v1.Movie
+	0, // 1: greenlight.v1.UpdateMovieRequest.movie:type_name -> greenlight.v1.Movie
+	7, // 2: greenlight.v1.UpdateMovieRequest.update_mask:type_name -> google.protobuf.FieldMask
+	1, // 3: greenlight.v1.MovieService.GetMovie:input_type -> greenlight.v1.GetMovieRequest
+	2, // 4: greenlight.v1.MovieService.ListMovies:input_type -> greenlight.v1.ListMoviesRequest
+	3, // 5: greenlight.v1.MovieService.CreateMovie:input_type -> greenlight.v1.CreateMovieRequest
+	4, // 6: greenlight.v1.MovieService.UpdateMovie:input_type -> greenlight.v1.UpdateMovieRequest
+	5, // 7: greenlight.v1.MovieService.DeleteMovie:input_type -> greenlight.v1.DeleteMovieRequest
+	0, // 8: greenlight.v1.MovieService.GetMovie:output_type -> greenlight.v1.Movie
+	0, // 9: greenlight.v1.MovieService.ListMovies:output_type -> greenlight.v1.Movie
+	0, // 10: greenlight.v1.MovieService.CreateMovie:output_type -> greenlight.v1.Movie
+	0, // 11: greenlight.v1.MovieService.UpdateMovie:output_type -> greenlight.v1.Movie
+	6, // 12: greenlight.v1.MovieService.DeleteMovie:output_type -> greenlight.v1.DeleteMovieResponse
+	8, // [8:13] is the sub-list for method output_type
+	3, // [3:8] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_greenlight_v1_movies_proto_init() }
+func file_greenlight_v1_movies_proto_init() {
+	if File_greenlight_v1_movies_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_greenlight_v1_movies_proto_rawDesc), len(file_greenlight_v1_movies_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_greenlight_v1_movies_proto_goTypes,
+		DependencyIndexes: file_greenlight_v1_movies_proto_depIdxs,
+		MessageInfos:      file_greenlight_v1_movies_proto_msgTypes,
+	}.Build()
+	File_greenlight_v1_movies_proto = out.File
+	file_greenlight_v1_movies_proto_goTypes = nil
+	file_greenlight_v1_movies_proto_depIdxs = nil
+}